@@ -5,11 +5,16 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/urfave/cli/v2"
 	"github.com/yeboahd24/dbBackupUitility/pkg/backup"
 	"github.com/yeboahd24/dbBackupUitility/pkg/config"
+	"github.com/yeboahd24/dbBackupUitility/pkg/notification"
+	"github.com/yeboahd24/dbBackupUitility/pkg/progress"
+	"github.com/yeboahd24/dbBackupUitility/pkg/retry"
+	"github.com/yeboahd24/dbBackupUitility/pkg/storage"
 )
 
 func BackupCommand() *cli.Command {
@@ -34,65 +39,293 @@ func BackupCommand() *cli.Command {
 				Aliases: []string{"o"},
 				Usage:   "Output file path (required when storage is disabled)",
 			},
+			&cli.BoolFlag{
+				Name:  "overwrite",
+				Usage: "Replace an existing backup of the same name in local storage",
+			},
+			&cli.StringFlag{
+				Name:  "storage-class",
+				Usage: "Override the S3 storage class for this backup (e.g. STANDARD_IA, GLACIER_IR)",
+			},
+			&cli.StringSliceFlag{
+				Name:  "tag",
+				Usage: "Add an S3 object tag for this backup as key=value (repeatable)",
+			},
+			&cli.StringFlag{
+				Name:  "retain-until",
+				Usage: "Override the S3 Object Lock retention date for this backup (YYYY-MM-DD)",
+			},
+			&cli.StringFlag{
+				Name:  "upload-rate",
+				Usage: "Limit the combined upload rate of this run, e.g. 10MB/s (overrides upload_rate)",
+			},
+			&cli.BoolFlag{
+				Name:  "no-progress",
+				Usage: "Do not report progress",
+			},
+			&cli.DurationFlag{
+				Name:  "progress-interval",
+				Usage: "Time between progress lines when not running in a terminal",
+				Value: progress.DefaultInterval,
+			},
+			&cli.BoolFlag{
+				Name:  "skip-preflight",
+				Usage: "Do not run the pre-flight checks",
+			},
 		},
 		Action: func(c *cli.Context) error {
 			ctx := context.Background()
-			cfg, err := config.LoadConfig(c.String("config"))
+			cfg, err := loadConfig(c)
 			if err != nil {
 				return fmt.Errorf("failed to load config: %w", err)
 			}
 
-			// Initialize database backuper
-			backuper := backup.NewPostgresBackup(cfg.Database)
-			if err := backuper.Connect(ctx); err != nil {
-				return err
-			}
-			defer backuper.Close()
-
-			// Perform backup
-			backupType := backup.BackupType(c.String("type"))
-			reader, err := backuper.Backup(ctx, backupType)
+			policy, err := retryPolicy(cfg.Retry)
 			if err != nil {
 				return err
 			}
 
-			// Handle backup storage
-			if cfg.Storage.Enabled {
-				// Initialize remote storage
-				storage, err := initializeStorage(cfg.Storage)
-				if err != nil {
-					return err
-				}
-
-				// Store in remote storage
-				filename := fmt.Sprintf("backup_%s_%s.dump",
-					cfg.Database.Database,
-					time.Now().Format("20060102150405"))
-
-				return storage.Store(ctx, filename, reader)
-			} else {
-				// Store locally if output path is provided
-				outputPath := c.String("output")
-				if outputPath == "" {
-					return fmt.Errorf("output path is required when storage is disabled")
-				}
-
-				// Create output file
-				file, err := os.Create(outputPath)
-				if err != nil {
-					return fmt.Errorf("failed to create output file: %w", err)
-				}
-				defer file.Close()
-
-				// Copy backup data to file
-				_, err = io.Copy(file, reader)
-				if err != nil {
-					return fmt.Errorf("failed to write backup to file: %w", err)
-				}
-
-				fmt.Printf("Backup saved to: %s\n", outputPath)
-				return nil
+			err = runBackup(ctx, c, cfg, policy)
+			if cfg.Notification.Enabled {
+				notifyResult(ctx, cfg, policy, err)
 			}
+			return err
 		},
 	}
 }
+
+// runBackup dumps the database and writes the backup to every configured
+// storage, or to the output file when storage is disabled
+func runBackup(ctx context.Context, c *cli.Context, cfg *config.Config, policy retry.Policy) error {
+	// Compressed dumps defeat chunk deduplication, so repositories
+	// get uncompressed dumps unless a level is configured
+	for _, storageCfg := range cfg.StorageTargets() {
+		if storageCfg.Mode == "repository" && cfg.Database.DumpCompression == nil {
+			level := 0
+			cfg.Database.DumpCompression = &level
+		}
+	}
+
+	// Initialize database backuper, on a replica when configured
+	backuper, node, err := connectDumpNode(ctx, cfg.Database, policy)
+	if err != nil {
+		return err
+	}
+	defer backuper.Close()
+	if node.Role == "replica" {
+		fmt.Printf("Dumping from replica %s, %.1fs behind the primary\n", node.Address, *node.LagSeconds)
+	}
+
+	// Perform backup
+	estimate := estimateSize(ctx, backuper)
+	if !c.Bool("skip-preflight") && !cfg.Preflight.Disabled {
+		err := backupPreflight(ctx, cfg, backuper, cfg.StorageTargets(), policy, c.String("output"), estimate)
+		if err != nil {
+			return err
+		}
+	}
+
+	backupType := backup.BackupType(c.String("type"))
+	createdAt := time.Now()
+	dump, err := backuper.Backup(ctx, backupType)
+	if err != nil {
+		return err
+	}
+	if closer, ok := dump.(io.Closer); ok {
+		defer closer.Close()
+	}
+	var tool *backup.ToolInfo
+	if reporter, ok := backuper.(backup.ToolReporter); ok {
+		if tool = reporter.DumpTool(); tool != nil {
+			fmt.Printf("Dumping with %s %s (%s)\n", tool.Name, tool.Version, tool.Path)
+		}
+	}
+
+	prog := progress.New("Backup", estimate, progressOptions(c))
+	prog.Start()
+	defer prog.Finish()
+	reader := prog.Reader(dump)
+
+	// Handle backup storage
+	if storageCfgs := cfg.StorageTargets(); len(storageCfgs) > 0 {
+		overrides, err := storageOverrides(c)
+		if err != nil {
+			return err
+		}
+		for i := range storageCfgs {
+			overrides.Apply(&storageCfgs[i])
+		}
+
+		filename, err := backup.ExpandName(cfg.NamingTemplate, backup.NameVars(
+			cfg.Database.Database, cfg.Database.Type, backupType, createdAt, cfg.Labels))
+		if err != nil {
+			return err
+		}
+		manifest := &backup.Manifest{
+			Name:         filename,
+			Database:     cfg.Database.Database,
+			DatabaseType: cfg.Database.Type,
+			BackupType:   backupType,
+			CreatedAt:    createdAt,
+			Tool:         tool,
+			Node:         node,
+		}
+
+		limiter, err := uploadLimiter(c, cfg)
+		if err != nil {
+			return err
+		}
+
+		// Spooled backups are written to local disk first and uploaded from there
+		if cfg.Spool.Path != "" {
+			return spoolBackup(ctx, cfg, policy, limiter, reader, manifest, overrides)
+		}
+
+		// Initialize every storage destination, counting what each one
+		// uploads, which volumes and repositories make differ from the dump
+		uploaded := make([]*progress.Counter, len(storageCfgs))
+		for i, storageCfg := range storageCfgs {
+			label := storageCfg.StorageName() + " uploaded"
+			if storageCfg.Mode == "repository" {
+				label += " (compressed)"
+			}
+			uploaded[i] = progress.NewCounter(label)
+		}
+		prog.Track(uploaded...)
+		targets, err := initializeTargets(storageCfgs, policy, limiter, uploaded)
+		if err != nil {
+			return err
+		}
+
+		// Stream the backup to all destinations at once
+		digest := backup.NewDigest(reader)
+		results := storage.StoreAll(ctx, targets, filename, digest)
+
+		manifest.Size = digest.Size()
+		manifest.SHA256 = digest.Sum()
+		for i, r := range results {
+			dest := backup.DestinationResult{Name: r.Name, OK: r.Err == nil}
+			if volumes, ok := targets[i].Provider.(*storage.VolumeStorage); ok && r.Err == nil {
+				dest.Parts = volumes.Parts(filename)
+			}
+			if r.Err != nil {
+				dest.Error = r.Err.Error()
+				progress.Printf("Failed to store backup on %s: %v\n", r.Name, r.Err)
+			}
+			manifest.Destinations = append(manifest.Destinations, dest)
+		}
+
+		// Every destination holding the backup gets the full manifest,
+		// including the outcome on the other destinations
+		for i, r := range results {
+			if r.Err != nil {
+				continue
+			}
+			printVolumeWarnings(targets[i].Provider, filename)
+			if err := backup.WriteManifest(ctx, targets[i].Provider, manifest); err != nil {
+				progress.Printf("Warning: %s: %v\n", r.Name, err)
+				continue
+			}
+			progress.Printf("Backup saved to %s: %s, %s uploaded\n", r.Name, filename, progress.FormatSize(uploaded[i].Load()))
+		}
+
+		return storage.CheckPolicy(cfg.StoragePolicy, results)
+	} else {
+		// Store locally if output path is provided
+		outputPath := c.String("output")
+		if outputPath == "" {
+			return fmt.Errorf("output path is required when storage is disabled")
+		}
+
+		// Create output file
+		file, err := os.Create(outputPath)
+		if err != nil {
+			return fmt.Errorf("failed to create output file: %w", err)
+		}
+		defer file.Close()
+
+		// Copy backup data to file
+		_, err = io.Copy(file, reader)
+		if err != nil {
+			return fmt.Errorf("failed to write backup to file: %w", err)
+		}
+
+		progress.Printf("Backup saved to: %s\n", outputPath)
+		return nil
+	}
+}
+
+// printVolumeWarnings prints the problems a storage splitting backups into
+// volumes met cleaning up after storing the named backup
+func printVolumeWarnings(store backup.StorageProvider, name string) {
+	volumes, ok := store.(*storage.VolumeStorage)
+	if !ok {
+		return
+	}
+	for _, err := range volumes.Warnings(name) {
+		progress.Printf("Warning: %v\n", err)
+	}
+}
+
+// estimateSize returns the estimated size of the backup, or 0 when the
+// backuper cannot estimate it
+func estimateSize(ctx context.Context, backuper backup.DatabaseBackuper) int64 {
+	estimator, ok := backuper.(backup.SizeEstimator)
+	if !ok {
+		return 0
+	}
+	size, err := estimator.EstimateSize(ctx)
+	if err != nil {
+		return 0
+	}
+	return size
+}
+
+// progressOptions returns the progress settings from the command line
+func progressOptions(c *cli.Context) progress.Options {
+	return progress.Options{
+		Disabled: c.Bool("no-progress"),
+		Interval: c.Duration("progress-interval"),
+	}
+}
+
+// notifyResult reports the outcome of a backup
+func notifyResult(ctx context.Context, cfg *config.Config, policy retry.Policy, backupErr error) {
+	message := fmt.Sprintf("Backup of %s completed successfully", cfg.Database.Database)
+	if backupErr != nil {
+		message = fmt.Sprintf("Backup of %s failed: %v", cfg.Database.Database, backupErr)
+	}
+
+	notify(ctx, cfg, policy, message)
+}
+
+// notify sends a notification, retrying failed deliveries
+func notify(ctx context.Context, cfg *config.Config, policy retry.Policy, message string) {
+	notifier := notification.NewSlackNotifier(cfg.Notification.SlackWebhook)
+	err := retry.Do(ctx, policy, func() error {
+		return notifier.Notify(message)
+	})
+	if err != nil {
+		progress.Printf("Warning: failed to send notification: %v\n", err)
+	}
+}
+
+// storageOverrides collects the per-run storage flags
+func storageOverrides(c *cli.Context) (config.StorageOverrides, error) {
+	overrides := config.StorageOverrides{
+		Overwrite:    c.Bool("overwrite"),
+		StorageClass: c.String("storage-class"),
+		RetainUntil:  c.String("retain-until"),
+	}
+	for _, tag := range c.StringSlice("tag") {
+		key, value, ok := strings.Cut(tag, "=")
+		if !ok || key == "" {
+			return overrides, fmt.Errorf("invalid tag %q, expected key=value", tag)
+		}
+		if overrides.Tags == nil {
+			overrides.Tags = make(map[string]string)
+		}
+		overrides.Tags[key] = value
+	}
+	return overrides, nil
+}
@@ -2,79 +2,65 @@ package cmd
 
 import (
 	"fmt"
+	"os"
 
 	"github.com/urfave/cli/v2"
+	"github.com/yeboahd24/dbBackupUitility/pkg/backup"
 	"github.com/yeboahd24/dbBackupUitility/pkg/config"
 )
 
+// databaseBackupers create the backuper of each database type
+var databaseBackupers = map[string]func(config.DatabaseConfig) backup.DatabaseBackuper{
+	"postgres": func(cfg config.DatabaseConfig) backup.DatabaseBackuper { return backup.NewPostgresBackup(cfg) },
+	"mysql":    func(cfg config.DatabaseConfig) backup.DatabaseBackuper { return backup.NewMySQLBackup(cfg) },
+}
+
+// databaseTools are the client tools whose paths can be set per database type
+var databaseTools = map[string][]string{
+	"postgres": {"pg_dump", "pg_restore"},
+	"mysql":    {"mysqldump", "mysql"},
+}
+
 func ConfigCommand() *cli.Command {
 	return &cli.Command{
 		Name:  "config",
 		Usage: "Manage configuration settings",
 		Subcommands: []*cli.Command{
-			{
-				Name:  "validate",
-				Usage: "Validate configuration file",
-				Flags: []cli.Flag{
-					&cli.StringFlag{
-						Name:     "config",
-						Aliases:  []string{"c"},
-						Usage:    "Path to config file (optional, will auto-detect if not provided)",
-						Required: false,
-					},
-				},
-				Action: func(c *cli.Context) error {
-					cfg, err := config.LoadConfig(c.String("config"))
-					if err != nil {
-						return fmt.Errorf("configuration validation failed: %w", err)
-					}
-
-					// Validate database configuration
-					if cfg.Database.Type == "" {
-						return fmt.Errorf("database type is required")
-					}
-					if cfg.Database.Host == "" {
-						return fmt.Errorf("database host is required")
-					}
-					if cfg.Database.Port == 0 {
-						return fmt.Errorf("database port is required")
-					}
-					if cfg.Database.Username == "" {
-						return fmt.Errorf("database username is required")
-					}
-					if cfg.Database.Database == "" {
-						return fmt.Errorf("database name is required")
-					}
+			configValidateCommand(),
+			configShowCommand(),
+			configSchemaCommand(),
+			configMigrateCommand(),
+			configInitCommand(),
+			configEncryptCommand(),
+			configDecryptCommand(),
+			configEditCommand(),
+			configKeygenCommand(),
+		},
+	}
+}
 
-					// Validate storage configuration
-					if cfg.Storage.Type == "" {
-						return fmt.Errorf("storage type is required")
-					}
-					switch cfg.Storage.Type {
-					case "local":
-						if cfg.Storage.Path == "" {
-							return fmt.Errorf("storage path is required for local storage")
-						}
-					case "s3":
-						if cfg.Storage.Bucket == "" {
-							return fmt.Errorf("storage bucket is required for S3 storage")
-						}
-						if cfg.Storage.Region == "" {
-							return fmt.Errorf("storage region is required for S3 storage")
-						}
-					default:
-						return fmt.Errorf("unsupported storage type: %s", cfg.Storage.Type)
-					}
+// loadOptions selects the configuration layers with the file given by
+// --config, the --set overrides and --no-default-config
+func loadOptions(c *cli.Context) config.LoadOptions {
+	return config.LoadOptions{
+		Path:       c.String("config"),
+		Set:        c.StringSlice("set"),
+		NoDefaults: c.Bool("no-default-config"),
+	}
+}
 
-					// If notification is enabled, validate webhook URL
-					if cfg.Notification.Enabled && cfg.Notification.SlackWebhook == "" {
-						return fmt.Errorf("slack webhook URL is required when notifications are enabled")
-					}
+// loadConfig loads the configuration layers and prints the warnings found
+func loadConfig(c *cli.Context) (*config.Config, error) {
+	cfg, warnings, err := config.Load(loadOptions(c))
+	printConfigWarnings(warnings)
+	return cfg, err
+}
 
-					fmt.Println("Configuration file is valid")
-					return nil
-				},
-			},
-		},
+// printConfigWarnings prints the warnings found while loading the
+// configuration to stderr, keeping the output of commands such as
+// config show clean
+func printConfigWarnings(warnings []string) {
+	for _, warning := range warnings {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", warning)
 	}
 }
@@ -0,0 +1,361 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+
+	"filippo.io/age"
+	"github.com/urfave/cli/v2"
+	"github.com/yeboahd24/dbBackupUitility/pkg/config"
+	"golang.org/x/term"
+	"gopkg.in/yaml.v3"
+)
+
+func configEncryptCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "encrypt",
+		Usage: "Encrypt the passwords, keys and webhooks in the configuration file",
+		Flags: []cli.Flag{
+			configPathFlag(),
+			&cli.StringSliceFlag{
+				Name:  "age-recipient",
+				Usage: "age public key to encrypt for (repeatable)",
+			},
+			&cli.BoolFlag{
+				Name:  "passphrase",
+				Usage: "Encrypt with a passphrase (DBBACKUP_PASSPHRASE or prompted)",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			path, err := config.ConfigPath(c.String("config"))
+			if err != nil {
+				return err
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return fmt.Errorf("failed to read config file: %w", err)
+			}
+			existing, err := config.ReadEncryption(data)
+			if err != nil {
+				return err
+			}
+
+			enc := config.EncryptionConfig{
+				AgeRecipients: c.StringSlice("age-recipient"),
+				Passphrase:    c.Bool("passphrase"),
+			}
+			given := len(enc.AgeRecipients) > 0 || enc.Passphrase
+			switch {
+			case existing == nil && !given:
+				return fmt.Errorf("specify --age-recipient or --passphrase")
+			case existing != nil && !given:
+				// Encrypt values added since with the same keys
+				enc = *existing
+			case existing != nil && (existing.Passphrase != enc.Passphrase ||
+				!slices.Equal(existing.AgeRecipients, enc.AgeRecipients)):
+				return fmt.Errorf("%s is encrypted with other keys, decrypt it first to change them", path)
+			}
+
+			var passphrase string
+			if enc.Passphrase {
+				if passphrase, err = readPassphrase(existing == nil); err != nil {
+					return err
+				}
+				if existing != nil {
+					// Values encrypted with another passphrase could not be
+					// decrypted together
+					if err := config.AddPassphrase(passphrase); err != nil {
+						return err
+					}
+					if _, err := config.DecryptFile(data); err != nil {
+						return fmt.Errorf("the passphrase does not decrypt the values already encrypted: %w", err)
+					}
+				}
+			}
+			recipients, err := enc.Recipients(passphrase)
+			if err != nil {
+				return err
+			}
+
+			encrypted, err := config.EncryptFile(data, enc, recipients)
+			if err != nil {
+				return err
+			}
+			if err := writeConfigFile(path, encrypted); err != nil {
+				return err
+			}
+			fmt.Printf("Encrypted %s\n", path)
+			return nil
+		},
+	}
+}
+
+func configDecryptCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "decrypt",
+		Usage: "Print the configuration file with its encrypted values decrypted",
+		Flags: []cli.Flag{
+			configPathFlag(),
+			&cli.BoolFlag{
+				Name:  "in-place",
+				Usage: "Replace the file with its decrypted contents",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			path, err := config.ConfigPath(c.String("config"))
+			if err != nil {
+				return err
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return fmt.Errorf("failed to read config file: %w", err)
+			}
+			decrypted, err := decryptConfig(data)
+			if err != nil {
+				return err
+			}
+
+			if !c.Bool("in-place") {
+				_, err := os.Stdout.Write(decrypted)
+				return err
+			}
+			if err := writeConfigFile(path, decrypted); err != nil {
+				return err
+			}
+			fmt.Printf("Decrypted %s\n", path)
+			return nil
+		},
+	}
+}
+
+func configEditCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "edit",
+		Usage: "Edit an encrypted configuration file in $EDITOR and encrypt it again",
+		Flags: []cli.Flag{configPathFlag()},
+		Action: func(c *cli.Context) error {
+			path, err := config.ConfigPath(c.String("config"))
+			if err != nil {
+				return err
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return fmt.Errorf("failed to read config file: %w", err)
+			}
+			enc, err := config.ReadEncryption(data)
+			if err != nil {
+				return err
+			}
+			if enc == nil {
+				return fmt.Errorf("%s is not encrypted, run 'dbbackup config encrypt' first", path)
+			}
+			var passphrase string
+			if enc.Passphrase {
+				if passphrase, err = readPassphrase(false); err != nil {
+					return err
+				}
+				if err := config.AddPassphrase(passphrase); err != nil {
+					return err
+				}
+			}
+			recipients, err := enc.Recipients(passphrase)
+			if err != nil {
+				return err
+			}
+			decrypted, err := config.DecryptFile(data)
+			if err != nil {
+				return err
+			}
+
+			// The plain text only exists in a file readable by the user
+			// for as long as the editor runs
+			tmp, err := os.CreateTemp("", "dbbackup-config-*.yml")
+			if err != nil {
+				return fmt.Errorf("failed to create temp file: %w", err)
+			}
+			defer os.Remove(tmp.Name())
+			_, err = tmp.Write(decrypted)
+			if closeErr := tmp.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				return fmt.Errorf("failed to write temp file: %w", err)
+			}
+			if err := runEditor(tmp.Name()); err != nil {
+				return err
+			}
+
+			edited, err := os.ReadFile(tmp.Name())
+			if err != nil {
+				return fmt.Errorf("failed to read edited file: %w", err)
+			}
+			if bytes.Equal(edited, decrypted) {
+				fmt.Println("No changes")
+				return nil
+			}
+			var cfg config.Config
+			if err := yaml.Unmarshal(edited, &cfg); err != nil {
+				return fmt.Errorf("edited file is not valid, no changes saved: %w", err)
+			}
+			encrypted, err := config.EncryptFile(edited, *enc, recipients)
+			if err != nil {
+				return err
+			}
+			if err := writeConfigFile(path, encrypted); err != nil {
+				return err
+			}
+			fmt.Printf("Saved %s\n", path)
+			return nil
+		},
+	}
+}
+
+func configKeygenCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "keygen",
+		Usage: "Generate an age key for encrypting configuration files",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "output",
+				Usage: "File to write the key to",
+				Value: config.DefaultKeyFile(),
+			},
+		},
+		Action: func(c *cli.Context) error {
+			path := c.String("output")
+			identity, err := age.GenerateX25519Identity()
+			if err != nil {
+				return fmt.Errorf("failed to generate key: %w", err)
+			}
+			if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+				return fmt.Errorf("failed to create key directory: %w", err)
+			}
+			// Never replace a key that may still be needed to decrypt files
+			f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+			if err != nil {
+				return fmt.Errorf("failed to create key file: %w", err)
+			}
+			_, err = fmt.Fprintf(f, "# public key: %s\n%s\n", identity.Recipient(), identity)
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				return fmt.Errorf("failed to write key file: %w", err)
+			}
+			fmt.Printf("Key written to %s\n", path)
+			fmt.Printf("Public key: %s\n", identity.Recipient())
+			return nil
+		},
+	}
+}
+
+func configPathFlag() *cli.StringFlag {
+	return &cli.StringFlag{
+		Name:     "config",
+		Aliases:  []string{"c"},
+		Usage:    "Path to config file (optional, will auto-detect if not provided)",
+		Required: false,
+	}
+}
+
+// decryptConfig decrypts a configuration file, prompting for the passphrase
+// when it is encrypted with one that is not set in DBBACKUP_PASSPHRASE
+func decryptConfig(data []byte) ([]byte, error) {
+	enc, err := config.ReadEncryption(data)
+	if err != nil {
+		return nil, err
+	}
+	if enc != nil && enc.Passphrase && os.Getenv("DBBACKUP_PASSPHRASE") == "" {
+		passphrase, err := readPassphrase(false)
+		if err != nil {
+			return nil, err
+		}
+		if err := config.AddPassphrase(passphrase); err != nil {
+			return nil, err
+		}
+	}
+	return config.DecryptFile(data)
+}
+
+// readPassphrase returns DBBACKUP_PASSPHRASE or prompts for the passphrase,
+// twice for a new one
+func readPassphrase(confirm bool) (string, error) {
+	if passphrase := os.Getenv("DBBACKUP_PASSPHRASE"); passphrase != "" {
+		return passphrase, nil
+	}
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return "", fmt.Errorf("set DBBACKUP_PASSPHRASE or run from a terminal to enter the passphrase")
+	}
+
+	fmt.Fprint(os.Stderr, "Passphrase: ")
+	passphrase, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("failed to read passphrase: %w", err)
+	}
+	if len(passphrase) == 0 {
+		return "", fmt.Errorf("empty passphrase")
+	}
+	if confirm {
+		fmt.Fprint(os.Stderr, "Confirm passphrase: ")
+		again, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return "", fmt.Errorf("failed to read passphrase: %w", err)
+		}
+		if !bytes.Equal(passphrase, again) {
+			return "", fmt.Errorf("passphrases do not match")
+		}
+	}
+	return string(passphrase), nil
+}
+
+// runEditor opens path in $VISUAL or $EDITOR, or vi
+func runEditor(path string) error {
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+	// Editors are often configured with arguments, e.g. "code --wait"
+	cmd := exec.Command("sh", "-c", editor+` "$1"`, "sh", path)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("editor failed, no changes saved: %w", err)
+	}
+	return nil
+}
+
+// writeConfigFile replaces a configuration file, keeping its permissions
+func writeConfigFile(path string, data []byte) error {
+	mode := os.FileMode(0600)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return fmt.Errorf("failed to write config file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Chmod(mode)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write config file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,393 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+	"github.com/yeboahd24/dbBackupUitility/pkg/config"
+	"github.com/yeboahd24/dbBackupUitility/pkg/preflight"
+	"github.com/yeboahd24/dbBackupUitility/pkg/retry"
+	"golang.org/x/term"
+	"gopkg.in/yaml.v3"
+)
+
+// defaultPorts are the default database ports by type
+var defaultPorts = map[string]string{"postgres": "5432", "mysql": "3306"}
+
+func configInitCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "init",
+		Usage: "Create a configuration file, testing the database and storage settings",
+		Flags: []cli.Flag{
+			&cli.StringFlag{Name: "path", Usage: "File to write (default: chosen from the search paths)"},
+			&cli.BoolFlag{Name: "force", Usage: "Replace an existing file"},
+			&cli.BoolFlag{Name: "non-interactive", Usage: "Do not prompt, take every setting from the flags"},
+			&cli.BoolFlag{Name: "skip-checks", Usage: "Do not test the database connection and storage"},
+			&cli.StringFlag{Name: "db-type", Usage: "Database type: postgres or mysql"},
+			&cli.StringFlag{Name: "db-host", Usage: "Database host"},
+			&cli.StringFlag{Name: "db-port", Usage: "Database port"},
+			&cli.StringFlag{Name: "db-user", Usage: "Database user"},
+			&cli.StringFlag{Name: "db-password", Usage: "Database password or a reference such as env:DB_PASS"},
+			&cli.StringFlag{Name: "db-name", Usage: "Database name"},
+			&cli.StringFlag{Name: "storage-type", Usage: "Storage type: local, s3 or none"},
+			&cli.StringFlag{Name: "storage-path", Usage: "Directory for local storage"},
+			&cli.StringFlag{Name: "bucket", Usage: "Bucket for S3 storage"},
+			&cli.StringFlag{Name: "region", Usage: "Region for S3 storage"},
+			&cli.StringFlag{Name: "slack-webhook", Usage: "Slack webhook for notifications, or a reference"},
+		},
+		Action: func(c *cli.Context) error {
+			ctx := context.Background()
+			p := &prompter{
+				c:           c,
+				in:          bufio.NewReader(os.Stdin),
+				interactive: !c.Bool("non-interactive") && term.IsTerminal(int(os.Stdin.Fd())),
+			}
+			cfg := &config.Config{Version: config.CurrentVersion}
+
+			if err := initDatabase(ctx, p, cfg); err != nil {
+				return err
+			}
+			if err := initStorage(ctx, p, cfg); err != nil {
+				return err
+			}
+			webhook, err := p.ask("Slack webhook for notifications (empty for none)", "slack-webhook", "", false)
+			if err != nil {
+				return err
+			}
+			if webhook != "" {
+				cfg.Notification = config.NotificationConfig{SlackWebhook: webhook, Enabled: true}
+			}
+
+			path, err := initPath(p)
+			if err != nil {
+				return err
+			}
+			data, err := encodeInitConfig(cfg)
+			if err != nil {
+				return err
+			}
+			if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+				return fmt.Errorf("failed to create config directory: %w", err)
+			}
+			if err := writeConfigFile(path, data); err != nil {
+				return err
+			}
+			// The file holds credentials, whatever the permissions of a
+			// file it replaced
+			if err := os.Chmod(path, 0600); err != nil {
+				return fmt.Errorf("failed to set config file permissions: %w", err)
+			}
+			fmt.Printf("Configuration written to %s\n", path)
+			return nil
+		},
+	}
+}
+
+// initDatabase asks for the database settings and tests the connection until
+// it succeeds or the user keeps the settings anyway
+func initDatabase(ctx context.Context, p *prompter, cfg *config.Config) error {
+	db := &cfg.Database
+	for {
+		var err error
+		if db.Type, err = p.choose("Database type", "db-type", "postgres", "postgres", "mysql"); err != nil {
+			return err
+		}
+		if db.Host, err = p.ask("Database host", "db-host", "localhost", true); err != nil {
+			return err
+		}
+		port, err := p.ask("Database port", "db-port", defaultPorts[db.Type], true)
+		if err != nil {
+			return err
+		}
+		if db.Port, err = strconv.Atoi(port); err != nil {
+			return fmt.Errorf("invalid database port: %s", port)
+		}
+		if db.Username, err = p.ask("Database user", "db-user", "", true); err != nil {
+			return err
+		}
+		if db.Password, err = p.secret("Database password, or a reference such as env:DB_PASS", "db-password"); err != nil {
+			return err
+		}
+		if db.Database, err = p.ask("Database name", "db-name", "", true); err != nil {
+			return err
+		}
+
+		if p.c.Bool("skip-checks") {
+			return nil
+		}
+		err = checkInitDatabase(ctx, *db)
+		if err == nil {
+			fmt.Println("  [ok  ] Database connection")
+			return nil
+		}
+		fmt.Printf("  [FAIL] Database connection: %v\n", err)
+		if retry, promptErr := p.again("database"); promptErr != nil || !retry {
+			return promptErr
+		}
+	}
+}
+
+func checkInitDatabase(ctx context.Context, db config.DatabaseConfig) error {
+	password, err := config.ResolveSecret(ctx, db.Password)
+	if err != nil {
+		return err
+	}
+	db.Password = password
+	backuper, err := newBackuper(db)
+	if err != nil {
+		return err
+	}
+	policy := retry.DefaultPolicy
+	policy.MaxAttempts = 1
+	if err := connect(ctx, backuper, policy); err != nil {
+		return err
+	}
+	return backuper.Close()
+}
+
+// initStorage asks for a storage and tests it until the test succeeds or the
+// user keeps the settings anyway
+func initStorage(ctx context.Context, p *prompter, cfg *config.Config) error {
+	for {
+		kind, err := p.choose("Storage type", "storage-type", "local", "local", "s3", "none")
+		if err != nil {
+			return err
+		}
+		storageCfg := config.StorageConfig{Type: kind}
+		switch kind {
+		case "none":
+			cfg.Storages = nil
+			return nil
+		case "local":
+			if storageCfg.Path, err = p.ask("Backup directory", "storage-path", "/var/backups/dbbackup", true); err != nil {
+				return err
+			}
+		case "s3":
+			if storageCfg.Bucket, err = p.ask("S3 bucket", "bucket", "", true); err != nil {
+				return err
+			}
+			if storageCfg.Region, err = p.ask("S3 region", "region", "us-east-1", true); err != nil {
+				return err
+			}
+		}
+		cfg.Storages = []config.StorageConfig{storageCfg}
+
+		if p.c.Bool("skip-checks") {
+			return nil
+		}
+		if kind == "local" {
+			if err := os.MkdirAll(storageCfg.Path, 0700); err != nil {
+				fmt.Printf("  [FAIL] Storage: %v\n", err)
+				if retry, promptErr := p.again("storage"); promptErr != nil || !retry {
+					return promptErr
+				}
+				continue
+			}
+		}
+		provider, err := initializeProvider(storageCfg)
+		if err == nil {
+			err = preflight.RoundTrip(ctx, provider)
+		}
+		if err == nil {
+			fmt.Println("  [ok  ] Storage: write, read and delete succeeded")
+			return nil
+		}
+		fmt.Printf("  [FAIL] Storage: %v\n", err)
+		if retry, promptErr := p.again("storage"); promptErr != nil || !retry {
+			return promptErr
+		}
+	}
+}
+
+// initPath returns the file to write, letting the user choose one of the
+// search paths
+func initPath(p *prompter) (string, error) {
+	path := p.c.String("path")
+	if path == "" {
+		locations := config.ConfigFileLocations()
+		if !p.interactive {
+			path = locations[0]
+		} else {
+			fmt.Println("Where should the configuration be written?")
+			for i, location := range locations {
+				fmt.Printf("  %d) %s\n", i+1, location)
+			}
+			choice, err := p.ask("Choice", "", "1", true)
+			if err != nil {
+				return "", err
+			}
+			i, err := strconv.Atoi(choice)
+			if err != nil || i < 1 || i > len(locations) {
+				return "", fmt.Errorf("invalid choice: %s", choice)
+			}
+			path = locations[i-1]
+		}
+	}
+
+	if _, err := os.Stat(path); err == nil && !p.c.Bool("force") {
+		if !p.interactive {
+			return "", fmt.Errorf("%s already exists, use --force to replace it", path)
+		}
+		replace, err := p.confirm(fmt.Sprintf("%s already exists. Replace it?", path), false)
+		if err != nil {
+			return "", err
+		}
+		if !replace {
+			return "", fmt.Errorf("%s already exists", path)
+		}
+	}
+	return path, nil
+}
+
+// encodeInitConfig encodes the settings the user gave, leaving out the ones
+// left at their defaults
+func encodeInitConfig(cfg *config.Config) ([]byte, error) {
+	var node yaml.Node
+	if err := node.Encode(cfg); err != nil {
+		return nil, fmt.Errorf("failed to encode config: %w", err)
+	}
+	pruneEmpty(&node)
+	node.HeadComment = "Created by 'dbbackup config init'. Secrets can be references such as\n" +
+		"env:DB_PASS or file:/run/secrets/db, see 'dbbackup help config'."
+
+	var b strings.Builder
+	enc := yaml.NewEncoder(&b)
+	enc.SetIndent(2)
+	if err := enc.Encode(&node); err != nil {
+		return nil, fmt.Errorf("failed to encode config: %w", err)
+	}
+	if err := enc.Close(); err != nil {
+		return nil, fmt.Errorf("failed to encode config: %w", err)
+	}
+	return []byte(b.String()), nil
+}
+
+// pruneEmpty removes the keys of mappings whose values are empty, zero or
+// false, and reports whether node itself is empty
+func pruneEmpty(node *yaml.Node) bool {
+	switch node.Kind {
+	case yaml.MappingNode:
+		kept := node.Content[:0]
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if !pruneEmpty(node.Content[i+1]) {
+				kept = append(kept, node.Content[i], node.Content[i+1])
+			}
+		}
+		node.Content = kept
+		return len(kept) == 0
+	case yaml.SequenceNode:
+		for _, item := range node.Content {
+			pruneEmpty(item)
+		}
+		return len(node.Content) == 0
+	case yaml.ScalarNode:
+		return node.Tag == "!!null" || node.Value == "" ||
+			(node.Tag == "!!int" && node.Value == "0") || (node.Tag == "!!bool" && node.Value == "false")
+	}
+	return false
+}
+
+// prompter asks for the settings not given as flags, or only takes them from
+// the flags when not interactive
+type prompter struct {
+	c           *cli.Context
+	in          *bufio.Reader
+	interactive bool
+}
+
+// ask returns the value of flag if set, or prompts for the value with def as
+// its default. Required values cannot be left empty.
+func (p *prompter) ask(label, flag, def string, required bool) (string, error) {
+	if flag != "" && p.c.IsSet(flag) {
+		return p.c.String(flag), nil
+	}
+	if !p.interactive {
+		if def == "" && required {
+			return "", fmt.Errorf("--%s is required", flag)
+		}
+		return def, nil
+	}
+
+	for {
+		if def != "" {
+			fmt.Printf("%s [%s]: ", label, def)
+		} else {
+			fmt.Printf("%s: ", label)
+		}
+		line, err := p.in.ReadString('\n')
+		if err != nil && !(errors.Is(err, io.EOF) && line != "") {
+			return "", fmt.Errorf("failed to read answer: %w", err)
+		}
+		value := strings.TrimSpace(line)
+		if value == "" {
+			value = def
+		}
+		if value != "" || !required {
+			return value, nil
+		}
+	}
+}
+
+// choose asks for one of the given options
+func (p *prompter) choose(label, flag, def string, options ...string) (string, error) {
+	value, err := p.ask(fmt.Sprintf("%s (%s)", label, strings.Join(options, ", ")), flag, def, true)
+	if err != nil {
+		return "", err
+	}
+	for _, option := range options {
+		if value == option {
+			return value, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported %s: %s", strings.ToLower(label), value)
+}
+
+// secret asks for a value without echoing it
+func (p *prompter) secret(label, flag string) (string, error) {
+	if p.c.IsSet(flag) || !p.interactive {
+		return p.c.String(flag), nil
+	}
+	fmt.Printf("%s: ", label)
+	value, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Println()
+	if err != nil {
+		return "", fmt.Errorf("failed to read answer: %w", err)
+	}
+	return string(value), nil
+}
+
+// confirm asks a yes or no question
+func (p *prompter) confirm(label string, def bool) (bool, error) {
+	hint := "y/N"
+	if def {
+		hint = "Y/n"
+	}
+	answer, err := p.ask(fmt.Sprintf("%s (%s)", label, hint), "", "", false)
+	if err != nil {
+		return false, err
+	}
+	switch strings.ToLower(answer) {
+	case "":
+		return def, nil
+	case "y", "yes":
+		return true, nil
+	}
+	return false, nil
+}
+
+// again asks whether to change settings that failed their check. Keeping
+// them is only possible interactively; otherwise the check fails the command.
+func (p *prompter) again(what string) (bool, error) {
+	if !p.interactive {
+		return false, fmt.Errorf("%s check failed, fix the settings or use --skip-checks", what)
+	}
+	return p.confirm(fmt.Sprintf("Change the %s settings?", what), true)
+}
@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/urfave/cli/v2"
+	"github.com/yeboahd24/dbBackupUitility/pkg/config"
+)
+
+func configMigrateCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "migrate",
+		Usage: "Rewrite the configuration file in the current format, keeping its comments",
+		Flags: []cli.Flag{
+			configPathFlag(),
+			&cli.BoolFlag{
+				Name:  "dry-run",
+				Usage: "Print the migrated file instead of replacing it",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			path, err := config.ConfigPath(c.String("config"))
+			if err != nil {
+				return err
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return fmt.Errorf("failed to read config file: %w", err)
+			}
+			migrated, version, changes, err := config.MigrateFile(data)
+			if err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+			if version == config.CurrentVersion {
+				fmt.Printf("%s is already at version %d\n", path, version)
+				return nil
+			}
+
+			if c.Bool("dry-run") {
+				_, err := os.Stdout.Write(migrated)
+				return err
+			}
+			if err := writeConfigFile(path, migrated); err != nil {
+				return err
+			}
+			fmt.Printf("Migrated %s from version %d to %d\n", path, version, config.CurrentVersion)
+			for _, change := range changes {
+				fmt.Printf("  %s\n", change)
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,123 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+
+	"github.com/urfave/cli/v2"
+	"github.com/yeboahd24/dbBackupUitility/pkg/config"
+	"gopkg.in/yaml.v3"
+)
+
+func configShowCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "show",
+		Usage: "Print the merged configuration with secrets redacted",
+		Flags: []cli.Flag{
+			configPathFlag(),
+			&cli.BoolFlag{
+				Name:  "origin",
+				Usage: "Note the file, environment variable or flag each value comes from",
+			},
+			&cli.StringFlag{
+				Name:  "format",
+				Usage: "Output format: yaml or json",
+				Value: "yaml",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			layers, err := config.LoadLayers(loadOptions(c))
+			if err != nil {
+				return fmt.Errorf("failed to load config: %w", err)
+			}
+			printConfigWarnings(layers.Warnings)
+			files := slices.Clone(layers.Files)
+			slices.Reverse(files)
+
+			switch c.String("format") {
+			case "yaml":
+			case "json":
+				annotate(layers.Root, "", nil)
+				var values any
+				if err := layers.Root.Decode(&values); err != nil {
+					return fmt.Errorf("failed to encode config: %w", err)
+				}
+				var out any = values
+				if c.Bool("origin") {
+					out = map[string]any{"files": files, "origins": layers.Origins, "config": values}
+				}
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				enc.SetEscapeHTML(false)
+				return enc.Encode(out)
+			default:
+				return fmt.Errorf("unsupported format: %s", c.String("format"))
+			}
+
+			var origins map[string]string
+			if c.Bool("origin") {
+				origins = layers.Origins
+				fmt.Println("# Loaded from, highest precedence first:")
+				for _, file := range files {
+					fmt.Printf("#   %s\n", file)
+				}
+			}
+			annotate(layers.Root, "", origins)
+
+			enc := yaml.NewEncoder(os.Stdout)
+			enc.SetIndent(2)
+			if err := enc.Encode(layers.Root); err != nil {
+				return fmt.Errorf("failed to encode config: %w", err)
+			}
+			return enc.Close()
+		},
+	}
+}
+
+// annotate prepares a configuration document for display: comments of the
+// source files are dropped, sensitive values are redacted and, when origins
+// are given, each value is followed by where it came from
+func annotate(node *yaml.Node, path string, origins map[string]string) {
+	node.HeadComment, node.LineComment, node.FootComment = "", "", ""
+	// Flow style would put the comments inside brackets
+	node.Style &^= yaml.FlowStyle
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			key.HeadComment, key.LineComment, key.FootComment = "", "", ""
+			keyPath := key.Value
+			if path != "" {
+				keyPath = path + "." + key.Value
+			}
+			annotate(value, keyPath, origins)
+			if value.Kind == yaml.ScalarNode && slices.Contains(config.SensitiveKeys, key.Value) {
+				value.Value = redact(value.Value)
+				value.Tag, value.Style = "!!str", 0
+			}
+		}
+	case yaml.SequenceNode:
+		for i, item := range node.Content {
+			annotate(item, fmt.Sprintf("%s.%d", path, i), origins)
+		}
+	}
+	if origin, ok := origins[path]; ok && (node.Kind == yaml.ScalarNode || len(node.Content) == 0) {
+		node.LineComment = origin
+	}
+}
+
+// redact hides a secret, keeping references to secrets stored elsewhere,
+// which are not secret themselves
+func redact(value string) string {
+	switch {
+	case value == "":
+		return ""
+	case config.IsEncrypted(value):
+		return "<encrypted>"
+	case config.IsSecretRef(value):
+		return value
+	}
+	return "<redacted>"
+}
@@ -0,0 +1,477 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"time"
+
+	"filippo.io/age"
+	"github.com/urfave/cli/v2"
+	"github.com/yeboahd24/dbBackupUitility/pkg/backup"
+	"github.com/yeboahd24/dbBackupUitility/pkg/config"
+	"github.com/yeboahd24/dbBackupUitility/pkg/preflight"
+	"github.com/yeboahd24/dbBackupUitility/pkg/storage"
+)
+
+// s3BucketName matches valid S3 bucket names
+var s3BucketName = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]{1,61}[a-z0-9]$`)
+
+// s3OnlySettings and localOnlySettings are the storage settings that only
+// apply to one type of storage
+var (
+	s3OnlySettings = map[string]func(config.StorageConfig) bool{
+		"bucket":                 func(s config.StorageConfig) bool { return s.Bucket != "" },
+		"region":                 func(s config.StorageConfig) bool { return s.Region != "" },
+		"access_key":             func(s config.StorageConfig) bool { return s.AccessKey != "" },
+		"secret_key":             func(s config.StorageConfig) bool { return s.SecretKey != "" },
+		"storage_class":          func(s config.StorageConfig) bool { return s.StorageClass != "" },
+		"server_side_encryption": func(s config.StorageConfig) bool { return s.ServerSideEncryption != "" },
+		"kms_key_id":             func(s config.StorageConfig) bool { return s.KMSKeyID != "" },
+		"tags":                   func(s config.StorageConfig) bool { return len(s.Tags) > 0 },
+		"object_lock":            func(s config.StorageConfig) bool { return s.ObjectLock != config.ObjectLockConfig{} },
+	}
+	localOnlySettings = map[string]func(config.StorageConfig) bool{
+		"path":      func(s config.StorageConfig) bool { return s.Path != "" },
+		"overwrite": func(s config.StorageConfig) bool { return s.Overwrite },
+	}
+)
+
+func configValidateCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "validate",
+		Usage: "Validate configuration file",
+		Flags: []cli.Flag{
+			configPathFlag(),
+			&cli.BoolFlag{
+				Name:  "live",
+				Usage: "Also connect to the database and write, read and delete a probe object on every storage",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			ctx := context.Background()
+			layers, err := config.LoadLayers(loadOptions(c))
+			if err != nil {
+				return fmt.Errorf("configuration validation failed: %w", err)
+			}
+			printConfigWarnings(layers.Warnings)
+
+			// Report every problem at once: the settings that are not
+			// understood, the secrets that cannot be resolved and the
+			// values that are not accepted
+			p := &configProblems{layers: layers, errs: layers.Check()}
+			var cfg config.Config
+			// Values that do not decode are already reported by Check
+			_ = layers.Root.Decode(&cfg)
+			p.errs = append(p.errs, flattenErrors(config.ResolveSecrets(ctx, &cfg))...)
+			validateConfig(p, &cfg)
+
+			if len(p.errs) > 0 {
+				for _, err := range p.errs {
+					fmt.Println(err)
+				}
+				if c.Bool("live") {
+					fmt.Println("Live checks skipped until the configuration is valid")
+				}
+				return fmt.Errorf("configuration validation failed: %d problem(s) found", len(p.errs))
+			}
+			fmt.Println("Configuration file is valid")
+
+			if c.Bool("live") {
+				return liveChecks(ctx, &cfg)
+			}
+			return nil
+		},
+	}
+}
+
+func configSchemaCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "schema",
+		Usage: "Print the JSON Schema of the configuration file, for editor completion",
+		Action: func(c *cli.Context) error {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			enc.SetEscapeHTML(false)
+			return enc.Encode(config.Schema())
+		},
+	}
+}
+
+// configProblems collects the problems found in a configuration, each
+// starting with where the setting was made
+type configProblems struct {
+	layers *config.Layers
+	errs   []error
+}
+
+// add records a problem with the setting at path, a dotted key such as
+// storages.0.bucket
+func (p *configProblems) add(path, format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	if origin := p.layers.Origin(path); origin != "" {
+		p.errs = append(p.errs, fmt.Errorf("%s: %s: %s", origin, path, msg))
+		return
+	}
+	p.errs = append(p.errs, fmt.Errorf("%s: %s", path, msg))
+}
+
+// check records err, if not nil, as a problem with the setting at path
+func (p *configProblems) check(path string, err error) {
+	if err != nil {
+		p.add(path, "%v", err)
+	}
+}
+
+// flattenErrors returns the errors joined in err
+func flattenErrors(err error) []error {
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok {
+		if err == nil {
+			return nil
+		}
+		return []error{err}
+	}
+	var errs []error
+	for _, err := range joined.Unwrap() {
+		errs = append(errs, flattenErrors(err)...)
+	}
+	return errs
+}
+
+// validateConfig records every problem with the values of cfg
+func validateConfig(p *configProblems, cfg *config.Config) {
+	validateDatabase(p, cfg.Database)
+
+	// Disabled storages are checked too, so they work once enabled
+	type namedStorage struct {
+		path string
+		cfg  config.StorageConfig
+	}
+	var storages []namedStorage
+	for i, s := range cfg.Storages {
+		storages = append(storages, namedStorage{fmt.Sprintf("storages.%d", i), s})
+	}
+	for i, tier := range cfg.Tiering {
+		path := fmt.Sprintf("tiering.%d", i)
+		if tier.After == "" {
+			p.add(path+".after", "required")
+		} else if _, err := config.ParseDuration(tier.After); err != nil {
+			p.add(path+".after", "%v", err)
+		}
+		storages = append(storages, namedStorage{path + ".storage", tier.Storage})
+	}
+	names := map[string]string{}
+	for _, s := range storages {
+		validateStorage(p, s.path, s.cfg)
+		if s.cfg.Disabled {
+			continue
+		}
+		if other, ok := names[s.cfg.StorageName()]; ok {
+			p.add(s.path+".name", "duplicate storage name %s, also used by %s", s.cfg.StorageName(), other)
+		}
+		names[s.cfg.StorageName()] = s.path
+	}
+
+	if len(cfg.Tiering) > 0 && len(cfg.StorageTargets()) == 0 {
+		p.add("tiering", "requires storage to be enabled")
+	}
+	switch cfg.StoragePolicy {
+	case "", storage.PolicyAll, storage.PolicyAny, storage.PolicyMajority:
+	default:
+		p.add("storage_policy", "unsupported policy %q, expected all, any or majority", cfg.StoragePolicy)
+	}
+	if cfg.NamingTemplate != "" {
+		_, err := backup.ExpandName(cfg.NamingTemplate, backup.NameVars(
+			cfg.Database.Database, cfg.Database.Type, backup.Full, time.Now(), cfg.Labels))
+		p.check("naming_template", err)
+	}
+	if cfg.UploadRate != "" {
+		_, err := config.ParseRate(cfg.UploadRate)
+		p.check("upload_rate", err)
+	}
+
+	if cfg.Spool.Path != "" && len(cfg.StorageTargets()) == 0 {
+		p.add("spool.path", "spool requires storage to be enabled")
+	}
+	if cfg.Spool.MaxSize != "" {
+		_, err := config.ParseSize(cfg.Spool.MaxSize)
+		p.check("spool.max_size", err)
+	}
+	if cfg.Spool.AlertSize != "" {
+		_, err := config.ParseSize(cfg.Spool.AlertSize)
+		p.check("spool.alert_size", err)
+	}
+
+	if cfg.Retry.MaxAttempts < 0 {
+		p.add("retry.max_attempts", "must not be negative")
+	}
+	if cfg.Retry.BaseDelay != "" {
+		_, err := config.ParseDuration(cfg.Retry.BaseDelay)
+		p.check("retry.base_delay", err)
+	}
+	if cfg.Retry.MaxDelay != "" {
+		_, err := config.ParseDuration(cfg.Retry.MaxDelay)
+		p.check("retry.max_delay", err)
+	}
+	if cfg.Retry.Jitter < 0 || cfg.Retry.Jitter > 1 {
+		p.add("retry.jitter", "must be between 0 and 1")
+	}
+	if cfg.Preflight.SizeFactor < 0 {
+		p.add("preflight.size_factor", "must not be negative")
+	}
+
+	if cfg.Notification.Enabled && cfg.Notification.SlackWebhook == "" {
+		p.add("notification.slack_webhook", "required when notifications are enabled")
+	}
+	if webhook := cfg.Notification.SlackWebhook; webhook != "" {
+		// The URL is a secret, so it is not repeated in the message
+		if u, err := url.Parse(webhook); err != nil || u.Scheme != "https" || u.Host == "" {
+			p.add("notification.slack_webhook", "not a valid https URL")
+		}
+	}
+
+	if cfg.Encryption.Passphrase && len(cfg.Encryption.AgeRecipients) > 0 {
+		p.add("encryption", "a passphrase cannot be combined with age recipients")
+	}
+	for i, recipient := range cfg.Encryption.AgeRecipients {
+		if _, err := age.ParseX25519Recipient(recipient); err != nil {
+			p.add(fmt.Sprintf("encryption.age_recipients.%d", i), "invalid age recipient %q", recipient)
+		}
+	}
+}
+
+// validateDatabase records the problems with the database settings
+func validateDatabase(p *configProblems, db config.DatabaseConfig) {
+	switch db.Type {
+	case "":
+		p.add("database.type", "required")
+	case "postgres", "mysql":
+	default:
+		p.add("database.type", "unsupported database type %q, expected postgres or mysql", db.Type)
+	}
+	for _, required := range []struct{ key, value string }{
+		{"database.username", db.Username},
+		{"database.database", db.Database},
+	} {
+		if required.value == "" {
+			p.add(required.key, "required")
+		}
+	}
+	// A socket takes the place of host and port
+	if db.Host == "" && db.Socket == "" {
+		p.add("database.host", "required, or a socket")
+	}
+	if db.Port == 0 && db.Socket == "" {
+		p.add("database.port", "required")
+	} else if db.Port < 0 || db.Port > 65535 {
+		p.add("database.port", "must be between 1 and 65535")
+	}
+	if db.Socket != "" && db.Type == "postgres" && db.SSLMode != "" && db.SSLMode != "disable" {
+		p.add("database.ssl_mode", "TLS is not available over a PostgreSQL socket")
+	}
+	if tunnel := db.SSHTunnel; tunnel != nil {
+		validateSSHTunnel(p, tunnel)
+		if db.Type == "mysql" && db.SSLMode == "verify-full" {
+			p.add("database.ssl_mode", "the MySQL client tools cannot verify the host name through ssh_tunnel, use verify-ca")
+		}
+	}
+
+	for i, replica := range db.Replicas {
+		path := fmt.Sprintf("database.replicas.%d", i)
+		if replica.Host == "" && replica.Socket == "" {
+			p.add(path+".host", "required, or a socket")
+		}
+		if replica.Port < 0 || replica.Port > 65535 {
+			p.add(path+".port", "must be between 1 and 65535")
+		}
+	}
+	switch db.DumpFrom {
+	case "", "primary":
+	case "replica":
+		if len(db.Replicas) == 0 {
+			p.add("database.dump_from", "replica requires database.replicas")
+		}
+	default:
+		p.add("database.dump_from", "unsupported value %q, expected primary or replica", db.DumpFrom)
+	}
+	if db.MaxReplicationLag != "" {
+		_, err := config.ParseDuration(db.MaxReplicationLag)
+		p.check("database.max_replication_lag", err)
+	}
+	switch db.ReplicaFallback {
+	case "", "primary", "fail":
+	default:
+		p.add("database.replica_fallback", "unsupported value %q, expected primary or fail", db.ReplicaFallback)
+	}
+
+	if level := db.DumpCompression; level != nil {
+		if db.Type == "mysql" {
+			p.add("database.dump_compression", "only applies to postgres")
+		} else if *level < 0 || *level > 9 {
+			p.add("database.dump_compression", "must be between 0 and 9")
+		}
+	}
+	if db.Nice < -20 || db.Nice > 19 {
+		p.add("database.nice", "must be between -20 and 19")
+	}
+	switch db.IONiceClass {
+	case "", "idle", "best-effort":
+	default:
+		p.add("database.ionice_class", "unsupported class %q, expected idle or best-effort", db.IONiceClass)
+	}
+	if level := db.IONiceLevel; level != nil {
+		if *level < 0 || *level > 7 {
+			p.add("database.ionice_level", "must be between 0 and 7")
+		} else if db.IONiceClass != "best-effort" {
+			p.add("database.ionice_level", "only applies to the best-effort ionice_class")
+		}
+	}
+
+	if db.SSLMode != "" && !slices.Contains(backup.SSLModes, db.SSLMode) {
+		p.add("database.ssl_mode", "unsupported mode %q, expected one of %v", db.SSLMode, backup.SSLModes)
+	}
+	if (db.SSLCert == "") != (db.SSLKey == "") {
+		p.add("database.ssl_cert", "ssl_cert and ssl_key must be set together")
+	}
+	for _, file := range []struct{ key, path string }{
+		{"database.ssl_ca", db.SSLCA},
+		{"database.ssl_cert", db.SSLCert},
+		{"database.ssl_key", db.SSLKey},
+	} {
+		if file.path == "" {
+			continue
+		}
+		if db.SSLMode == "" || db.SSLMode == "disable" {
+			p.add(file.key, "has no effect while ssl_mode is disable")
+		} else if _, err := os.Stat(file.path); err != nil {
+			p.check(file.key, err)
+		}
+	}
+
+	tools := make([]string, 0, len(db.ToolPaths))
+	for name := range db.ToolPaths {
+		tools = append(tools, name)
+	}
+	slices.Sort(tools)
+	for _, name := range tools {
+		if known, ok := databaseTools[db.Type]; ok && !slices.Contains(known, name) {
+			p.add("database.tool_paths."+name, "unknown %s tool, expected one of %v", db.Type, known)
+		}
+	}
+}
+
+// validateSSHTunnel records the problems with the SSH bastion of the
+// database
+func validateSSHTunnel(p *configProblems, tunnel *config.SSHTunnelConfig) {
+	if tunnel.Host == "" {
+		p.add("database.ssh_tunnel.host", "required")
+	}
+	if tunnel.User == "" {
+		p.add("database.ssh_tunnel.user", "required")
+	}
+	if tunnel.Port < 0 || tunnel.Port > 65535 {
+		p.add("database.ssh_tunnel.port", "must be between 1 and 65535")
+	}
+	if tunnel.KeyPassphrase != "" && tunnel.Key == "" {
+		p.add("database.ssh_tunnel.key_passphrase", "has no effect without a key")
+	}
+	for _, file := range []struct{ key, path string }{
+		{"database.ssh_tunnel.key", tunnel.Key},
+		{"database.ssh_tunnel.known_hosts", tunnel.KnownHosts},
+	} {
+		if file.path != "" {
+			_, err := os.Stat(file.path)
+			p.check(file.key, err)
+		}
+	}
+}
+
+// validateStorage records the problems with the storage at path, following
+// the rules of its type
+func validateStorage(p *configProblems, path string, s config.StorageConfig) {
+	switch s.Type {
+	case "":
+		p.add(path+".type", "required")
+	case "local":
+		if s.Path == "" {
+			p.add(path+".path", "required for local storage")
+		}
+		notApplicable(p, path, s, s3OnlySettings, "S3")
+	case "s3":
+		if s.Bucket == "" {
+			p.add(path+".bucket", "required for S3 storage")
+		} else if !s3BucketName.MatchString(s.Bucket) {
+			p.add(path+".bucket", "%q is not a valid S3 bucket name", s.Bucket)
+		}
+		if s.Region == "" {
+			p.add(path+".region", "required for S3 storage")
+		}
+		if s.ObjectLock.RetainDays < 0 {
+			p.add(path+".object_lock.retain_days", "must not be negative")
+		}
+		if opts, err := s3Options(s); err != nil {
+			p.add(path+".object_lock.retain_until", "%v", err)
+		} else {
+			p.check(path, opts.Validate())
+		}
+		notApplicable(p, path, s, localOnlySettings, "local")
+	default:
+		p.add(path+".type", "unsupported storage type %q, expected local or s3", s.Type)
+	}
+
+	switch s.Mode {
+	case "", "repository":
+	default:
+		p.add(path+".mode", "unsupported storage mode %q, expected repository", s.Mode)
+	}
+	if s.VolumeSize != "" {
+		_, err := config.ParseSize(s.VolumeSize)
+		p.check(path+".volume_size", err)
+	}
+	if s.UploadRate != "" {
+		_, err := config.ParseRate(s.UploadRate)
+		p.check(path+".upload_rate", err)
+	}
+}
+
+// notApplicable records the settings of a storage that only apply to
+// another type of storage
+func notApplicable(p *configProblems, path string, s config.StorageConfig, settings map[string]func(config.StorageConfig) bool, other string) {
+	keys := make([]string, 0, len(settings))
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		if settings[key](s) {
+			p.add(path+"."+key, "only applies to %s storage", other)
+		}
+	}
+}
+
+// liveChecks connects to the database and writes, reads and deletes a probe
+// object on every storage
+func liveChecks(ctx context.Context, cfg *config.Config) error {
+	var report preflight.Report
+	policy, err := retryPolicy(cfg.Retry)
+	if err != nil {
+		return err
+	}
+	policy.MaxAttempts = 1
+
+	doctorDatabase(ctx, &report, cfg, policy)
+	for _, storageCfg := range cfg.AllStorages() {
+		report.Add(doctorStorage(ctx, storageCfg))
+	}
+	report.Print(os.Stdout)
+	if report.Failed() {
+		return errors.New("live checks failed")
+	}
+	return nil
+}
@@ -0,0 +1,219 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/urfave/cli/v2"
+	"github.com/yeboahd24/dbBackupUitility/pkg/backup"
+	"github.com/yeboahd24/dbBackupUitility/pkg/config"
+	"github.com/yeboahd24/dbBackupUitility/pkg/storage"
+)
+
+func CopyCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "copy",
+		Usage: "Copy or move backups between configured storages",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:     "config",
+				Aliases:  []string{"c"},
+				Usage:    "Path to config file (optional, will auto-detect if not provided)",
+				Required: false,
+			},
+			&cli.StringFlag{
+				Name:     "from",
+				Usage:    "Name of the storage to copy from",
+				Required: true,
+			},
+			&cli.StringFlag{
+				Name:     "to",
+				Usage:    "Name of the storage to copy to",
+				Required: true,
+			},
+			&cli.StringFlag{
+				Name:  "since",
+				Usage: "Only copy backups newer than this age (e.g. 30d, 72h)",
+			},
+			&cli.StringFlag{
+				Name:  "prefix",
+				Usage: "Only copy backups whose name starts with this prefix",
+			},
+			&cli.BoolFlag{
+				Name:  "move",
+				Usage: "Delete backups from the source once copied and verified",
+			},
+			&cli.BoolFlag{
+				Name:  "dry-run",
+				Usage: "Only print the backups that would be copied",
+			},
+			&cli.StringFlag{
+				Name:  "upload-rate",
+				Usage: "Limit the combined upload rate of this run, e.g. 10MB/s (overrides upload_rate)",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			ctx := context.Background()
+			cfg, err := loadConfig(c)
+			if err != nil {
+				return fmt.Errorf("failed to load config: %w", err)
+			}
+			policy, err := retryPolicy(cfg.Retry)
+			if err != nil {
+				return err
+			}
+			limiter, err := uploadLimiter(c, cfg)
+			if err != nil {
+				return err
+			}
+
+			var cutoff time.Time
+			if since := c.String("since"); since != "" {
+				maxAge, err := config.ParseDuration(since)
+				if err != nil {
+					return err
+				}
+				cutoff = time.Now().Add(-maxAge)
+			}
+
+			if c.String("from") == c.String("to") {
+				return fmt.Errorf("source and destination storage must differ")
+			}
+			fromCfg, err := cfg.FindStorage(c.String("from"))
+			if err != nil {
+				return err
+			}
+			toCfg, err := cfg.FindStorage(c.String("to"))
+			if err != nil {
+				return err
+			}
+			src, err := initializeStorage(fromCfg, policy, nil, nil)
+			if err != nil {
+				return fmt.Errorf("failed to initialize storage %s: %w", fromCfg.StorageName(), err)
+			}
+			dst, err := initializeStorage(toCfg, policy, limiter, nil)
+			if err != nil {
+				return fmt.Errorf("failed to initialize storage %s: %w", toCfg.StorageName(), err)
+			}
+
+			objects, err := src.List(ctx, c.String("prefix"))
+			if err != nil {
+				return fmt.Errorf("failed to list backups: %w", err)
+			}
+			existing, err := dst.List(ctx, c.String("prefix"))
+			if err != nil {
+				return fmt.Errorf("failed to list destination: %w", err)
+			}
+			present := storedKeys(existing)
+			stored := storedKeys(objects)
+
+			var copied, skipped, failed int
+			for _, obj := range objects {
+				name := obj.Key
+				if backup.IsManifest(name) {
+					continue
+				}
+				if !cutoff.IsZero() {
+					created, ok := createdAt(ctx, src, obj)
+					if !ok || created.Before(cutoff) {
+						continue
+					}
+				}
+
+				if present[name] {
+					same, err := storage.InSync(ctx, src, dst, name)
+					if err != nil {
+						fmt.Printf("Failed to compare %s: %v\n", name, err)
+						failed++
+						continue
+					}
+					if same {
+						skipped++
+						if c.Bool("move") && !c.Bool("dry-run") {
+							n, retained := deleteBackup(ctx, src, name, stored[backup.ManifestName(name)])
+							if retained != nil {
+								fmt.Printf("%s already copied, source retained: %v\n", name, retained)
+							}
+							failed += n
+						}
+						continue
+					}
+				}
+
+				if c.Bool("dry-run") {
+					fmt.Printf("Would copy %s\n", name)
+					continue
+				}
+
+				if err := storage.Copy(ctx, src, dst, name); err != nil {
+					fmt.Printf("Failed to copy %s: %v\n", name, err)
+					failed++
+					continue
+				}
+				printVolumeWarnings(dst, name)
+				copied++
+				if !c.Bool("move") {
+					fmt.Printf("Copied %s\n", name)
+					continue
+				}
+				n, retained := deleteBackup(ctx, src, name, stored[backup.ManifestName(name)])
+				if retained != nil {
+					fmt.Printf("Copied %s, source retained: %v\n", name, retained)
+				} else {
+					fmt.Printf("Copied %s\n", name)
+				}
+				failed += n
+			}
+
+			fmt.Printf("Copied %d backup(s) from %s to %s, %d already up to date\n",
+				copied, fromCfg.StorageName(), toCfg.StorageName(), skipped)
+			if failed > 0 {
+				return fmt.Errorf("%d operation(s) failed", failed)
+			}
+			return nil
+		},
+	}
+}
+
+// createdAt returns when a backup was taken, preferring its manifest over the
+// timestamp embedded in its name and finally its modification time
+func createdAt(ctx context.Context, store backup.StorageProvider, obj backup.ObjectInfo) (time.Time, bool) {
+	if m, err := backup.ReadManifest(ctx, store, obj.Key); err == nil {
+		return m.CreatedAt, true
+	}
+	if created, ok := backupTime(obj.Key); ok {
+		return created, true
+	}
+	return obj.ModTime, !obj.ModTime.IsZero()
+}
+
+// storedKeys returns the set of keys of the listed objects
+func storedKeys(objects []backup.ObjectInfo) map[string]bool {
+	keys := make(map[string]bool, len(objects))
+	for _, obj := range objects {
+		keys[obj.Key] = true
+	}
+	return keys
+}
+
+// deleteBackup removes a backup and its manifest, if it has one, returning the
+// number of deletions that failed. A backup under object lock is retained
+// rather than failed: the lock error is returned for the caller to report.
+func deleteBackup(ctx context.Context, store backup.StorageProvider, name string, hasManifest bool) (failed int, retained error) {
+	if err := store.Delete(ctx, name); err != nil {
+		if errors.Is(err, storage.ErrObjectLocked) {
+			return 0, err
+		}
+		fmt.Printf("Failed to delete %s: %v\n", name, err)
+		return 1, nil
+	}
+	if manifest := backup.ManifestName(name); hasManifest {
+		if err := store.Delete(ctx, manifest); err != nil {
+			fmt.Printf("Failed to delete %s: %v\n", manifest, err)
+			failed++
+		}
+	}
+	return failed, nil
+}
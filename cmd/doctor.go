@@ -0,0 +1,185 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/urfave/cli/v2"
+	"github.com/yeboahd24/dbBackupUitility/pkg/backup"
+	"github.com/yeboahd24/dbBackupUitility/pkg/config"
+	"github.com/yeboahd24/dbBackupUitility/pkg/notification"
+	"github.com/yeboahd24/dbBackupUitility/pkg/preflight"
+	"github.com/yeboahd24/dbBackupUitility/pkg/retry"
+)
+
+func DoctorCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "doctor",
+		Usage: "Check the configuration, database, tools, storages and notifications",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:     "config",
+				Aliases:  []string{"c"},
+				Usage:    "Path to config file (optional, will auto-detect if not provided)",
+				Required: false,
+			},
+			&cli.BoolFlag{
+				Name:  "skip-notification",
+				Usage: "Do not send a test notification",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			ctx := context.Background()
+			var report preflight.Report
+			defer func() {
+				report.Print(os.Stdout)
+			}()
+
+			cfg, err := loadConfig(c)
+			if err != nil {
+				report.Add(preflight.Result{
+					Name: "Configuration",
+					Err:  err,
+					Hint: "create config.yml or pass its path with --config",
+				})
+				return fmt.Errorf("doctor found problems")
+			}
+			report.Add(preflight.Result{Name: "Configuration", Detail: "loaded"})
+
+			// Report failures at once rather than after several retries
+			policy, err := retryPolicy(cfg.Retry)
+			if err != nil {
+				report.Add(preflight.Result{Name: "Retry settings", Err: err})
+			}
+			policy.MaxAttempts = 1
+
+			doctorDatabase(ctx, &report, cfg, policy)
+			for _, storageCfg := range cfg.AllStorages() {
+				report.Add(doctorStorage(ctx, storageCfg))
+			}
+			if !c.Bool("skip-notification") {
+				report.Add(doctorNotification(cfg))
+			}
+
+			if report.Failed() {
+				return fmt.Errorf("doctor found problems")
+			}
+			return nil
+		},
+	}
+}
+
+// doctorDatabase checks the connection, the user's privileges and the client
+// tools against the server
+func doctorDatabase(ctx context.Context, report *preflight.Report, cfg *config.Config, policy retry.Policy) {
+	backuper, err := newBackuper(cfg.Database)
+	if err != nil {
+		report.Add(preflight.Result{Name: "Database", Err: err, Hint: "set database.type to postgres or mysql"})
+		return
+	}
+
+	db := cfg.Database
+	name := fmt.Sprintf("Database connection to %s@%s:%d/%s", db.Username, db.Host, db.Port, db.Database)
+	if db.Socket != "" {
+		name = fmt.Sprintf("Database connection to %s@%s/%s", db.Username, db.Socket, db.Database)
+	}
+	if db.SSHTunnel != nil {
+		name += fmt.Sprintf(" via %s@%s", db.SSHTunnel.User, db.SSHTunnel.Host)
+	}
+	if err := connect(ctx, backuper, policy); err != nil {
+		hint := "check database host, port, credentials and that the server accepts connections from this host"
+		if db.SSHTunnel != nil {
+			hint = "check the ssh_tunnel key and known_hosts, the database host, port and credentials, and that the server accepts connections from the bastion"
+		}
+		report.Add(preflight.Result{Name: name, Err: err, Hint: hint})
+		doctorReplicas(ctx, report, db, policy)
+		return
+	}
+	defer backuper.Close()
+	report.Add(preflight.Result{Name: name, Detail: "connected"})
+
+	if checker, ok := backuper.(backup.PrivilegeChecker); ok {
+		result := preflight.Result{Name: "Database privileges", Detail: "sufficient for a backup"}
+		if err := checker.CheckPrivileges(ctx); err != nil {
+			result.Err = err
+			result.Hint = "grant the backup user read access to every table, e.g. the pg_read_all_data role or SELECT, LOCK TABLES, SHOW VIEW and TRIGGER"
+		}
+		report.Add(result)
+	}
+	if checker, ok := backuper.(backup.ToolChecker); ok {
+		report.Add(checkTools(ctx, checker))
+	}
+	doctorReplicas(ctx, report, db, policy)
+}
+
+// doctorReplicas checks that every replica is healthy enough to dump from
+func doctorReplicas(ctx context.Context, report *preflight.Report, db config.DatabaseConfig, policy retry.Policy) {
+	maxLag, err := maxReplicationLag(db)
+	if err != nil && len(db.Replicas) > 0 {
+		report.Add(preflight.Result{Name: "Database replicas", Err: err})
+		return
+	}
+	for i := range db.Replicas {
+		replica := db.Replica(i)
+		result := preflight.Result{Name: "Database replica " + replica.Address()}
+		backuper, lag, err := connectReplica(ctx, replica, policy, maxLag)
+		if err != nil {
+			result.Err = err
+			result.Hint = "check that the replica is reachable and replicating, or raise max_replication_lag"
+			// Backups may still be dumped from another replica or the primary
+			result.Warning = true
+		} else {
+			backuper.Close()
+			result.Detail = fmt.Sprintf("replicating, %s behind the primary", lag.Round(time.Millisecond))
+		}
+		report.Add(result)
+	}
+}
+
+// doctorStorage writes, reads back and deletes a probe object
+func doctorStorage(ctx context.Context, storageCfg config.StorageConfig) preflight.Result {
+	result := preflight.Result{Name: "Storage " + storageCfg.StorageName(), Detail: "write, read and delete succeeded"}
+	if storageCfg.ObjectLock.Mode != "" {
+		// The probe object could not be deleted before its retention ends
+		result.Detail = "skipped, object lock is enabled"
+		return result
+	}
+
+	// Archive storage classes cannot be read back immediately
+	storageCfg.StorageClass = ""
+	provider, err := initializeProvider(storageCfg)
+	if err == nil {
+		err = preflight.RoundTrip(ctx, provider)
+	}
+	if err != nil {
+		result.Err = err
+		result.Hint = probeHint
+	}
+	return result
+}
+
+// doctorNotification sends a test notification when notifications are enabled
+func doctorNotification(cfg *config.Config) preflight.Result {
+	result := preflight.Result{Name: "Notifications"}
+	if !cfg.Notification.Enabled {
+		result.Detail = "disabled"
+		return result
+	}
+	if cfg.Notification.SlackWebhook == "" {
+		result.Err = fmt.Errorf("no slack_webhook configured")
+		result.Hint = "set notification.slack_webhook or disable notifications"
+		return result
+	}
+
+	host, _ := os.Hostname()
+	notifier := notification.NewSlackNotifier(cfg.Notification.SlackWebhook)
+	if err := notifier.Notify(fmt.Sprintf("Test notification from dbbackup doctor on %s", host)); err != nil {
+		result.Err = err
+		result.Hint = "check the Slack webhook URL and outbound network access"
+		return result
+	}
+	result.Detail = "test message sent"
+	return result
+}
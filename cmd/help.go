@@ -16,7 +16,7 @@ func HelpCommand() *cli.Command {
                 Name:  "backup",
                 Usage: "Show detailed help for backup command",
                 Action: func(c *cli.Context) error {
-                    fmt.Println(`
+                    fmt.Print(`
 BACKUP COMMAND
 -------------
 Performs database backup operations with various options.
@@ -28,21 +28,36 @@ Options:
   --type, -t     Backup type (full, incremental, differential) (default: "full")
   --output, -o   Output file path for local storage
   --config, -c   Path to config file (optional)
+  --overwrite      Replace an existing backup of the same name in local storage
+  --storage-class  Override the S3 storage class for this backup
+  --tag            Add S3 object tags as key=value (repeatable)
+  --retain-until   Override the S3 Object Lock retention date (YYYY-MM-DD)
+  --upload-rate    Limit the combined upload rate of this run, e.g. 10MB/s
+  --no-progress    Do not report progress
+  --progress-interval  Time between progress lines when not in a terminal (default: 30s)
+  --skip-preflight     Do not run the pre-flight checks
 
 Examples:
   1. Local backup:
      dbbackup backup --type full --output backup.dump
 
-  2. S3 backup (when storage.enabled is true):
+  2. Backup to the configured storages:
      dbbackup backup --type full
 
   3. Custom config:
      dbbackup backup -c /path/to/config.yml -t full -o backup.dump
 
+  4. S3 backup with per-run object settings:
+     dbbackup backup --storage-class GLACIER_IR --tag db=auth-db,env=prod
+
 Notes:
   - For S3 storage, ensure AWS credentials are properly configured
   - Incremental and differential backups depend on database support
-  - Output path is required when storage.enabled is false
+  - Output path is required when no storage is configured
+  - Progress is shown as a bar in a terminal and as periodic lines otherwise;
+    the total is estimated from the database size
+  - Pre-flight checks run before the dump: free space for local copies, a
+    write probe on every storage and the client tool versions
 `)
                     return nil
                 },
@@ -51,7 +66,7 @@ Notes:
                 Name:  "restore",
                 Usage: "Show detailed help for restore command",
                 Action: func(c *cli.Context) error {
-                    fmt.Println(`
+                    fmt.Print(`
 RESTORE COMMAND
 --------------
 Restores database from a backup file.
@@ -61,13 +76,17 @@ Usage:
 
 Options:
   --file, -f     Backup file to restore from
+  --storage      Name of the storage to restore from (optional)
   --config, -c   Path to config file (optional)
+  --no-progress  Do not report progress
+  --progress-interval  Time between progress lines when not in a terminal (default: 30s)
+  --skip-preflight     Do not run the pre-flight checks
 
 Examples:
   1. Local restore:
      dbbackup restore --file backup.dump
 
-  2. S3 restore (when storage.enabled is true):
+  2. Restore from the configured storages:
      dbbackup restore --file backup_name.dump
 
   3. Custom config:
@@ -77,6 +96,184 @@ Notes:
   - Ensure target database exists and is accessible
   - User must have sufficient privileges for restore operation
   - For S3 restores, ensure AWS credentials are properly configured
+`)
+                    return nil
+                },
+            },
+            {
+                Name:  "prune",
+                Usage: "Show detailed help for prune command",
+                Action: func(c *cli.Context) error {
+                    fmt.Print(`
+PRUNE COMMAND
+------------
+Moves aging backups to colder storage tiers and deletes backups older than a
+given age from every configured storage.
+
+Usage:
+  dbbackup prune [--older-than <age>] [options]
+
+Options:
+  --older-than   Delete backups older than this age (e.g. 30d, 72h)
+  --prefix       Only delete backups whose name starts with this prefix
+  --no-tiering   Do not move backups between storage tiers
+  --dry-run      Only print the backups that would be moved or deleted
+  --config, -c   Path to config file (optional)
+
+Examples:
+  1. Preview what would be deleted:
+     dbbackup prune --older-than 30d --dry-run
+
+  2. Delete backups older than 90 days:
+     dbbackup prune --older-than 90d
+
+  3. Only apply storage tiering:
+     dbbackup prune
+
+Notes:
+  - Requires at least one storage to be configured
+  - S3 objects still under Object Lock retention are skipped, not failed
+  - Tiered backups are verified on the colder storage before being removed
+  - Unreferenced chunks are removed from repository-mode storages
+`)
+                    return nil
+                },
+            },
+            {
+                Name:  "copy",
+                Usage: "Show detailed help for copy command",
+                Action: func(c *cli.Context) error {
+                    fmt.Print(`
+COPY COMMAND
+-----------
+Copies or moves backups between two configured storages.
+
+Usage:
+  dbbackup copy --from <storage> --to <storage> [options]
+
+Options:
+  --from         Name of the storage to copy from
+  --to           Name of the storage to copy to
+  --since        Only copy backups newer than this age (e.g. 30d, 72h)
+  --prefix       Only copy backups whose name starts with this prefix
+  --move         Delete backups from the source once copied and verified
+  --dry-run      Only print the backups that would be copied
+  --upload-rate  Limit the upload rate, e.g. 10MB/s
+  --config, -c   Path to config file (optional)
+
+Examples:
+  1. Mirror the last 30 days of local backups to S3:
+     dbbackup copy --from local --to offsite --since 30d
+
+  2. Move everything to another bucket:
+     dbbackup copy --from offsite --to archive --move
+
+Notes:
+  - Storages are referenced by their name (or type, if unnamed)
+  - Backups already present with a matching checksum are skipped
+  - Every copy is read back and verified before the source is deleted
+  - Manifests are copied along with their backups
+`)
+                    return nil
+                },
+            },
+            {
+                Name:  "verify",
+                Usage: "Show detailed help for verify command",
+                Action: func(c *cli.Context) error {
+                    fmt.Print(`
+VERIFY COMMAND
+-------------
+Verifies stored backups against the checksums recorded in their manifests.
+
+Usage:
+  dbbackup verify --file <backup> [options]
+
+Options:
+  --file, -f     Backup file to verify
+  --storage      Name of the storage to verify (optional)
+  --config, -c   Path to config file (optional)
+
+Examples:
+  1. Verify every copy of a backup:
+     dbbackup verify --file backup_name.dump
+
+  2. Verify the copy on one storage:
+     dbbackup verify --file backup_name.dump --storage offsite
+
+Notes:
+  - Split backups have each volume checked before the complete backup
+`)
+                    return nil
+                },
+            },
+            {
+                Name:  "spool",
+                Usage: "Show detailed help for spool command",
+                Action: func(c *cli.Context) error {
+                    fmt.Print(`
+SPOOL COMMAND
+-------------
+Uploads backups waiting in the local spool. When spool.path is configured,
+backups are written there first and uploads that fail stay queued.
+
+Usage:
+  dbbackup spool flush [options]
+  dbbackup spool status [options]
+
+Options:
+  --watch        Keep running and flush the spool periodically
+  --interval     Time between flushes with --watch (default: 5m)
+  --upload-rate  Limit the combined upload rate, e.g. 10MB/s
+  --config, -c   Path to config file (optional)
+
+Examples:
+  1. Upload all queued backups once:
+     dbbackup spool flush
+
+  2. Run as a background uploader:
+     dbbackup spool flush --watch --interval 10m
+
+  3. Show queued backups and spool usage:
+     dbbackup spool status
+
+Notes:
+  - The queue is kept in the spool directory and survives restarts
+  - Backups being uploaded by another process are skipped
+  - spool flush exits with an error while uploads remain pending
+`)
+                    return nil
+                },
+            },
+            {
+                Name:  "doctor",
+                Usage: "Show detailed help for doctor command",
+                Action: func(c *cli.Context) error {
+                    fmt.Print(`
+DOCTOR COMMAND
+-------------
+Checks the whole setup and prints a pass/fail checklist with hints.
+
+Usage:
+  dbbackup doctor [options]
+
+Options:
+  --config, -c         Path to config file (optional)
+  --skip-notification  Do not send a test notification
+
+Checks:
+  - The configuration file loads
+  - The database accepts the connection and the user can read every table
+  - pg_dump/pg_restore or mysqldump/mysql are installed and match the server
+  - Every storage accepts a write, read and delete of a small probe object
+  - A test notification is delivered when notifications are enabled
+
+Examples:
+  1. Check the default configuration:
+     dbbackup doctor
+
+  2. Check another configuration without notifying:
+     dbbackup doctor -c /path/to/config.yml --skip-notification
 `)
                     return nil
                 },
@@ -85,32 +282,85 @@ Notes:
                 Name:  "config",
                 Usage: "Show detailed help for config command",
                 Action: func(c *cli.Context) error {
-                    fmt.Println(`
+                    fmt.Print(`
 CONFIG COMMAND
 -------------
 Manages and validates configuration settings.
 
 Usage:
   dbbackup config validate [options]
+  dbbackup config show [options]
+  dbbackup config schema
+  dbbackup config migrate [options]
+  dbbackup config init [options]
+  dbbackup config encrypt [options]
+  dbbackup config decrypt [options]
+  dbbackup config edit [options]
+  dbbackup config keygen [options]
 
 Options:
-  --config, -c   Path to config file (optional)
+  --config, -c       Path to config file (optional)
+  --live             Also test the database and storages (validate)
+  --origin           Show where each value comes from (show)
+  --format           Output format, yaml or json (show)
+  --path             File to write (init)
+  --non-interactive  Take every setting from flags such as --db-host (init)
+  --skip-checks      Do not test the database and storage (init)
+  --age-recipient    age public key to encrypt for, repeatable (encrypt)
+  --passphrase       Encrypt with a passphrase (encrypt)
+  --in-place         Replace the file instead of printing it (decrypt)
+  --dry-run          Print the migrated file instead of replacing it (migrate)
+  --output           Key file to write (keygen)
 
 Examples:
   1. Validate default config:
      dbbackup config validate
 
-  2. Validate specific config:
-     dbbackup config validate -c /path/to/config.yml
+  2. Validate specific config and test the connections:
+     dbbackup config validate -c /path/to/config.yml --live
+
+  3. Encrypt passwords, keys and webhooks with a new age key:
+     dbbackup config keygen
+     dbbackup config encrypt --age-recipient age1...
+
+  4. Edit an encrypted config:
+     dbbackup config edit
+
+  5. Show the merged config and where each value comes from:
+     dbbackup --set upload_rate=5MB/s config show --origin
+
+  6. Create a config file, testing the connection and storage:
+     dbbackup config init
+
+  7. Upgrade a config file written for an older release:
+     dbbackup config migrate -c /path/to/config.yml
+
+Unknown keys are rejected with the file and line they were set at, and
+validate lists every problem found. 'config schema' prints a JSON Schema
+for editor completion. Files in an older format are upgraded when loaded,
+with a warning, until 'config migrate' rewrites them.
+
+Encrypted values are decrypted with the age identities in DBBACKUP_AGE_KEY,
+DBBACKUP_AGE_KEY_FILE or ~/.config/dbbackup/age.key, or the passphrase in
+DBBACKUP_PASSPHRASE.
 
 Config File Locations:
-  The utility searches for config.yml in these locations:
-  1. Current directory
+  Every config.yml found is loaded, and values from earlier locations
+  override later ones:
+  1. Current directory, or the file given with --config
   2. $HOME/.dbbackup/
-  3. /etc/dbbackup/
-  4. $XDG_CONFIG_HOME/dbbackup/
+  3. $XDG_CONFIG_HOME/dbbackup/
+  4. /etc/dbbackup/
+  A file given with --config replaces only the one in the current directory;
+  the user and system files are still merged beneath it unless the global
+  --no-default-config flag is given.
+  DBBACKUP_* environment variables (e.g. DBBACKUP_DATABASE_HOST) override
+  the files, and the global --set key=value flag overrides everything.
+  A file can load others first with include: [file, conf.d/*.yml].
 
 Required Configuration:
+  version: 2                 # format of the file
+
   database:
     type: postgres|mysql
     host: <hostname>
@@ -118,13 +368,77 @@ Required Configuration:
     username: <username>
     password: <password>
     database: <dbname>
+    dump_compression: <0-9>  # optional, pg_dump compression level
+    nice: <1-19>             # optional, CPU priority of dump and restore tools
+    ionice_class: idle|best-effort   # optional, I/O priority (Linux only)
+    ionice_level: <0-7>      # optional, for best-effort
+    socket: <path>           # optional, Unix socket instead of host and port
+    ssh_tunnel:              # optional, connect through an SSH bastion
+      host: <bastion>
+      port: <port>           # default: 22
+      user: <user>
+      key: <private-key-file>      # default: keys of ssh-agent
+      key_passphrase: <passphrase> # for an encrypted key
+      known_hosts: <file>    # default: ~/.ssh/known_hosts
+    replicas:                # optional, standbys of this primary
+      - host: <hostname>     # or socket: <path>
+        port: <port>         # default: the primary's port
+    dump_from: primary|replica         # optional, default: primary
+    max_replication_lag: <duration>    # optional, e.g. 5m
+    replica_fallback: primary|fail     # optional, without a healthy replica
+    ssl_mode: disable|require|verify-ca|verify-full   # optional, TLS (default: disable)
+    ssl_ca: <file>           # optional, CA certificate verifying the server
+    ssl_cert: <file>         # optional, client certificate
+    ssl_key: <file>          # optional, client certificate key
 
-  storage:
-    enabled: true|false
-    type: local|s3
-    bucket: <bucket-name>    # for S3
-    region: <region>         # for S3
-    path: <local-path>       # for local
+  storages:                  # destinations every backup is copied to
+    - name: <name>           # optional, defaults to the type
+      type: local|s3
+      disabled: true|false   # optional, keep the settings but leave it out
+      mode: repository       # optional, deduplicate backups into chunks
+      volume_size: <size>    # optional, split backups into volumes, e.g. 5GiB
+      upload_rate: <rate>    # optional, limit uploads to this storage, e.g. 10MB/s
+      bucket: <bucket-name>  # for S3
+      region: <region>       # for S3
+      path: <local-path>     # for local
+      overwrite: true|false  # for local, replace existing backups (default: false)
+      storage_class: <class> # for S3, e.g. STANDARD_IA, GLACIER_IR
+      server_side_encryption: AES256|aws:kms   # for S3
+      kms_key_id: <key-id>   # for S3 with aws:kms
+      tags:                  # for S3
+        <key>: <value>
+      object_lock:           # for S3
+        mode: GOVERNANCE|COMPLIANCE
+        retain_days: <days>
+  storage_policy: all|any|majority
+
+  naming_template: <template>  # optional, e.g. {env}/{database}/{yyyy}/{mm}/{id}.{ext}
+  labels:                      # optional extra template placeholders
+    <name>: <value>
+
+  tiering:                   # optional, applied by prune
+    - after: <age>           # e.g. 7d
+      storage:
+        name: <name>
+        type: local|s3
+        ...
+
+  upload_rate: <rate>        # optional, limit all uploads of a run together
+
+  preflight:                 # optional
+    disabled: true|false
+    size_factor: <fraction>  # expected backup size relative to the database size (default: 1)
+
+  retry:                     # optional, for connections, storage and notifications
+    max_attempts: <n>        # default: 3
+    base_delay: <duration>   # default: 1s, doubled after each attempt
+    max_delay: <duration>    # default: 30s
+    jitter: <fraction>       # default: 0.2
+
+  spool:                     # optional, write backups locally before uploading
+    path: <local-path>
+    max_size: <size>         # backups that do not fit fail, e.g. 50GiB
+    alert_size: <size>       # warn and notify above this size, e.g. 20GiB
 
   notification:
     slack_webhook: <webhook-url>
@@ -0,0 +1,147 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/yeboahd24/dbBackupUitility/pkg/backup"
+	"github.com/yeboahd24/dbBackupUitility/pkg/config"
+	"github.com/yeboahd24/dbBackupUitility/pkg/preflight"
+	"github.com/yeboahd24/dbBackupUitility/pkg/progress"
+	"github.com/yeboahd24/dbBackupUitility/pkg/retry"
+	"github.com/yeboahd24/dbBackupUitility/pkg/storage"
+)
+
+const (
+	freeSpaceHint = "free up space, or set preflight.size_factor to the expected backup size relative to the database size"
+	probeHint     = "check the storage credentials, permissions and network access"
+	toolsHint     = "install the database client tools matching the server version"
+)
+
+// backupPreflight checks that the backup has room wherever it is written
+// locally, that the storages accept writes and that the dump tools work,
+// before any data is dumped
+func backupPreflight(ctx context.Context, cfg *config.Config, backuper backup.DatabaseBackuper,
+	storageCfgs []config.StorageConfig, policy retry.Policy, output string, estimate int64) error {
+	var report preflight.Report
+
+	need := int64(0)
+	if estimate > 0 {
+		factor := cfg.Preflight.SizeFactor
+		if factor <= 0 {
+			factor = 1
+		}
+		need = int64(float64(estimate) * factor)
+		report.Add(preflight.Result{Name: "Database size", Detail: "~" + progress.FormatSize(estimate)})
+	} else {
+		report.Add(preflight.Result{Name: "Database size", Detail: "unknown"})
+	}
+
+	if len(storageCfgs) == 0 && output != "" {
+		report.Add(checkFreeSpace("Free space for "+output, filepath.Dir(output), need))
+	}
+	if cfg.Spool.Path != "" {
+		report.Add(checkFreeSpace("Free space in spool", cfg.Spool.Path, need))
+	}
+	for _, storageCfg := range storageCfgs {
+		if storageCfg.Type == "local" {
+			report.Add(checkFreeSpace("Free space on "+storageCfg.StorageName(), storageCfg.Path, need))
+		}
+	}
+
+	for _, storageCfg := range storageCfgs {
+		result := probeStorage(ctx, storageCfg, policy)
+		// Spooled backups are uploaded later, so an unreachable storage
+		// does not stop the backup
+		result.Warning = cfg.Spool.Path != ""
+		report.Add(result)
+	}
+
+	if checker, ok := backuper.(backup.ToolChecker); ok {
+		report.Add(checkTools(ctx, checker))
+	}
+	return finishPreflight(&report)
+}
+
+// restorePreflight checks that the backup fits into the temporary directory
+// and that the restore tools work
+func restorePreflight(ctx context.Context, backuper backup.DatabaseBackuper, size int64) error {
+	var report preflight.Report
+	report.Add(checkFreeSpace("Free space in temporary directory", os.TempDir(), size))
+	if checker, ok := backuper.(backup.ToolChecker); ok {
+		// Version requirements concern the dump tool, which a restore
+		// does not run
+		result := checkTools(ctx, checker)
+		result.Warning = true
+		report.Add(result)
+	}
+	return finishPreflight(&report)
+}
+
+func finishPreflight(report *preflight.Report) error {
+	fmt.Println("Pre-flight checks:")
+	report.Print(os.Stdout)
+	if report.Failed() {
+		return fmt.Errorf("pre-flight checks failed")
+	}
+	return nil
+}
+
+// checkFreeSpace checks that the file system holding path has at least need
+// bytes available. Nothing is required when need is unknown (0).
+func checkFreeSpace(name, path string, need int64) preflight.Result {
+	result := preflight.Result{Name: name}
+	free, err := preflight.FreeSpace(path)
+	switch {
+	case errors.Is(err, preflight.ErrUnsupported):
+		result.Detail = "unknown on this platform"
+	case err != nil:
+		result.Err = fmt.Errorf("failed to determine free space of %s: %w", path, err)
+	case free < need:
+		result.Err = fmt.Errorf("%s free on %s, ~%s needed", progress.FormatSize(free), path, progress.FormatSize(need))
+		result.Hint = freeSpaceHint
+	default:
+		result.Detail = progress.FormatSize(free) + " free on " + path
+	}
+	return result
+}
+
+// probeStorage writes and deletes a small object on the storage
+func probeStorage(ctx context.Context, storageCfg config.StorageConfig, policy retry.Policy) preflight.Result {
+	result := preflight.Result{Name: "Write access to " + storageCfg.StorageName()}
+	if storageCfg.ObjectLock.Mode != "" {
+		// The probe object could not be deleted before its retention ends
+		result.Detail = "skipped, object lock is enabled"
+		return result
+	}
+
+	provider, err := initializeProvider(storageCfg)
+	if err == nil {
+		err = preflight.ProbeStorage(ctx, storage.NewRetryStorage(provider, policy))
+	}
+	if err != nil {
+		result.Err = err
+		result.Hint = probeHint
+	}
+	return result
+}
+
+// checkTools reports the client tools found and whether they fit the server
+func checkTools(ctx context.Context, checker backup.ToolChecker) preflight.Result {
+	result := preflight.Result{Name: "Client tools"}
+	tools, err := checker.CheckTools(ctx)
+	var found []string
+	for _, tool := range tools {
+		found = append(found, tool.Name+" "+tool.Version)
+	}
+	result.Detail = strings.Join(found, ", ")
+	if err != nil {
+		result.Err = err
+		result.Hint = toolsHint
+	}
+	return result
+}
@@ -0,0 +1,192 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+	"github.com/yeboahd24/dbBackupUitility/pkg/backup"
+	"github.com/yeboahd24/dbBackupUitility/pkg/config"
+	"github.com/yeboahd24/dbBackupUitility/pkg/repository"
+	"github.com/yeboahd24/dbBackupUitility/pkg/storage"
+)
+
+func PruneCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "prune",
+		Usage: "Move aging backups to colder tiers and delete old backups from storage",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:     "config",
+				Aliases:  []string{"c"},
+				Usage:    "Path to config file (optional, will auto-detect if not provided)",
+				Required: false,
+			},
+			&cli.StringFlag{
+				Name:  "older-than",
+				Usage: "Delete backups older than this age (e.g. 30d, 72h)",
+			},
+			&cli.StringFlag{
+				Name:  "prefix",
+				Usage: "Only delete backups whose name starts with this prefix",
+			},
+			&cli.BoolFlag{
+				Name:  "no-tiering",
+				Usage: "Do not move backups between storage tiers",
+			},
+			&cli.BoolFlag{
+				Name:  "dry-run",
+				Usage: "Only print the backups that would be moved or deleted",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			ctx := context.Background()
+			cfg, err := loadConfig(c)
+			if err != nil {
+				return fmt.Errorf("failed to load config: %w", err)
+			}
+			if len(cfg.StorageTargets()) == 0 {
+				return fmt.Errorf("prune requires storage to be enabled")
+			}
+
+			tiering := len(cfg.Tiering) > 0 && !c.Bool("no-tiering")
+			if c.String("older-than") == "" && !tiering {
+				return fmt.Errorf("--older-than is required when no storage tiering is configured")
+			}
+
+			policy, err := retryPolicy(cfg.Retry)
+			if err != nil {
+				return err
+			}
+			limiter, err := uploadLimiter(c, cfg)
+			if err != nil {
+				return err
+			}
+			targets, err := initializeTargets(cfg.AllStorages(), policy, nil, nil)
+			if err != nil {
+				return fmt.Errorf("failed to initialize storage: %w", err)
+			}
+
+			var deleted, moved, locked, failed int
+			if tiering {
+				moved, failed, err = applyTiering(ctx, cfg, policy, limiter, c.Bool("dry-run"))
+				if err != nil {
+					return fmt.Errorf("failed to apply storage tiering: %w", err)
+				}
+			}
+
+			if olderThan := c.String("older-than"); olderThan != "" {
+				maxAge, err := config.ParseDuration(olderThan)
+				if err != nil {
+					return err
+				}
+				cutoff := time.Now().Add(-maxAge)
+
+				for _, target := range targets {
+					d, l, f := pruneTarget(ctx, target, c.String("prefix"), cutoff, c.Bool("dry-run"))
+					deleted += d
+					locked += l
+					failed += f
+				}
+			}
+
+			// Reclaim repository chunks no longer referenced by any backup
+			if !c.Bool("dry-run") {
+				for _, target := range targets {
+					repo, ok := target.Provider.(*repository.Repository)
+					if !ok {
+						continue
+					}
+					removed, err := repo.GC(ctx)
+					if err != nil {
+						fmt.Printf("Failed to collect garbage on %s: %v\n", target.Name, err)
+						failed++
+					}
+					if removed > 0 {
+						fmt.Printf("Removed %d unreferenced chunk(s) from %s\n", removed, target.Name)
+					}
+				}
+			}
+
+			fmt.Printf("Moved %d backup(s) to colder storage, pruned %d, %d still locked\n", moved, deleted, locked)
+			return pruneResult(failed)
+		},
+	}
+}
+
+// pruneTarget deletes the backups created before cutoff from a storage and
+// returns the number of backups deleted, still locked and failed
+func pruneTarget(ctx context.Context, target storage.Target, prefix string, cutoff time.Time, dryRun bool) (int, int, int) {
+	objects, err := target.Provider.List(ctx, prefix)
+	if err != nil {
+		fmt.Printf("Failed to list backups on %s: %v\n", target.Name, err)
+		return 0, 0, 1
+	}
+	stored := storedKeys(objects)
+
+	var deleted, locked, failed int
+	for _, obj := range objects {
+		name := obj.Key
+		if backup.IsManifest(name) {
+			continue
+		}
+		if created, ok := createdAt(ctx, target.Provider, obj); !ok || !created.Before(cutoff) {
+			continue
+		}
+
+		if dryRun {
+			fmt.Printf("Would delete %s from %s\n", name, target.Name)
+			continue
+		}
+
+		err := target.Provider.Delete(ctx, name)
+		switch {
+		case errors.Is(err, storage.ErrObjectLocked):
+			// Locked objects are expected under compliance retention;
+			// they become prunable once the lock expires
+			fmt.Printf("Skipping %v\n", err)
+			locked++
+			continue
+		case err != nil:
+			fmt.Printf("Failed to delete %s from %s: %v\n", name, target.Name, err)
+			failed++
+			continue
+		}
+		fmt.Printf("Deleted %s from %s\n", name, target.Name)
+		deleted++
+
+		if manifest := backup.ManifestName(name); stored[manifest] {
+			if err := target.Provider.Delete(ctx, manifest); err != nil {
+				fmt.Printf("Failed to delete %s from %s: %v\n", manifest, target.Name, err)
+				failed++
+			}
+		}
+	}
+	return deleted, locked, failed
+}
+
+func pruneResult(failed int) error {
+	if failed > 0 {
+		return fmt.Errorf("%d prune operation(s) failed", failed)
+	}
+	return nil
+}
+
+// backupTime extracts the creation time from a backup name produced by the
+// default naming template (backup_<database>_<timestamp>.dump)
+func backupTime(name string) (time.Time, bool) {
+	base := strings.TrimSuffix(path.Base(name), path.Ext(name))
+	if !strings.HasPrefix(base, "backup_") {
+		return time.Time{}, false
+	}
+	i := strings.LastIndex(base, "_")
+	created, err := time.ParseInLocation(backup.TimestampFormat, base[i+1:], time.Local)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return created, true
+}
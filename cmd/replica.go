@@ -0,0 +1,100 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/yeboahd24/dbBackupUitility/pkg/backup"
+	"github.com/yeboahd24/dbBackupUitility/pkg/config"
+	"github.com/yeboahd24/dbBackupUitility/pkg/retry"
+)
+
+// connectDumpNode connects to the server a backup is dumped from: with
+// dump_from replica, the first healthy replica, otherwise, or when none is
+// healthy and replica_fallback is not fail, the primary
+func connectDumpNode(ctx context.Context, db config.DatabaseConfig, policy retry.Policy) (backup.DatabaseBackuper, *backup.Node, error) {
+	if db.DumpFrom == "replica" {
+		maxLag, err := maxReplicationLag(db)
+		if err != nil {
+			return nil, nil, err
+		}
+		var problems []string
+		for i := range db.Replicas {
+			replica := db.Replica(i)
+			backuper, lag, err := connectReplica(ctx, replica, policy, maxLag)
+			if err != nil {
+				fmt.Printf("Warning: not dumping from replica %s: %v\n", replica.Address(), err)
+				problems = append(problems, fmt.Sprintf("%s: %v", replica.Address(), err))
+				continue
+			}
+			seconds := lag.Seconds()
+			return backuper, &backup.Node{Role: "replica", Address: replica.Address(), LagSeconds: &seconds}, nil
+		}
+		if db.ReplicaFallback == "fail" {
+			if len(problems) == 0 {
+				return nil, nil, errors.New("no replica configured to dump from")
+			}
+			return nil, nil, fmt.Errorf("no healthy replica to dump from: %s", strings.Join(problems, "; "))
+		}
+		fmt.Println("Warning: no healthy replica, dumping from the primary")
+	}
+
+	backuper, err := newBackuper(db)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := connect(ctx, backuper, policy); err != nil {
+		return nil, nil, err
+	}
+	return backuper, &backup.Node{Role: "primary", Address: db.Address()}, nil
+}
+
+// connectReplica connects to a replica and returns it with its lag if it is
+// healthy: in recovery, replicating and no further behind than maxLag, when
+// set
+func connectReplica(ctx context.Context, db config.DatabaseConfig, policy retry.Policy, maxLag time.Duration) (backup.DatabaseBackuper, time.Duration, error) {
+	backuper, err := newBackuper(db)
+	if err != nil {
+		return nil, 0, err
+	}
+	if err := connect(ctx, backuper, policy); err != nil {
+		return nil, 0, err
+	}
+	checker, ok := backuper.(backup.ReplicationChecker)
+	if !ok {
+		backuper.Close()
+		return nil, 0, fmt.Errorf("replication status of %s databases cannot be checked", db.Type)
+	}
+
+	status, err := checker.ReplicationStatus(ctx)
+	switch {
+	case err != nil:
+	case !status.Replica:
+		err = errors.New("not a replica, it may have been promoted")
+	case status.Lag < 0:
+		err = errors.New("replication lag unknown, replication may be stopped")
+	case maxLag > 0 && status.Lag > maxLag:
+		err = fmt.Errorf("replication lag %s exceeds max_replication_lag %s", status.Lag.Round(time.Second), maxLag)
+	}
+	if err != nil {
+		backuper.Close()
+		return nil, 0, err
+	}
+	return backuper, status.Lag, nil
+}
+
+// maxReplicationLag returns the lag above which replicas are not dumped
+// from, or 0 to accept any known lag
+func maxReplicationLag(db config.DatabaseConfig) (time.Duration, error) {
+	if db.MaxReplicationLag == "" {
+		return 0, nil
+	}
+	lag, err := config.ParseDuration(db.MaxReplicationLag)
+	if err != nil {
+		return 0, fmt.Errorf("invalid max_replication_lag: %w", err)
+	}
+	return lag, nil
+}
@@ -0,0 +1,241 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yeboahd24/dbBackupUitility/pkg/backup"
+	"github.com/yeboahd24/dbBackupUitility/pkg/config"
+	"github.com/yeboahd24/dbBackupUitility/pkg/retry"
+)
+
+// fakeDatabase is a server whose connection and replication status are set
+// by the test
+type fakeDatabase struct {
+	connectErr error
+	status     backup.ReplicationStatus
+	statusErr  error
+	connected  bool
+	closed     bool
+}
+
+func (f *fakeDatabase) Connect(ctx context.Context) error {
+	if f.connectErr != nil {
+		return f.connectErr
+	}
+	f.connected = true
+	return nil
+}
+
+func (f *fakeDatabase) Backup(ctx context.Context, backupType backup.BackupType) (io.Reader, error) {
+	return strings.NewReader("dump"), nil
+}
+
+func (f *fakeDatabase) Restore(ctx context.Context, backupFile io.Reader) error {
+	return nil
+}
+
+func (f *fakeDatabase) Close() error {
+	f.closed = true
+	return nil
+}
+
+func (f *fakeDatabase) ReplicationStatus(ctx context.Context) (backup.ReplicationStatus, error) {
+	return f.status, f.statusErr
+}
+
+// fakeDatabases makes the servers of database type fake those given by host
+func fakeDatabases(t *testing.T, servers map[string]*fakeDatabase) {
+	t.Helper()
+	databaseBackupers["fake"] = func(cfg config.DatabaseConfig) backup.DatabaseBackuper {
+		server, ok := servers[cfg.Host]
+		if !ok {
+			t.Fatalf("no fake database %s", cfg.Host)
+		}
+		return server
+	}
+	t.Cleanup(func() { delete(databaseBackupers, "fake") })
+}
+
+func replica(lag time.Duration) *fakeDatabase {
+	return &fakeDatabase{status: backup.ReplicationStatus{Replica: true, Lag: lag}}
+}
+
+func TestConnectDumpNode(t *testing.T) {
+	policy := retry.Policy{MaxAttempts: 1}
+	tests := []struct {
+		name     string
+		servers  map[string]*fakeDatabase
+		dumpFrom string
+		fallback string
+		maxLag   string
+		want     string // host of the node dumped from
+		wantLag  time.Duration
+		wantErr  []string
+	}{
+		{
+			name:    "primary",
+			servers: map[string]*fakeDatabase{"primary": {}},
+			want:    "primary",
+		},
+		{
+			name:     "first healthy replica",
+			servers:  map[string]*fakeDatabase{"r1": replica(3 * time.Second), "r2": replica(0)},
+			dumpFrom: "replica",
+			want:     "r1",
+			wantLag:  3 * time.Second,
+		},
+		{
+			name:     "lagging replica skipped",
+			servers:  map[string]*fakeDatabase{"r1": replica(10 * time.Minute), "r2": replica(5 * time.Second)},
+			dumpFrom: "replica",
+			maxLag:   "1m",
+			want:     "r2",
+			wantLag:  5 * time.Second,
+		},
+		{
+			name:     "any known lag without max_replication_lag",
+			servers:  map[string]*fakeDatabase{"r1": replica(10 * time.Minute), "r2": replica(0)},
+			dumpFrom: "replica",
+			want:     "r1",
+			wantLag:  10 * time.Minute,
+		},
+		{
+			// A standby that is not streaming has replayed all it received,
+			// while falling behind
+			name:     "unknown lag rejected",
+			servers:  map[string]*fakeDatabase{"r1": replica(-1), "r2": replica(2 * time.Second)},
+			dumpFrom: "replica",
+			want:     "r2",
+			wantLag:  2 * time.Second,
+		},
+		{
+			name: "unhealthy replicas fall back to the primary",
+			servers: map[string]*fakeDatabase{
+				"primary": {},
+				"r1":      replica(-1),
+				"r2":      replica(time.Hour),
+			},
+			dumpFrom: "replica",
+			fallback: "primary",
+			maxLag:   "1m",
+			want:     "primary",
+		},
+		{
+			name: "unhealthy replicas fail",
+			servers: map[string]*fakeDatabase{
+				"r1": replica(-1),
+				"r2": replica(time.Hour),
+			},
+			dumpFrom: "replica",
+			fallback: "fail",
+			maxLag:   "1m",
+			wantErr: []string{
+				"no healthy replica to dump from",
+				"r1:5432: replication lag unknown, replication may be stopped",
+				"r2:5432: replication lag 1h0m0s exceeds max_replication_lag 1m0s",
+			},
+		},
+		{
+			name: "promoted and unreachable replicas fail",
+			servers: map[string]*fakeDatabase{
+				"r1": {status: backup.ReplicationStatus{Replica: false}},
+				"r2": {connectErr: errors.New("connection refused")},
+			},
+			dumpFrom: "replica",
+			fallback: "fail",
+			wantErr: []string{
+				"r1:5432: not a replica, it may have been promoted",
+				"r2:5432: connection refused",
+			},
+		},
+		{
+			name: "status error",
+			servers: map[string]*fakeDatabase{
+				"r1": {statusErr: errors.New("permission denied")},
+				"r2": {statusErr: errors.New("permission denied")},
+			},
+			dumpFrom: "replica",
+			fallback: "fail",
+			wantErr:  []string{"r1:5432: permission denied"},
+		},
+		{
+			name:     "invalid max_replication_lag",
+			servers:  map[string]*fakeDatabase{},
+			dumpFrom: "replica",
+			maxLag:   "soon",
+			wantErr:  []string{`invalid max_replication_lag: invalid duration "soon"`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeDatabases(t, tt.servers)
+			db := config.DatabaseConfig{
+				Type:              "fake",
+				Host:              "primary",
+				Port:              5432,
+				DumpFrom:          tt.dumpFrom,
+				ReplicaFallback:   tt.fallback,
+				MaxReplicationLag: tt.maxLag,
+				Replicas:          []config.ReplicaConfig{{Host: "r1"}, {Host: "r2"}},
+			}
+
+			backuper, node, err := connectDumpNode(context.Background(), db, policy)
+			if len(tt.wantErr) > 0 {
+				for _, want := range tt.wantErr {
+					if err == nil || !strings.Contains(err.Error(), want) {
+						t.Errorf("connectDumpNode returned %v, want an error containing %q", err, want)
+					}
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			} else {
+				if backuper != tt.servers[tt.want] {
+					t.Errorf("dumping from %+v, want %s", node, tt.want)
+				}
+				wantNode := backup.Node{Role: "replica", Address: tt.want + ":5432"}
+				if tt.want == "primary" {
+					wantNode.Role = "primary"
+				}
+				if node.Role != wantNode.Role || node.Address != wantNode.Address {
+					t.Errorf("node is %+v, want %+v", node, wantNode)
+				}
+				if node.Role == "replica" && (node.LagSeconds == nil || *node.LagSeconds != tt.wantLag.Seconds()) {
+					t.Errorf("node lag is %v, want %s", node.LagSeconds, tt.wantLag)
+				}
+			}
+
+			// Every replica rejected is closed, the one dumped from is not
+			for host, server := range tt.servers {
+				if server.connected && server.closed == (server == backuper) {
+					t.Errorf("%s closed: %v", host, server.closed)
+				}
+			}
+		})
+	}
+}
+
+func TestMaxReplicationLag(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"30s", 30 * time.Second, false},
+		{"5m", 5 * time.Minute, false},
+		{"1d", 24 * time.Hour, false},
+		{"soon", 0, true},
+		{"1x", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := maxReplicationLag(config.DatabaseConfig{MaxReplicationLag: tt.in})
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("maxReplicationLag(%q) = %s, %v, want %s", tt.in, got, err, tt.want)
+		}
+	}
+}
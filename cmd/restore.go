@@ -2,28 +2,216 @@ package cmd
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"time"
 
 	"github.com/urfave/cli/v2"
 	"github.com/yeboahd24/dbBackupUitility/pkg/backup"
 	"github.com/yeboahd24/dbBackupUitility/pkg/config"
+	"github.com/yeboahd24/dbBackupUitility/pkg/progress"
+	"github.com/yeboahd24/dbBackupUitility/pkg/repository"
+	"github.com/yeboahd24/dbBackupUitility/pkg/retry"
 	"github.com/yeboahd24/dbBackupUitility/pkg/storage"
+	"github.com/yeboahd24/dbBackupUitility/pkg/throttle"
 )
 
-func initializeStorage(cfg config.StorageConfig) (backup.StorageProvider, error) {
+// initializeStorage initializes a storage with its configured layers. The
+// upload limiter is shared by every storage of the run and may be nil.
+func initializeStorage(cfg config.StorageConfig, policy retry.Policy, limiter *throttle.Limiter, count func(n int64)) (backup.StorageProvider, error) {
+	provider, err := initializeProvider(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if count != nil {
+		provider = storage.NewCountingStorage(provider, count)
+	}
+
+	limiters := []*throttle.Limiter{limiter}
+	if cfg.UploadRate != "" {
+		rate, err := config.ParseRate(cfg.UploadRate)
+		if err != nil {
+			return nil, fmt.Errorf("invalid upload_rate: %w", err)
+		}
+		limiters = append(limiters, throttle.NewLimiter(rate))
+	}
+	if limiter != nil || cfg.UploadRate != "" {
+		provider = storage.NewThrottleStorage(provider, limiters...)
+	}
+	provider = storage.NewRetryStorage(provider, policy)
+
+	if cfg.VolumeSize != "" {
+		size, err := config.ParseSize(cfg.VolumeSize)
+		if err != nil {
+			return nil, fmt.Errorf("invalid volume_size: %w", err)
+		}
+		provider = storage.NewVolumeStorage(provider, cfg.StorageName(), size)
+	}
+
+	switch cfg.Mode {
+	case "":
+		return provider, nil
+	case "repository":
+		return repository.New(provider), nil
+	default:
+		return nil, fmt.Errorf("unsupported storage mode: %s", cfg.Mode)
+	}
+}
+
+func initializeProvider(cfg config.StorageConfig) (backup.StorageProvider, error) {
 	switch cfg.Type {
 	case "local":
-		return storage.NewLocalStorage(cfg.Path)
+		return storage.NewLocalStorage(cfg.Path, storage.LocalOptions{Overwrite: cfg.Overwrite})
 	case "s3":
 		ctx := context.Background()
-		return storage.NewS3Storage(ctx, cfg.Bucket, cfg.Region)
+		opts, err := s3Options(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return storage.NewS3Storage(ctx, cfg.Bucket, cfg.Region, opts)
 	default:
 		return nil, fmt.Errorf("unsupported storage type: %s", cfg.Type)
 	}
 }
 
+// s3Options returns the object settings of an S3 storage
+func s3Options(cfg config.StorageConfig) (storage.S3Options, error) {
+	opts := storage.S3Options{
+		StorageClass:         cfg.StorageClass,
+		ServerSideEncryption: cfg.ServerSideEncryption,
+		KMSKeyID:             cfg.KMSKeyID,
+		Tags:                 cfg.Tags,
+		LockMode:             cfg.ObjectLock.Mode,
+		RetainFor:            time.Duration(cfg.ObjectLock.RetainDays) * 24 * time.Hour,
+	}
+	if cfg.ObjectLock.RetainUntil != "" {
+		until, err := time.Parse(time.DateOnly, cfg.ObjectLock.RetainUntil)
+		if err != nil {
+			return opts, fmt.Errorf("invalid object lock retain_until date: %w", err)
+		}
+		opts.RetainUntil = until
+	}
+	return opts, nil
+}
+
+// initializeTargets initializes a named storage target for every config. The
+// bytes stored on each are added to its uploaded counter, if any.
+func initializeTargets(cfgs []config.StorageConfig, policy retry.Policy, limiter *throttle.Limiter, uploaded []*progress.Counter) ([]storage.Target, error) {
+	targets := make([]storage.Target, 0, len(cfgs))
+	for i, cfg := range cfgs {
+		var count func(int64)
+		if uploaded != nil {
+			count = uploaded[i].Add
+		}
+		provider, err := initializeStorage(cfg, policy, limiter, count)
+		if err != nil {
+			return nil, fmt.Errorf("storage %s: %w", cfg.StorageName(), err)
+		}
+		targets = append(targets, storage.Target{Name: cfg.StorageName(), Provider: provider})
+	}
+	return targets, nil
+}
+
+// retrieveBackup opens the named backup from the first storage that has it and
+// returns its size, or 0 if unknown. The bytes read from the storage are
+// added to downloaded.
+func retrieveBackup(ctx context.Context, cfgs []config.StorageConfig, policy retry.Policy, name string, downloaded *progress.Counter) (io.ReadCloser, int64, error) {
+	var errs []error
+	for _, cfg := range cfgs {
+		store, err := initializeStorage(cfg, policy, nil, downloaded.Add)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("storage %s: %w", cfg.StorageName(), err))
+			continue
+		}
+		reader, err := store.Retrieve(ctx, name)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("storage %s: %w", cfg.StorageName(), err))
+			continue
+		}
+		return reader, objectSize(ctx, store, name), nil
+	}
+	return nil, 0, errors.Join(errs...)
+}
+
+// objectSize returns the size of a stored object, or 0 if it cannot be listed
+func objectSize(ctx context.Context, store backup.StorageProvider, name string) int64 {
+	objects, err := store.List(ctx, name)
+	if err != nil {
+		return 0
+	}
+	for _, obj := range objects {
+		if obj.Key == name {
+			return obj.Size
+		}
+	}
+	return 0
+}
+
+func newBackuper(cfg config.DatabaseConfig) (backup.DatabaseBackuper, error) {
+	newFunc, ok := databaseBackupers[cfg.Type]
+	if !ok {
+		return nil, fmt.Errorf("unsupported database type: %s", cfg.Type)
+	}
+	return newFunc(cfg), nil
+}
+
+// connect connects to the database, retrying transient failures such as a
+// failover in progress
+func connect(ctx context.Context, backuper backup.DatabaseBackuper, policy retry.Policy) error {
+	return retry.Do(ctx, policy, func() error {
+		return backuper.Connect(ctx)
+	})
+}
+
+// uploadLimiter returns the limiter shared by all uploads of the run, from
+// the --upload-rate flag or the config, or nil when uploads are not limited
+func uploadLimiter(c *cli.Context, cfg *config.Config) (*throttle.Limiter, error) {
+	value := cfg.UploadRate
+	if c.IsSet("upload-rate") {
+		value = c.String("upload-rate")
+	}
+	if value == "" {
+		return nil, nil
+	}
+	rate, err := config.ParseRate(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid upload rate: %w", err)
+	}
+	return throttle.NewLimiter(rate), nil
+}
+
+// retryPolicy builds the retry policy from the config, falling back to the
+// defaults for unset fields
+func retryPolicy(cfg config.RetryConfig) (retry.Policy, error) {
+	policy := retry.DefaultPolicy
+	if cfg.MaxAttempts > 0 {
+		policy.MaxAttempts = cfg.MaxAttempts
+	}
+	if cfg.BaseDelay != "" {
+		d, err := config.ParseDuration(cfg.BaseDelay)
+		if err != nil {
+			return policy, fmt.Errorf("invalid retry base_delay: %w", err)
+		}
+		policy.BaseDelay = d
+	}
+	if cfg.MaxDelay != "" {
+		d, err := config.ParseDuration(cfg.MaxDelay)
+		if err != nil {
+			return policy, fmt.Errorf("invalid retry max_delay: %w", err)
+		}
+		policy.MaxDelay = d
+	}
+	if cfg.Jitter > 0 {
+		policy.Jitter = cfg.Jitter
+	}
+	policy.OnRetry = func(attempt int, err error, delay time.Duration) {
+		progress.Printf("Attempt %d failed, retrying in %s: %v\n", attempt, delay.Round(time.Millisecond), err)
+	}
+	return policy, nil
+}
+
 func RestoreCommand() *cli.Command {
 	return &cli.Command{
 		Name:  "restore",
@@ -41,36 +229,61 @@ func RestoreCommand() *cli.Command {
 				Usage:    "Backup file to restore",
 				Required: true,
 			},
+			&cli.StringFlag{
+				Name:  "storage",
+				Usage: "Name of the storage to restore from (defaults to the first configured storage or tier holding the backup)",
+			},
+			&cli.BoolFlag{
+				Name:  "no-progress",
+				Usage: "Do not report progress",
+			},
+			&cli.DurationFlag{
+				Name:  "progress-interval",
+				Usage: "Time between progress lines when not running in a terminal",
+				Value: progress.DefaultInterval,
+			},
+			&cli.BoolFlag{
+				Name:  "skip-preflight",
+				Usage: "Do not run the pre-flight checks",
+			},
 		},
 		Action: func(c *cli.Context) error {
 			ctx := context.Background()
-			cfg, err := config.LoadConfig(c.String("config"))
+			cfg, err := loadConfig(c)
 			if err != nil {
 				return fmt.Errorf("failed to load config: %w", err)
 			}
 
+			policy, err := retryPolicy(cfg.Retry)
+			if err != nil {
+				return err
+			}
+
 			// Initialize database backuper based on type
-			var backuper backup.DatabaseBackuper
-			switch cfg.Database.Type {
-			case "postgres":
-				backuper = backup.NewPostgresBackup(cfg.Database)
-			case "mysql":
-				backuper = backup.NewMySQLBackup(cfg.Database)
-			default:
-				return fmt.Errorf("unsupported database type: %s", cfg.Database.Type)
+			backuper, err := newBackuper(cfg.Database)
+			if err != nil {
+				return err
 			}
 
 			var reader io.ReadCloser
+			// Differs from the restored bytes for repositories, whose chunks
+			// are compressed
+			downloaded := progress.NewCounter("downloaded")
+			var size int64
 			backupFile := c.String("file")
 
-			if cfg.Storage.Enabled {
-				// Get from remote storage
-				storage, err := initializeStorage(cfg.Storage)
-				if err != nil {
-					return fmt.Errorf("failed to initialize storage: %w", err)
+			if storageCfgs := cfg.AllStorages(); len(storageCfgs) > 0 {
+				if name := c.String("storage"); name != "" {
+					storageCfg, err := cfg.FindStorage(name)
+					if err != nil {
+						return err
+					}
+					storageCfgs = []config.StorageConfig{storageCfg}
 				}
 
-				reader, err = storage.Retrieve(ctx, backupFile)
+				// Get from the first storage holding the backup, which may
+				// be a colder tier it has been moved to
+				reader, size, err = retrieveBackup(ctx, storageCfgs, policy, backupFile, downloaded)
 				if err != nil {
 					return fmt.Errorf("failed to retrieve backup file: %w", err)
 				}
@@ -81,16 +294,31 @@ func RestoreCommand() *cli.Command {
 					return fmt.Errorf("failed to open backup file: %w", err)
 				}
 				reader = file
+				if info, err := file.Stat(); err == nil {
+					size = info.Size()
+				}
 			}
 			defer reader.Close()
 
 			// Perform restore
-			if err := backuper.Connect(ctx); err != nil {
+			if err := connect(ctx, backuper, policy); err != nil {
 				return fmt.Errorf("failed to connect to database: %w", err)
 			}
 			defer backuper.Close()
 
-			if err := backuper.Restore(ctx, reader); err != nil {
+			if !c.Bool("skip-preflight") && !cfg.Preflight.Disabled {
+				if err := restorePreflight(ctx, backuper, size); err != nil {
+					return err
+				}
+			}
+
+			prog := progress.New("Restore", size, progressOptions(c))
+			if len(cfg.AllStorages()) > 0 {
+				prog.Track(downloaded)
+			}
+			prog.Start()
+			defer prog.Finish()
+			if err := backuper.Restore(ctx, prog.Reader(reader)); err != nil {
 				return fmt.Errorf("failed to restore backup: %w", err)
 			}
 
@@ -0,0 +1,348 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/urfave/cli/v2"
+	"github.com/yeboahd24/dbBackupUitility/pkg/backup"
+	"github.com/yeboahd24/dbBackupUitility/pkg/config"
+	"github.com/yeboahd24/dbBackupUitility/pkg/progress"
+	"github.com/yeboahd24/dbBackupUitility/pkg/retry"
+	"github.com/yeboahd24/dbBackupUitility/pkg/spool"
+	"github.com/yeboahd24/dbBackupUitility/pkg/storage"
+	"github.com/yeboahd24/dbBackupUitility/pkg/throttle"
+)
+
+func SpoolCommand() *cli.Command {
+	configFlag := &cli.StringFlag{
+		Name:     "config",
+		Aliases:  []string{"c"},
+		Usage:    "Path to config file (optional, will auto-detect if not provided)",
+		Required: false,
+	}
+
+	return &cli.Command{
+		Name:  "spool",
+		Usage: "Upload or inspect backups waiting in the local spool",
+		Subcommands: []*cli.Command{
+			{
+				Name:  "flush",
+				Usage: "Upload spooled backups to their pending destinations",
+				Flags: []cli.Flag{
+					configFlag,
+					&cli.BoolFlag{
+						Name:  "watch",
+						Usage: "Keep running and flush the spool periodically",
+					},
+					&cli.DurationFlag{
+						Name:  "interval",
+						Usage: "Time between flushes with --watch",
+						Value: 5 * time.Minute,
+					},
+					&cli.StringFlag{
+						Name:  "upload-rate",
+						Usage: "Limit the combined upload rate, e.g. 10MB/s (overrides upload_rate)",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+					defer stop()
+
+					cfg, err := loadConfig(c)
+					if err != nil {
+						return fmt.Errorf("failed to load config: %w", err)
+					}
+					policy, err := retryPolicy(cfg.Retry)
+					if err != nil {
+						return err
+					}
+					limiter, err := uploadLimiter(c, cfg)
+					if err != nil {
+						return err
+					}
+					sp, err := openSpool(cfg.Spool)
+					if err != nil {
+						return err
+					}
+
+					if !c.Bool("watch") {
+						pending, err := flushSpool(ctx, cfg, policy, limiter, sp)
+						checkSpoolUsage(ctx, cfg, policy, sp)
+						if err != nil {
+							return err
+						}
+						if pending > 0 {
+							return fmt.Errorf("%d spooled backups still have pending uploads", pending)
+						}
+						return nil
+					}
+
+					// Alert once each time the spool grows beyond the alert size
+					alerted := false
+					ticker := time.NewTicker(c.Duration("interval"))
+					defer ticker.Stop()
+					for {
+						if _, err := flushSpool(ctx, cfg, policy, limiter, sp); err != nil {
+							fmt.Printf("Spool flush failed: %v\n", err)
+						}
+						over := spoolOverAlertSize(cfg, sp)
+						if over && !alerted {
+							checkSpoolUsage(ctx, cfg, policy, sp)
+						}
+						alerted = over
+
+						select {
+						case <-ctx.Done():
+							return nil
+						case <-ticker.C:
+						}
+					}
+				},
+			},
+			{
+				Name:  "status",
+				Usage: "List spooled backups and their pending destinations",
+				Flags: []cli.Flag{configFlag},
+				Action: func(c *cli.Context) error {
+					cfg, err := loadConfig(c)
+					if err != nil {
+						return fmt.Errorf("failed to load config: %w", err)
+					}
+					sp, err := openSpool(cfg.Spool)
+					if err != nil {
+						return err
+					}
+
+					entries, err := sp.Entries()
+					if err != nil {
+						return err
+					}
+					for _, e := range entries {
+						fmt.Printf("%s  %s  %s  pending: %v  attempts: %d\n",
+							e.ID, e.Name, progress.FormatSize(e.Size()), e.Pending, e.Attempts)
+						if e.LastError != "" {
+							fmt.Printf("    last error: %s\n", e.LastError)
+						}
+					}
+					used, count, err := sp.Usage()
+					if err != nil {
+						return err
+					}
+					fmt.Printf("Spool %s: %d backups, %s\n", cfg.Spool.Path, count, progress.FormatSize(used))
+					return nil
+				},
+			},
+		},
+	}
+}
+
+// openSpool opens the configured spool directory
+func openSpool(cfg config.SpoolConfig) (*spool.Spool, error) {
+	if cfg.Path == "" {
+		return nil, fmt.Errorf("no spool path configured")
+	}
+	var maxSize int64
+	if cfg.MaxSize != "" {
+		var err error
+		if maxSize, err = config.ParseSize(cfg.MaxSize); err != nil {
+			return nil, fmt.Errorf("invalid spool max_size: %w", err)
+		}
+	}
+	return spool.Open(cfg.Path, maxSize)
+}
+
+// spoolBackup writes the backup to the spool and then uploads it. Destinations
+// that cannot be reached stay queued for 'spool flush', so the backup only
+// fails if it cannot be spooled.
+func spoolBackup(ctx context.Context, cfg *config.Config, policy retry.Policy, limiter *throttle.Limiter, data io.Reader,
+	manifest *backup.Manifest, overrides config.StorageOverrides) error {
+	sp, err := openSpool(cfg.Spool)
+	if err != nil {
+		return err
+	}
+
+	digest := backup.NewDigest(data)
+	e, err := sp.Add(manifest.Name, digest)
+	if err != nil {
+		checkSpoolUsage(ctx, cfg, policy, sp)
+		return fmt.Errorf("failed to spool backup: %w", err)
+	}
+	defer sp.Unlock(e)
+
+	manifest.Size = digest.Size()
+	manifest.SHA256 = digest.Sum()
+	e.Manifest = manifest
+	e.Overrides = overrides
+	for _, storageCfg := range cfg.StorageTargets() {
+		name := storageCfg.StorageName()
+		e.Pending = append(e.Pending, name)
+		manifest.Destinations = append(manifest.Destinations,
+			backup.DestinationResult{Name: name, Error: "upload pending"})
+	}
+	if err := sp.Save(e); err != nil {
+		sp.Remove(e)
+		return err
+	}
+	progress.Printf("Backup spooled: %s\n", e.ID)
+
+	if err := uploadEntry(ctx, cfg, policy, limiter, sp, e); err != nil {
+		progress.Printf("%d uploads queued in the spool, run 'dbbackup spool flush' to retry them\n", len(e.Pending))
+	}
+	checkSpoolUsage(ctx, cfg, policy, sp)
+	return nil
+}
+
+// flushSpool uploads every spooled backup not locked by another process and
+// returns how many still have pending uploads
+func flushSpool(ctx context.Context, cfg *config.Config, policy retry.Policy, limiter *throttle.Limiter, sp *spool.Spool) (int, error) {
+	if _, err := sp.Clean(); err != nil {
+		return 0, err
+	}
+	entries, err := sp.Entries()
+	if err != nil {
+		return 0, err
+	}
+
+	pending := 0
+	for _, e := range entries {
+		if ctx.Err() != nil {
+			return pending, ctx.Err()
+		}
+		if err := sp.Lock(e); err != nil {
+			if errors.Is(err, spool.ErrLocked) {
+				fmt.Printf("Skipping %s: upload in progress\n", e.Name)
+				pending++
+				continue
+			}
+			return pending, err
+		}
+		err := uploadEntry(ctx, cfg, policy, limiter, sp, e)
+		sp.Unlock(e)
+		if err != nil {
+			pending++
+		}
+	}
+	return pending, nil
+}
+
+// uploadEntry uploads a spooled backup to its pending destinations, then
+// removes it from the spool once none are left
+func uploadEntry(ctx context.Context, cfg *config.Config, policy retry.Policy, limiter *throttle.Limiter, sp *spool.Spool, e *spool.Entry) error {
+	storageCfgs := make(map[string]config.StorageConfig)
+	for _, storageCfg := range cfg.StorageTargets() {
+		storageCfgs[storageCfg.StorageName()] = storageCfg
+	}
+
+	var remaining []string
+	var errs []error
+	for _, name := range e.Pending {
+		storageCfg, ok := storageCfgs[name]
+		if !ok {
+			err := fmt.Errorf("storage %s is no longer configured", name)
+			progress.Printf("Failed to upload %s: %v\n", e.Name, err)
+			remaining = append(remaining, name)
+			errs = append(errs, err)
+			continue
+		}
+		e.Overrides.Apply(&storageCfg)
+
+		if err := uploadSpooled(ctx, storageCfg, policy, limiter, e); err != nil {
+			progress.Printf("Failed to store backup on %s: %v\n", name, err)
+			remaining = append(remaining, name)
+			errs = append(errs, fmt.Errorf("storage %s: %w", name, err))
+			continue
+		}
+		progress.Printf("Backup saved to %s: %s\n", name, e.Name)
+	}
+
+	e.Pending = remaining
+	e.Attempts++
+	err := errors.Join(errs...)
+	if err == nil {
+		return sp.Remove(e)
+	}
+	e.LastError = err.Error()
+	if saveErr := sp.Save(e); saveErr != nil {
+		return errors.Join(err, saveErr)
+	}
+	return err
+}
+
+// uploadSpooled stores a spooled backup and its manifest on one destination
+func uploadSpooled(ctx context.Context, storageCfg config.StorageConfig, policy retry.Policy, limiter *throttle.Limiter, e *spool.Entry) error {
+	store, err := initializeStorage(storageCfg, policy, limiter, nil)
+	if err != nil {
+		return err
+	}
+
+	// A spooled file can be rewound, so failed uploads are retried
+	file, err := os.Open(e.DataPath())
+	if err != nil {
+		return fmt.Errorf("failed to open spooled backup: %w", err)
+	}
+	defer file.Close()
+
+	dest := backup.DestinationResult{Name: storageCfg.StorageName(), OK: true}
+	err = store.Store(ctx, e.Name, file)
+	if errors.Is(err, storage.ErrExists) {
+		// Stored by an earlier attempt that failed afterwards
+		sum, sumErr := backup.Checksum(ctx, store, e.Name)
+		if sumErr != nil || sum != e.Manifest.SHA256 {
+			return err
+		}
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+	if volumes, ok := store.(*storage.VolumeStorage); ok {
+		dest.Parts = volumes.Parts(e.Name)
+	}
+	printVolumeWarnings(store, e.Name)
+
+	for i := range e.Manifest.Destinations {
+		if e.Manifest.Destinations[i].Name == dest.Name {
+			e.Manifest.Destinations[i] = dest
+		}
+	}
+	return backup.WriteManifest(ctx, store, e.Manifest)
+}
+
+// spoolOverAlertSize reports whether the spool has grown beyond its alert size
+func spoolOverAlertSize(cfg *config.Config, sp *spool.Spool) bool {
+	if cfg.Spool.AlertSize == "" {
+		return false
+	}
+	limit, err := config.ParseSize(cfg.Spool.AlertSize)
+	if err != nil {
+		return false
+	}
+	used, _, err := sp.Usage()
+	return err == nil && used >= limit
+}
+
+// checkSpoolUsage warns, and notifies when enabled, if the spool has grown
+// beyond its alert size
+func checkSpoolUsage(ctx context.Context, cfg *config.Config, policy retry.Policy, sp *spool.Spool) {
+	if !spoolOverAlertSize(cfg, sp) {
+		return
+	}
+	used, count, err := sp.Usage()
+	if err != nil {
+		return
+	}
+
+	message := fmt.Sprintf("Backup spool %s holds %s in %d backups, above the alert size of %s",
+		cfg.Spool.Path, progress.FormatSize(used), count, cfg.Spool.AlertSize)
+	progress.Printf("Warning: %s\n", message)
+	if cfg.Notification.Enabled {
+		notify(ctx, cfg, policy, message)
+	}
+}
@@ -0,0 +1,156 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/yeboahd24/dbBackupUitility/pkg/backup"
+	"github.com/yeboahd24/dbBackupUitility/pkg/config"
+	"github.com/yeboahd24/dbBackupUitility/pkg/retry"
+	"github.com/yeboahd24/dbBackupUitility/pkg/storage"
+	"github.com/yeboahd24/dbBackupUitility/pkg/throttle"
+)
+
+// storageTier is a storage holding backups of at least a given age
+type storageTier struct {
+	after  time.Duration
+	target storage.Target
+}
+
+// loadTiers returns the main storage followed by the tiering storages, ordered
+// from the hottest to the coldest
+func loadTiers(cfg *config.Config, policy retry.Policy, limiter *throttle.Limiter) ([]storageTier, error) {
+	hot, err := initializeTargets(cfg.StorageTargets()[:1], policy, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	tiers := []storageTier{{target: hot[0]}}
+
+	for _, tierCfg := range cfg.Tiering {
+		after, err := config.ParseDuration(tierCfg.After)
+		if err != nil {
+			return nil, fmt.Errorf("tier %s: %w", tierCfg.Storage.StorageName(), err)
+		}
+		targets, err := initializeTargets([]config.StorageConfig{tierCfg.Storage}, policy, limiter, nil)
+		if err != nil {
+			return nil, err
+		}
+		tiers = append(tiers, storageTier{after: after, target: targets[0]})
+	}
+
+	sort.SliceStable(tiers[1:], func(i, j int) bool {
+		return tiers[i+1].after < tiers[j+1].after
+	})
+	return tiers, nil
+}
+
+// applyTiering moves every backup on the main storage, or on a tier, to the
+// coldest tier its age qualifies for. A backup is only removed from its
+// current storage once the copy has been verified, so it always has at least
+// one copy. It returns the number of backups moved and of failed operations.
+func applyTiering(ctx context.Context, cfg *config.Config, policy retry.Policy, limiter *throttle.Limiter, dryRun bool) (int, int, error) {
+	tiers, err := loadTiers(cfg, policy, limiter)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	present := make([]map[string]backup.ObjectInfo, len(tiers))
+	listTier := func(level int) (map[string]backup.ObjectInfo, error) {
+		if present[level] == nil {
+			objects, err := tiers[level].target.Provider.List(ctx, "")
+			if err != nil {
+				return nil, err
+			}
+			present[level] = make(map[string]backup.ObjectInfo, len(objects))
+			for _, obj := range objects {
+				present[level][obj.Key] = obj
+			}
+		}
+		return present[level], nil
+	}
+
+	var moved, failed int
+	now := time.Now()
+	for level := 0; level < len(tiers)-1; level++ {
+		src := tiers[level].target
+		stored, err := listTier(level)
+		if err != nil {
+			fmt.Printf("Failed to list backups on %s: %v\n", src.Name, err)
+			failed++
+			continue
+		}
+
+		names := make([]string, 0, len(stored))
+		for name := range stored {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			if backup.IsManifest(name) {
+				continue
+			}
+			created, ok := createdAt(ctx, src.Provider, stored[name])
+			if !ok {
+				continue
+			}
+
+			want := level
+			for i := len(tiers) - 1; i > level; i-- {
+				if now.Sub(created) >= tiers[i].after {
+					want = i
+					break
+				}
+			}
+			if want == level {
+				continue
+			}
+			dst := tiers[want].target
+
+			if dryRun {
+				fmt.Printf("Would move %s from %s to %s\n", name, src.Name, dst.Name)
+				continue
+			}
+
+			existing, err := listTier(want)
+			if err != nil {
+				fmt.Printf("Failed to list backups on %s: %v\n", dst.Name, err)
+				failed++
+				continue
+			}
+
+			same := false
+			if _, ok := existing[name]; ok {
+				same, _ = storage.InSync(ctx, src.Provider, dst.Provider, name)
+			}
+			if !same {
+				if err := storage.Copy(ctx, src.Provider, dst.Provider, name); err != nil {
+					fmt.Printf("Failed to move %s to %s: %v\n", name, dst.Name, err)
+					failed++
+					continue
+				}
+				printVolumeWarnings(dst.Provider, name)
+			}
+			existing[name] = stored[name]
+			manifest, hasManifest := stored[backup.ManifestName(name)]
+			if hasManifest {
+				existing[manifest.Key] = manifest
+			}
+
+			n, retained := deleteBackup(ctx, src.Provider, name, hasManifest)
+			if retained != nil {
+				fmt.Printf("Copied %s to %s, source retained: %v\n", name, dst.Name, retained)
+				continue
+			}
+			if n > 0 {
+				failed += n
+				continue
+			}
+			fmt.Printf("Moved %s from %s to %s\n", name, src.Name, dst.Name)
+			moved++
+		}
+	}
+	return moved, failed, nil
+}
@@ -0,0 +1,119 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/urfave/cli/v2"
+	"github.com/yeboahd24/dbBackupUitility/pkg/backup"
+	"github.com/yeboahd24/dbBackupUitility/pkg/config"
+)
+
+func VerifyCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "verify",
+		Usage: "Verify stored backups against their manifest checksums",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:     "config",
+				Aliases:  []string{"c"},
+				Usage:    "Path to config file (optional, will auto-detect if not provided)",
+				Required: false,
+			},
+			&cli.StringFlag{
+				Name:     "file",
+				Aliases:  []string{"f"},
+				Usage:    "Backup file to verify",
+				Required: true,
+			},
+			&cli.StringFlag{
+				Name:  "storage",
+				Usage: "Name of the storage to verify (defaults to every storage holding the backup)",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			ctx := context.Background()
+			cfg, err := loadConfig(c)
+			if err != nil {
+				return fmt.Errorf("failed to load config: %w", err)
+			}
+			policy, err := retryPolicy(cfg.Retry)
+			if err != nil {
+				return err
+			}
+
+			storageCfgs := cfg.AllStorages()
+			if name := c.String("storage"); name != "" {
+				storageCfg, err := cfg.FindStorage(name)
+				if err != nil {
+					return err
+				}
+				storageCfgs = []config.StorageConfig{storageCfg}
+			}
+			if len(storageCfgs) == 0 {
+				return fmt.Errorf("verify requires storage to be enabled")
+			}
+
+			name := c.String("file")
+			var verified, failed int
+			for _, storageCfg := range storageCfgs {
+				store, err := initializeStorage(storageCfg, policy, nil, nil)
+				if err != nil {
+					return fmt.Errorf("failed to initialize storage %s: %w", storageCfg.StorageName(), err)
+				}
+
+				manifest, err := backup.ReadManifest(ctx, store, name)
+				if err != nil {
+					if c.String("storage") != "" {
+						return err
+					}
+					continue
+				}
+
+				if err := verifyBackup(ctx, store, storageCfg.StorageName(), manifest); err != nil {
+					fmt.Printf("FAILED %s on %s: %v\n", name, storageCfg.StorageName(), err)
+					failed++
+					continue
+				}
+				fmt.Printf("OK     %s on %s\n", name, storageCfg.StorageName())
+				verified++
+			}
+
+			if verified+failed == 0 {
+				return fmt.Errorf("no manifest found for %s on any storage", name)
+			}
+			if failed > 0 {
+				return fmt.Errorf("%d copy(ies) of %s failed verification", failed, name)
+			}
+			return nil
+		},
+	}
+}
+
+// verifyBackup checks every volume recorded for the storage, then the
+// checksum of the complete backup as restore would read it
+func verifyBackup(ctx context.Context, store backup.StorageProvider, storageName string, m *backup.Manifest) error {
+	for _, dest := range m.Destinations {
+		if dest.Name != storageName {
+			continue
+		}
+		for _, part := range dest.Parts {
+			sum, err := backup.Checksum(ctx, store, part.Name)
+			if err != nil {
+				return err
+			}
+			if sum != part.SHA256 {
+				return fmt.Errorf("volume %s checksum mismatch", part.Name)
+			}
+		}
+	}
+
+	sum, err := backup.Checksum(ctx, store, m.Name)
+	if err != nil {
+		return err
+	}
+	if sum != m.SHA256 {
+		return fmt.Errorf("checksum mismatch")
+	}
+	return nil
+}
@@ -15,6 +15,11 @@ func main() {
 		Commands: []*cli.Command{
 			cmd.BackupCommand(),
 			cmd.RestoreCommand(),
+			cmd.PruneCommand(),
+			cmd.CopyCommand(),
+			cmd.VerifyCommand(),
+			cmd.SpoolCommand(),
+			cmd.DoctorCommand(),
 			cmd.ConfigCommand(),
 			cmd.HelpCommand(),
 		},
@@ -25,6 +30,14 @@ func main() {
 				Aliases: []string{"v"},
 				Usage:   "Enable verbose output",
 			},
+			&cli.StringSliceFlag{
+				Name:  "set",
+				Usage: "Override a setting, e.g. --set storages.0.bucket=backups (repeatable)",
+			},
+			&cli.BoolFlag{
+				Name:  "no-default-config",
+				Usage: "Load only the file given with --config, not the system, user and project files",
+			},
 		},
 		// Add default help text
 		Description: `Database Backup Utility provides a robust solution for managing database backups.
@@ -32,6 +45,11 @@ func main() {
 COMMANDS:
    backup   Perform database backup
    restore  Restore database from backup
+   prune    Delete old backups from storage
+   copy     Copy or move backups between storages
+   verify   Verify stored backups against their manifests
+   spool    Upload or inspect backups waiting in the local spool
+   doctor   Check the configuration, database, tools, storages and notifications
    config   Manage configuration settings
    help     Shows detailed help information for commands
 
@@ -0,0 +1,73 @@
+package backup
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+)
+
+// Passwords are handed to the client tools in temporary files readable only
+// by the current user, never on the command line where any user can read
+// them with ps.
+
+// writeCredentialsFile writes content to a new temporary file readable only
+// by the current user and returns its path
+func writeCredentialsFile(pattern, content string) (string, error) {
+	f, err := os.CreateTemp("", pattern)
+	if err != nil {
+		return "", fmt.Errorf("failed to create credentials file: %w", err)
+	}
+	err = f.Chmod(0600)
+	if err == nil {
+		_, err = f.WriteString(content)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("failed to write credentials file: %w", err)
+	}
+	return f.Name(), nil
+}
+
+// pgpassEscaper escapes the separators of a .pgpass field
+var pgpassEscaper = strings.NewReplacer(`\`, `\\`, `:`, `\:`)
+
+// postgresCredentials points cmd at a generated password file through
+// PGPASSFILE. Without a configured password the tool falls back to the
+// user's own PGPASSWORD or .pgpass. The returned function removes the file.
+func postgresCredentials(cmd *exec.Cmd, password string) (func(), error) {
+	if password == "" {
+		return func() {}, nil
+	}
+	path, err := writeCredentialsFile("dbbackup-*.pgpass", "*:*:*:*:"+pgpassEscaper.Replace(password)+"\n")
+	if err != nil {
+		return nil, err
+	}
+
+	// PGPASSWORD takes precedence over the password file
+	env := cmd.Environ()
+	env = slices.DeleteFunc(env, func(v string) bool { return strings.HasPrefix(v, "PGPASSWORD=") })
+	cmd.Env = append(env, "PGPASSFILE="+path)
+	return func() { os.Remove(path) }, nil
+}
+
+// optionEscaper escapes a quoted MySQL option file value
+var optionEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// mysqlCredentials writes an option file with the password and returns the
+// --defaults-extra-file argument, which must come first, or no arguments
+// without a configured password. The returned function removes the file.
+func mysqlCredentials(password string) ([]string, func(), error) {
+	if password == "" {
+		return nil, func() {}, nil
+	}
+	path, err := writeCredentialsFile("dbbackup-*.cnf", "[client]\npassword=\""+optionEscaper.Replace(password)+"\"\n")
+	if err != nil {
+		return nil, nil, err
+	}
+	return []string{"--defaults-extra-file=" + path}, func() { os.Remove(path) }, nil
+}
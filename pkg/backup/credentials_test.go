@@ -0,0 +1,138 @@
+package backup
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// credentialsDir makes temporary files go to a directory of the test and
+// returns it
+func credentialsDir(t *testing.T) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("file modes and shell commands are Unix only")
+	}
+	dir := t.TempDir()
+	t.Setenv("TMPDIR", dir)
+	return dir
+}
+
+// checkCredentialsFile checks that the file at path holds content and is
+// readable only by the current user
+func checkCredentialsFile(t *testing.T, path, content string) {
+	t.Helper()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode != 0600 {
+		t.Errorf("%s has mode %o, want 600", path, mode)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != content {
+		t.Errorf("%s holds %q, want %q", path, data, content)
+	}
+}
+
+func checkRemoved(t *testing.T, dir string) {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("credentials files left behind: %v", entries)
+	}
+}
+
+func TestPostgresCredentials(t *testing.T) {
+	dir := credentialsDir(t)
+	t.Setenv("PGPASSWORD", "from-the-environment")
+
+	cmd := exec.Command("sh", "-c", `cat "$PGPASSFILE"; echo "PGPASSWORD=$PGPASSWORD"`)
+	cleanup, err := postgresCredentials(cmd, `pa:ss\word`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var passfile string
+	for _, v := range cmd.Env {
+		if strings.HasPrefix(v, "PGPASSWORD=") {
+			t.Errorf("PGPASSWORD is passed to the tool")
+		}
+		if path, ok := strings.CutPrefix(v, "PGPASSFILE="); ok {
+			passfile = path
+		}
+	}
+	if passfile == "" {
+		t.Fatal("PGPASSFILE is not set")
+	}
+	// The separators and the escape character are escaped
+	checkCredentialsFile(t, passfile, `*:*:*:*:pa\:ss\\word`+"\n")
+
+	// The file is removed once the tool exits
+	r, err := startCommand(cmd, cleanup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	output, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `*:*:*:*:pa\:ss\\word` + "\nPGPASSWORD=\n"; string(output) != want {
+		t.Errorf("tool saw %q, want %q", output, want)
+	}
+	checkRemoved(t, dir)
+}
+
+func TestPostgresCredentialsWithoutPassword(t *testing.T) {
+	dir := credentialsDir(t)
+	cmd := exec.Command("true")
+	cleanup, err := postgresCredentials(cmd, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cleanup()
+	// The tool falls back to the user's own PGPASSWORD or .pgpass
+	if cmd.Env != nil {
+		t.Errorf("environment changed to %v", cmd.Env)
+	}
+	checkRemoved(t, dir)
+}
+
+func TestMySQLCredentials(t *testing.T) {
+	dir := credentialsDir(t)
+	args, cleanup, err := mysqlCredentials("p\"a\\ss\nword")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(args) != 1 || !strings.HasPrefix(args[0], "--defaults-extra-file=") {
+		t.Fatalf("mysqlCredentials returned arguments %v", args)
+	}
+	path := strings.TrimPrefix(args[0], "--defaults-extra-file=")
+	checkCredentialsFile(t, path, "[client]\npassword=\"p\\\"a\\\\ss\\nword\"\n")
+
+	// The file is removed even when the tool is stopped early
+	r, err := startCommand(exec.Command("yes"), cleanup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Read(make([]byte, 10)); err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+	checkRemoved(t, dir)
+
+	args, cleanup, err = mysqlCredentials("")
+	if err != nil || args != nil {
+		t.Errorf("mysqlCredentials without a password returned %v, %v", args, err)
+	}
+	cleanup()
+}
@@ -3,6 +3,8 @@ package backup
 import (
 	"context"
 	"io"
+	"io/fs"
+	"time"
 )
 
 // BackupType represents the type of backup to perform
@@ -22,11 +24,86 @@ type DatabaseBackuper interface {
 	Close() error
 }
 
-// StorageProvider defines the interface for backup storage operations
+// SizeEstimator is implemented by backupers that can estimate the size of
+// the data a backup will read from the database
+type SizeEstimator interface {
+	EstimateSize(ctx context.Context) (int64, error)
+}
+
+// ToolChecker is implemented by backupers that run client tools.
+// CheckTools finds the tools and verifies they support the connected server.
+type ToolChecker interface {
+	CheckTools(ctx context.Context) ([]ToolInfo, error)
+}
+
+// PrivilegeChecker is implemented by backupers that can verify the connected
+// user has the privileges a backup needs
+type PrivilegeChecker interface {
+	CheckPrivileges(ctx context.Context) error
+}
+
+// ToolReporter is implemented by backupers that dump through a client tool.
+// DumpTool returns the tool the last backup ran, or nil.
+type ToolReporter interface {
+	DumpTool() *ToolInfo
+}
+
+// ReplicationChecker is implemented by backupers that can tell whether the
+// connected server is a replica and how far behind its primary it is
+type ReplicationChecker interface {
+	ReplicationStatus(ctx context.Context) (ReplicationStatus, error)
+}
+
+// ReplicationStatus is the role of a server and, for a replica, its lag
+type ReplicationStatus struct {
+	Replica bool
+	// Lag behind the primary, or negative when unknown, e.g. while
+	// replication is stopped
+	Lag time.Duration
+}
+
+// ToolInfo describes a client tool found on the system
+type ToolInfo struct {
+	Name    string `json:"name"`
+	Path    string `json:"path"`
+	Version string `json:"version"`
+	MariaDB bool   `json:"mariadb,omitempty"` // a MariaDB rather than MySQL client
+}
+
+// ObjectInfo describes a stored object
+type ObjectInfo struct {
+	Key     string // slash-separated, relative to the storage root
+	Size    int64
+	ModTime time.Time
+}
+
+// ErrNotFound is wrapped by the Retrieve errors of objects that do not exist.
+// It is fs.ErrNotExist, which local storage returns as is.
+var ErrNotFound = fs.ErrNotExist
+
+// StorageProvider defines the interface for backup storage operations.
+// Object names are slash-separated keys relative to the storage root, and List
+// returns every object whose key starts with prefix, sorted by key.
 type StorageProvider interface {
 	Store(ctx context.Context, name string, data io.Reader) error
 	Retrieve(ctx context.Context, name string) (io.ReadCloser, error)
-	List(ctx context.Context) ([]string, error)
+	List(ctx context.Context, prefix string) ([]ObjectInfo, error)
+	Delete(ctx context.Context, name string) error
+}
+
+// Replacer is implemented by storages whose Store refuses to overwrite an
+// existing object. Replace stores the object whether or not one exists.
+type Replacer interface {
+	Replace(ctx context.Context, name string, data io.Reader) error
+}
+
+// Replace stores data under name, replacing any object of that name even on
+// a storage that does not overwrite on Store
+func Replace(ctx context.Context, store StorageProvider, name string, data io.Reader) error {
+	if r, ok := store.(Replacer); ok {
+		return r.Replace(ctx, name, data)
+	}
+	return store.Store(ctx, name, data)
 }
 
 // Compressor defines the interface for backup compression
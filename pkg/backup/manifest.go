@@ -0,0 +1,136 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+	"time"
+)
+
+// ManifestSuffix is appended to a backup name to form the name of its manifest
+const ManifestSuffix = ".manifest.json"
+
+// Manifest describes a stored backup and is written next to it on every
+// destination that received it
+type Manifest struct {
+	Name         string              `json:"name"`
+	Database     string              `json:"database"`
+	DatabaseType string              `json:"database_type"`
+	BackupType   BackupType          `json:"backup_type"`
+	CreatedAt    time.Time           `json:"created_at"`
+	Size         int64               `json:"size"`
+	SHA256       string              `json:"sha256"`
+	Tool         *ToolInfo           `json:"tool,omitempty"` // client tool that made the dump
+	Node         *Node               `json:"node,omitempty"` // server the dump was taken from
+	Destinations []DestinationResult `json:"destinations,omitempty"`
+}
+
+// Node records the server a backup was dumped from
+type Node struct {
+	Role    string `json:"role"`    // primary or replica
+	Address string `json:"address"` // host and port, or socket
+	// Replication lag of a replica when the dump started
+	LagSeconds *float64 `json:"lag_seconds,omitempty"`
+}
+
+// DestinationResult records the outcome of storing a backup on one destination
+type DestinationResult struct {
+	Name  string `json:"name"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+	Parts []Part `json:"parts,omitempty"` // volumes, when the destination splits backups
+}
+
+// Part is one volume of a backup split into fixed-size pieces
+type Part struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// ManifestName returns the name of the manifest for the given backup
+func ManifestName(name string) string {
+	return name + ManifestSuffix
+}
+
+// IsManifest reports whether the stored object name is a manifest
+func IsManifest(name string) bool {
+	return strings.HasSuffix(name, ManifestSuffix)
+}
+
+// WriteManifest stores the manifest next to its backup, replacing an earlier
+// one
+func WriteManifest(ctx context.Context, store StorageProvider, m *Manifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest: %w", err)
+	}
+	if err := Replace(ctx, store, ManifestName(m.Name), bytes.NewReader(data)); err != nil {
+		return fmt.Errorf("failed to store manifest: %w", err)
+	}
+	return nil
+}
+
+// ReadManifest loads the manifest of the named backup
+func ReadManifest(ctx context.Context, store StorageProvider, name string) (*Manifest, error) {
+	r, err := store.Retrieve(ctx, ManifestName(name))
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve manifest: %w", err)
+	}
+	defer r.Close()
+
+	var m Manifest
+	if err := json.NewDecoder(r).Decode(&m); err != nil {
+		return nil, fmt.Errorf("failed to decode manifest: %w", err)
+	}
+	return &m, nil
+}
+
+// Digest computes the size and SHA-256 checksum of the data read through it
+type Digest struct {
+	r io.Reader
+	h hash.Hash
+	n int64
+}
+
+func NewDigest(r io.Reader) *Digest {
+	return &Digest{r: r, h: sha256.New()}
+}
+
+func (d *Digest) Read(p []byte) (int, error) {
+	n, err := d.r.Read(p)
+	d.h.Write(p[:n])
+	d.n += int64(n)
+	return n, err
+}
+
+// Size returns the number of bytes read so far
+func (d *Digest) Size() int64 {
+	return d.n
+}
+
+// Sum returns the hex encoded SHA-256 of the bytes read so far
+func (d *Digest) Sum() string {
+	return hex.EncodeToString(d.h.Sum(nil))
+}
+
+// Checksum reads the named object from storage and returns its SHA-256
+func Checksum(ctx context.Context, store StorageProvider, name string) (string, error) {
+	r, err := store.Retrieve(ctx, name)
+	if err != nil {
+		return "", fmt.Errorf("failed to retrieve %s: %w", name, err)
+	}
+	defer r.Close()
+
+	d := NewDigest(r)
+	if _, err := io.Copy(io.Discard, d); err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", name, err)
+	}
+	return d.Sum(), nil
+}
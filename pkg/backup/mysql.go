@@ -1,43 +1,101 @@
 package backup
 
 import (
-	"bytes"
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"os"
-	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
-	_ "github.com/go-sql-driver/mysql"
+	"github.com/go-sql-driver/mysql"
 	"github.com/yeboahd24/dbBackupUitility/pkg/config"
+	"github.com/yeboahd24/dbBackupUitility/pkg/retry"
 )
 
+// transientMySQLErrors are server errors worth retrying a connection for
+var transientMySQLErrors = map[uint16]bool{
+	1040: true, // ER_CON_COUNT_ERROR: too many connections
+	1053: true, // ER_SERVER_SHUTDOWN: server shutdown in progress
+}
+
+// mysqlToolDirs are the usual install locations of MySQL clients outside PATH
+var mysqlToolDirs = []string{
+	"/usr/local/mysql*/bin",               // MySQL installer packages
+	"/opt/homebrew/opt/mysql-client*/bin", // Homebrew
+	"/usr/local/opt/mysql-client*/bin",
+}
+
 type MySQLBackup struct {
 	config config.DatabaseConfig
 	db     *sql.DB
+	tunnel *sshTunnel
+	tools  *toolSet
+	dumped *ToolInfo
 }
 
 func NewMySQLBackup(config config.DatabaseConfig) *MySQLBackup {
-	return &MySQLBackup{config: config}
+	return &MySQLBackup{config: config, tools: newToolSet(config, mysqlToolDirs...)}
 }
 
 func (m *MySQLBackup) Connect(ctx context.Context) error {
-	dsn := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s",
-		m.config.Username,
-		m.config.Password,
-		m.config.Host,
-		m.config.Port,
-		m.config.Database,
-	)
+	// mysqldump and mysql reach the server at 127.0.0.1 through the tunnel,
+	// and VERIFY_IDENTITY would fail there only after the dump has started
+	if m.config.SSHTunnel != nil && sslMode(m.config) == "verify-full" {
+		return fmt.Errorf("ssl_mode verify-full is not supported with ssh_tunnel: the MySQL client tools connect to the local end of the tunnel and cannot verify the server host name, use verify-ca")
+	}
 
-	db, err := sql.Open("mysql", dsn)
+	// Formatted by the driver, as a password containing @ or / would break
+	// a hand-built DSN
+	dsn := mysql.NewConfig()
+	dsn.User = m.config.Username
+	dsn.Passwd = m.config.Password
+	dsn.Net = "tcp"
+	dsn.Addr = net.JoinHostPort(m.config.Host, strconv.Itoa(m.config.Port))
+	if m.config.Socket != "" {
+		dsn.Net, dsn.Addr = "unix", m.config.Socket
+	}
+	dsn.DBName = m.config.Database
+	tlsConfig, err := mysqlTLSConfig(m.config)
 	if err != nil {
+		return err
+	}
+	dsn.TLS = tlsConfig
+
+	if m.config.SSHTunnel != nil {
+		tunnel, err := openTunnel(ctx, m.config.SSHTunnel, dsn.Net, dsn.Addr)
+		if err != nil {
+			return err
+		}
+		m.tunnel = tunnel
+		// The driver dials the tunnel, and TLS verifies the host
+		dsn.Net, dsn.DialFunc = "tcp", tunnel.DialContext
+		if m.config.Socket != "" {
+			dsn.Addr = "localhost"
+		}
+	}
+
+	connector, err := mysql.NewConnector(dsn)
+	if err != nil {
+		m.closeTunnel()
 		return fmt.Errorf("failed to connect to MySQL: %w", err)
 	}
+	db := sql.OpenDB(connector)
 
 	err = db.PingContext(ctx)
 	if err != nil {
+		db.Close()
+		err = m.tunnel.wrap(err)
+		m.closeTunnel()
+		var mysqlErr *mysql.MySQLError
+		if errors.As(err, &mysqlErr) && transientMySQLErrors[mysqlErr.Number] {
+			err = retry.Transient(err)
+		}
 		return fmt.Errorf("failed to ping MySQL: %w", err)
 	}
 
@@ -45,28 +103,223 @@ func (m *MySQLBackup) Connect(ctx context.Context) error {
 	return nil
 }
 
+// toolArgs returns the connection options of mysqldump and mysql, which
+// connect to the local end of the SSH tunnel, if any
+func (m *MySQLBackup) toolArgs() []string {
+	switch {
+	case m.tunnel != nil:
+		return []string{"--protocol=TCP", "-h", "127.0.0.1", "-P", strconv.Itoa(m.tunnel.port()), "-u", m.config.Username}
+	case m.config.Socket != "":
+		return []string{"--protocol=SOCKET", "--socket=" + m.config.Socket, "-u", m.config.Username}
+	}
+	return []string{"-h", m.config.Host, "-P", strconv.Itoa(m.config.Port), "-u", m.config.Username}
+}
+
+// closeTunnel tears down the SSH tunnel, if any
+func (m *MySQLBackup) closeTunnel() error {
+	if m.tunnel == nil {
+		return nil
+	}
+	err := m.tunnel.Close()
+	m.tunnel = nil
+	return err
+}
+
 func (m *MySQLBackup) Backup(ctx context.Context, backupType BackupType) (io.Reader, error) {
-	cmd := exec.CommandContext(ctx, "mysqldump",
-		"-h", m.config.Host,
-		"-P", fmt.Sprintf("%d", m.config.Port),
-		"-u", m.config.Username,
-		"-p"+m.config.Password,
+	dump, err := m.dumpTool(ctx)
+	if err != nil {
+		return nil, err
+	}
+	args, cleanup, err := mysqlCredentials(m.config.Password)
+	if err != nil {
+		return nil, err
+	}
+	args = append(append(args, mysqlTLSArgs(m.config, dump)...), m.toolArgs()...)
+	cmd, err := toolCommand(ctx, m.config, dump.Path, append(args,
 		m.config.Database,
-	)
+	)...)
+	if err != nil {
+		cleanup()
+		return nil, err
+	}
+	m.dumped = &dump
 
-	output, err := cmd.Output()
+	r, err := startCommand(cmd, cleanup)
 	if err != nil {
-		return nil, fmt.Errorf("backup failed: %w", err)
+		return nil, err
 	}
+	r.wrap = m.tunnel.wrap
+	return r, nil
+}
 
-	return io.NopCloser(bytes.NewReader(output)), nil
+// EstimateSize returns the size of the database's tables and indexes
+func (m *MySQLBackup) EstimateSize(ctx context.Context) (int64, error) {
+	var size int64
+	err := m.db.QueryRowContext(ctx, `SELECT COALESCE(SUM(data_length + index_length), 0)
+		FROM information_schema.tables WHERE table_schema = DATABASE()`).Scan(&size)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query database size: %w", err)
+	}
+	return size, nil
+}
+
+// ReplicationStatus reports whether the server replicates from a primary
+// and, while replication runs, how far behind it is. Of several replication
+// channels, the one furthest behind counts.
+func (m *MySQLBackup) ReplicationStatus(ctx context.Context) (ReplicationStatus, error) {
+	rows, err := m.db.QueryContext(ctx, "SHOW REPLICA STATUS")
+	if err != nil {
+		// Before MySQL 8.0.22 and MariaDB 10.5.1
+		rows, err = m.db.QueryContext(ctx, "SHOW SLAVE STATUS")
+	}
+	if err != nil {
+		return ReplicationStatus{}, fmt.Errorf("failed to query replica status: %w", err)
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return ReplicationStatus{}, fmt.Errorf("failed to query replica status: %w", err)
+	}
+
+	var status ReplicationStatus
+	values := make([]sql.NullString, len(columns))
+	dest := make([]any, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	// The columns were renamed from master and slave in MySQL 8.0.22
+	column := func(names ...string) sql.NullString {
+		for i, name := range columns {
+			if slices.Contains(names, name) {
+				return values[i]
+			}
+		}
+		return sql.NullString{}
+	}
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return ReplicationStatus{}, fmt.Errorf("failed to query replica status: %w", err)
+		}
+		status.Replica = true
+		if status.Lag < 0 {
+			continue
+		}
+		seconds := column("Seconds_Behind_Source", "Seconds_Behind_Master")
+		running := column("Replica_IO_Running", "Slave_IO_Running").String == "Yes" &&
+			column("Replica_SQL_Running", "Slave_SQL_Running").String == "Yes"
+		lag, err := strconv.Atoi(seconds.String)
+		if !running || !seconds.Valid || err != nil {
+			status.Lag = -1
+		} else if d := time.Duration(lag) * time.Second; d > status.Lag {
+			status.Lag = d
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return ReplicationStatus{}, fmt.Errorf("failed to query replica status: %w", err)
+	}
+	return status, nil
+}
+
+// dumpPrivileges are the privileges mysqldump needs on the database
+var dumpPrivileges = []string{"SELECT", "LOCK TABLES", "SHOW VIEW", "TRIGGER"}
+
+// CheckPrivileges verifies that the user's grants on the database, or on all
+// databases, include the privileges mysqldump needs. Privileges granted
+// through roles are not resolved.
+func (m *MySQLBackup) CheckPrivileges(ctx context.Context) error {
+	rows, err := m.db.QueryContext(ctx, "SHOW GRANTS")
+	if err != nil {
+		return fmt.Errorf("failed to query grants: %w", err)
+	}
+	defer rows.Close()
+
+	granted := make(map[string]bool)
+	scopes := []string{"*.*", "`" + m.config.Database + "`.*", m.config.Database + ".*"}
+	for rows.Next() {
+		var grant string
+		if err := rows.Scan(&grant); err != nil {
+			return fmt.Errorf("failed to query grants: %w", err)
+		}
+		privileges, scope, ok := strings.Cut(strings.TrimPrefix(grant, "GRANT "), " ON ")
+		if !ok {
+			continue
+		}
+		scope, _, _ = strings.Cut(scope, " TO ")
+		if !slices.Contains(scopes, strings.ReplaceAll(scope, "\\_", "_")) {
+			continue
+		}
+		for _, privilege := range strings.Split(privileges, ",") {
+			granted[strings.TrimSpace(privilege)] = true
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to query grants: %w", err)
+	}
+	if granted["ALL PRIVILEGES"] || granted["ALL"] {
+		return nil
+	}
+
+	var missing []string
+	for _, privilege := range dumpPrivileges {
+		if !granted[privilege] {
+			missing = append(missing, privilege)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing privileges on %s: %s", m.config.Database, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// CheckTools verifies that mysqldump and mysql are installed and, for MySQL
+// servers, that mysqldump is not of an older major version
+func (m *MySQLBackup) CheckTools(ctx context.Context) ([]ToolInfo, error) {
+	dump, err := m.dumpTool(ctx)
+	if dump.Path == "" {
+		return nil, err
+	}
+	tools := []ToolInfo{dump}
+	client, clientErr := m.tools.find(ctx, "mysql")
+	if clientErr != nil {
+		return tools, clientErr
+	}
+	return append(tools, client), err
+}
+
+// DumpTool returns the mysqldump the last backup ran
+func (m *MySQLBackup) DumpTool() *ToolInfo {
+	return m.dumped
+}
+
+// dumpTool returns the newest installed mysqldump, which for MySQL servers
+// must not be of an older major version
+func (m *MySQLBackup) dumpTool(ctx context.Context) (ToolInfo, error) {
+	var serverVersion string
+	if err := m.db.QueryRowContext(ctx, "SELECT VERSION()").Scan(&serverVersion); err != nil {
+		return ToolInfo{}, fmt.Errorf("failed to query server version: %w", err)
+	}
+
+	dump, err := m.tools.find(ctx, "mysqldump")
+	if err != nil {
+		return dump, err
+	}
+	// MariaDB clients are versioned independently of MySQL servers
+	if strings.Contains(serverVersion, "MariaDB") {
+		return dump, nil
+	}
+	if major := majorVersion(dump.Version); major >= 0 && major < majorVersion(serverVersion) {
+		return dump, fmt.Errorf("mysqldump %s is older than the MySQL %s server, install a newer client or set its path in tool_paths",
+			dump.Version, serverVersion)
+	}
+	return dump, nil
 }
 
 func (m *MySQLBackup) Close() error {
+	var err error
 	if m.db != nil {
-		return m.db.Close()
+		err = m.db.Close()
 	}
-	return nil
+	return errors.Join(err, m.closeTunnel())
 }
 
 func (m *MySQLBackup) Restore(ctx context.Context, backupFile io.Reader) error {
@@ -76,24 +329,37 @@ func (m *MySQLBackup) Restore(ctx context.Context, backupFile io.Reader) error {
 		return fmt.Errorf("failed to create temp file: %w", err)
 	}
 	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
 
 	// Copy backup data to temp file
 	if _, err := io.Copy(tmpFile, backupFile); err != nil {
 		return fmt.Errorf("failed to write backup to temp file: %w", err)
 	}
+	if _, err := tmpFile.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to rewind temp file: %w", err)
+	}
 
 	// Execute mysql command to restore
-	cmd := exec.CommandContext(ctx, "mysql",
-		"-h", m.config.Host,
-		"-P", fmt.Sprintf("%d", m.config.Port),
-		"-u", m.config.Username,
-		"-p"+m.config.Password,
+	client, err := m.tools.find(ctx, "mysql")
+	if err != nil {
+		return err
+	}
+	args, cleanup, err := mysqlCredentials(m.config.Password)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	args = append(append(args, mysqlTLSArgs(m.config, client)...), m.toolArgs()...)
+	cmd, err := toolCommand(ctx, m.config, client.Path, append(args,
 		m.config.Database,
-	)
+	)...)
+	if err != nil {
+		return err
+	}
 
 	cmd.Stdin = tmpFile
 	if output, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("restore failed: %s: %w", string(output), err)
+		return fmt.Errorf("restore failed: %s: %w", string(output), m.tunnel.wrap(err))
 	}
 
 	return nil
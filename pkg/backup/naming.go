@@ -0,0 +1,66 @@
+package backup
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// DefaultNameTemplate produces flat names such as backup_mydb_20250101020000.dump
+const DefaultNameTemplate = "backup_{database}_{timestamp}.{ext}"
+
+// TimestampFormat is the layout of the {timestamp} and {id} placeholders
+const TimestampFormat = "20060102150405"
+
+var placeholder = regexp.MustCompile(`\{([a-z_]+)\}`)
+
+// NameVars returns the placeholder values available to naming templates for a
+// backup taken at the given time. Labels provide additional placeholders such
+// as {env}.
+func NameVars(database, databaseType string, backupType BackupType, at time.Time, labels map[string]string) map[string]string {
+	vars := make(map[string]string, len(labels)+12)
+	for k, v := range labels {
+		vars[k] = v
+	}
+	stamp := at.Format(TimestampFormat)
+	vars["database"] = database
+	vars["db_type"] = databaseType
+	vars["backup_type"] = string(backupType)
+	vars["yyyy"] = at.Format("2006")
+	vars["mm"] = at.Format("01")
+	vars["dd"] = at.Format("02")
+	vars["hh"] = at.Format("15")
+	vars["timestamp"] = stamp
+	vars["id"] = stamp
+	vars["ext"] = "dump"
+	return vars
+}
+
+// ExpandName fills in the {placeholders} of a naming template, producing a
+// slash-separated object key
+func ExpandName(template string, vars map[string]string) (string, error) {
+	if template == "" {
+		template = DefaultNameTemplate
+	}
+
+	var missing []string
+	name := placeholder.ReplaceAllStringFunc(template, func(m string) string {
+		key := m[1 : len(m)-1]
+		v, ok := vars[key]
+		if !ok {
+			missing = append(missing, m)
+		}
+		return v
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("unknown placeholder(s) in naming template: %s", strings.Join(missing, ", "))
+	}
+
+	clean := path.Clean(name)
+	if path.IsAbs(clean) || clean == "." || clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", fmt.Errorf("naming template produced an invalid object name %q", name)
+	}
+	return clean, nil
+}
@@ -0,0 +1,37 @@
+package backup
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExpandName(t *testing.T) {
+	vars := NameVars("orders", "postgres", Full, time.Date(2025, 3, 4, 5, 6, 7, 0, time.UTC), map[string]string{"env": "prod"})
+
+	tests := []struct {
+		template string
+		want     string
+		wantErr  string
+	}{
+		{template: "", want: "backup_orders_20250304050607.dump"},
+		{template: "{env}/{db_type}/{database}/{yyyy}/{mm}/{dd}/{hh}/{id}.{ext}", want: "prod/postgres/orders/2025/03/04/05/20250304050607.dump"},
+		{template: "{backup_type}//{database}/./{timestamp}", want: "full/orders/20250304050607"},
+		{template: "{database}/{region}/{zone}.dump", wantErr: "unknown placeholder(s) in naming template: {region}, {zone}"},
+		{template: "/{database}.dump", wantErr: "invalid object name"},
+		{template: "../{database}.dump", wantErr: "invalid object name"},
+		{template: "{database}/../../x", wantErr: "invalid object name"},
+	}
+	for _, tt := range tests {
+		got, err := ExpandName(tt.template, vars)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ExpandName(%q) = %q, %v, want an error containing %q", tt.template, got, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("ExpandName(%q) = %q, %v, want %q", tt.template, got, err, tt.want)
+		}
+	}
+}
@@ -1,43 +1,84 @@
 package backup
 
 import (
-	"bytes"
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"os"
 	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 	"github.com/yeboahd24/dbBackupUitility/pkg/config"
 )
 
+// postgresToolDirs are the usual install locations of PostgreSQL clients,
+// several versions of which may be installed side by side
+var postgresToolDirs = []string{
+	"/usr/lib/postgresql/*/bin",          // Debian and Ubuntu
+	"/usr/pgsql-*/bin",                   // PGDG packages for RHEL and Fedora
+	"/opt/homebrew/opt/postgresql@*/bin", // Homebrew
+	"/usr/local/opt/postgresql@*/bin",
+}
+
 type PostgresBackup struct {
 	config config.DatabaseConfig
 	db     *sql.DB
+	tunnel *sshTunnel
+	tools  *toolSet
+	dumped *ToolInfo
 }
 
 func NewPostgresBackup(config config.DatabaseConfig) *PostgresBackup {
-	return &PostgresBackup{config: config}
+	return &PostgresBackup{config: config, tools: newToolSet(config, postgresToolDirs...)}
 }
 
 func (p *PostgresBackup) Connect(ctx context.Context) error {
-	dsn := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable",
-		p.config.Host,
-		p.config.Port,
-		p.config.Username,
-		p.config.Password,
-		p.config.Database,
-	)
+	if p.config.SSHTunnel != nil {
+		network, address := "tcp", net.JoinHostPort(p.config.Host, strconv.Itoa(p.config.Port))
+		if p.config.Socket != "" {
+			dir, port := postgresSocket(p.config)
+			network, address = "unix", path.Join(dir, fmt.Sprintf(".s.PGSQL.%d", port))
+		}
+		tunnel, err := openTunnel(ctx, p.config.SSHTunnel, network, address)
+		if err != nil {
+			return err
+		}
+		p.tunnel = tunnel
+	}
+
+	// Quoted, as unquoted values end at the first space and a parse error
+	// would show the rest of the password
+	host, port, _ := p.endpoint()
+	dsn := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s",
+		dsnQuote(host),
+		port,
+		dsnQuote(p.config.Username),
+		dsnQuote(p.config.Password),
+		dsnQuote(p.config.Database),
+	) + postgresTLSParams(p.config)
 
-	db, err := sql.Open("postgres", dsn)
+	connector, err := pq.NewConnector(dsn)
 	if err != nil {
+		p.closeTunnel()
 		return fmt.Errorf("failed to connect to PostgreSQL: %w", err)
 	}
+	if p.tunnel != nil {
+		connector.Dialer(p.tunnel)
+	}
+	db := sql.OpenDB(connector)
 
 	err = db.PingContext(ctx)
 	if err != nil {
+		db.Close()
+		err = p.tunnel.wrap(err)
+		p.closeTunnel()
 		return fmt.Errorf("failed to ping PostgreSQL: %w", err)
 	}
 
@@ -45,24 +86,229 @@ func (p *PostgresBackup) Connect(ctx context.Context) error {
 	return nil
 }
 
-func (p *PostgresBackup) Backup(ctx context.Context, backupType BackupType) (io.Reader, error) {
-	cmd := exec.CommandContext(ctx, "pg_dump",
-		"-h", p.config.Host,
-		"-p", fmt.Sprintf("%d", p.config.Port),
+// endpoint returns the host and port the driver and the client tools
+// connect to: the server's, or the directory of its socket. Through an SSH
+// tunnel, the host is kept for TLS to verify, and the port and hostaddr are
+// those of the local end of the tunnel.
+func (p *PostgresBackup) endpoint() (host string, port int, hostaddr string) {
+	host, port = p.config.Host, p.config.Port
+	if p.config.Socket != "" {
+		host, port = postgresSocket(p.config)
+	}
+	if p.tunnel != nil {
+		if p.config.Socket != "" {
+			host = "localhost"
+		}
+		return host, p.tunnel.port(), "127.0.0.1"
+	}
+	return host, port, ""
+}
+
+// postgresSocket returns the directory of the server socket and its port,
+// from the socket file, e.g. /var/run/postgresql/.s.PGSQL.5432, or from the
+// directory and the configured port
+func postgresSocket(cfg config.DatabaseConfig) (string, int) {
+	dir, file := path.Split(cfg.Socket)
+	if suffix, ok := strings.CutPrefix(file, ".s.PGSQL."); ok {
+		if port, err := strconv.Atoi(suffix); err == nil {
+			return path.Clean(dir), port
+		}
+	}
+	if cfg.Port == 0 {
+		return cfg.Socket, 5432
+	}
+	return cfg.Socket, cfg.Port
+}
+
+// toolArgs returns the connection options of pg_dump and pg_restore
+func (p *PostgresBackup) toolArgs() []string {
+	host, port, _ := p.endpoint()
+	return []string{
+		"-h", host,
+		"-p", strconv.Itoa(port),
 		"-U", p.config.Username,
 		"-d", p.config.Database,
+	}
+}
+
+// toolEnv passes the TLS settings to pg_dump or pg_restore and, through an
+// SSH tunnel, the address to connect to in place of the host
+func (p *PostgresBackup) toolEnv(cmd *exec.Cmd) {
+	postgresTLS(cmd, p.config)
+	if _, _, hostaddr := p.endpoint(); hostaddr != "" {
+		cmd.Env = append(cmd.Env, "PGHOSTADDR="+hostaddr)
+	}
+}
+
+// closeTunnel tears down the SSH tunnel, if any
+func (p *PostgresBackup) closeTunnel() error {
+	if p.tunnel == nil {
+		return nil
+	}
+	err := p.tunnel.Close()
+	p.tunnel = nil
+	return err
+}
+
+// dsnEscaper escapes a quoted connection string value
+var dsnEscaper = strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+
+func dsnQuote(s string) string {
+	return "'" + dsnEscaper.Replace(s) + "'"
+}
+
+func (p *PostgresBackup) Backup(ctx context.Context, backupType BackupType) (io.Reader, error) {
+	args := append(p.toolArgs(),
 		"-F", "c", // Use custom format
 	)
+	if p.config.DumpCompression != nil {
+		args = append(args, "-Z", fmt.Sprintf("%d", *p.config.DumpCompression))
+	}
+	dump, err := p.dumpTool(ctx)
+	if err != nil {
+		return nil, err
+	}
+	cmd, err := toolCommand(ctx, p.config, dump.Path, args...)
+	if err != nil {
+		return nil, err
+	}
+	p.toolEnv(cmd)
+	cleanup, err := postgresCredentials(cmd, p.config.Password)
+	if err != nil {
+		return nil, err
+	}
+	p.dumped = &dump
+
+	r, err := startCommand(cmd, cleanup)
+	if err != nil {
+		return nil, err
+	}
+	r.wrap = p.tunnel.wrap
+	return r, nil
+}
+
+// EstimateSize returns the on-disk size of the database
+func (p *PostgresBackup) EstimateSize(ctx context.Context) (int64, error) {
+	var size int64
+	if err := p.db.QueryRowContext(ctx, "SELECT pg_database_size(current_database())").Scan(&size); err != nil {
+		return 0, fmt.Errorf("failed to query database size: %w", err)
+	}
+	return size, nil
+}
+
+// CheckTools verifies that pg_dump and pg_restore are installed and that
+// pg_dump is at least as new as the server, which it requires
+func (p *PostgresBackup) CheckTools(ctx context.Context) ([]ToolInfo, error) {
+	dump, err := p.dumpTool(ctx)
+	if dump.Path == "" {
+		return nil, err
+	}
+	tools := []ToolInfo{dump}
+	restore, restoreErr := p.tools.find(ctx, "pg_restore")
+	if restoreErr != nil {
+		return tools, restoreErr
+	}
+	return append(tools, restore), err
+}
+
+// DumpTool returns the pg_dump the last backup ran
+func (p *PostgresBackup) DumpTool() *ToolInfo {
+	return p.dumped
+}
+
+// dumpTool returns the newest installed pg_dump, which must be at least as
+// new as the server
+func (p *PostgresBackup) dumpTool(ctx context.Context) (ToolInfo, error) {
+	var versionNum int
+	if err := p.db.QueryRowContext(ctx, "SHOW server_version_num").Scan(&versionNum); err != nil {
+		return ToolInfo{}, fmt.Errorf("failed to query server version: %w", err)
+	}
+
+	dump, err := p.tools.find(ctx, "pg_dump")
+	if err != nil {
+		return dump, err
+	}
+	return dump, checkPgDumpVersion(dump, versionNum)
+}
+
+// checkPgDumpVersion fails when pg_dump is of an older major version than the
+// server, whose version is given as server_version_num
+func checkPgDumpVersion(dump ToolInfo, versionNum int) error {
+	// Major versions are 9.6 style before PostgreSQL 10
+	serverMajor := fmt.Sprintf("%d", versionNum/10000)
+	if versionNum < 100000 {
+		serverMajor = fmt.Sprintf("%d.%d", versionNum/10000, versionNum/100%100)
+	}
+	if dump.Version != "" && compareVersions(dump.Version, serverMajor) < 0 {
+		return fmt.Errorf("pg_dump %s cannot back up a PostgreSQL %s server, install pg_dump %s or newer or set its path in tool_paths",
+			dump.Version, serverMajor, serverMajor)
+	}
+	return nil
+}
+
+// ReplicationStatus reports whether the server is a standby in recovery and
+// how long ago it replayed the last transaction of its primary, no lag when
+// it streams from its primary and has replayed all it received, and unknown
+// lag when its WAL receiver is not streaming
+func (p *PostgresBackup) ReplicationStatus(ctx context.Context) (ReplicationStatus, error) {
+	var status ReplicationStatus
+	if err := p.db.QueryRowContext(ctx, "SELECT pg_is_in_recovery()").Scan(&status.Replica); err != nil {
+		return status, fmt.Errorf("failed to query recovery status: %w", err)
+	}
+	if !status.Replica {
+		return status, nil
+	}
 
-	// Set PGPASSWORD environment variable
-	cmd.Env = append(cmd.Env, fmt.Sprintf("PGPASSWORD=%s", p.config.Password))
+	// A standby whose receiver disconnected has replayed all it received
+	// too, while falling behind. The status is NULL to users without
+	// pg_read_all_stats, who only see that a receiver is running. The lag
+	// is NULL before the standby replayed any transaction.
+	var streaming bool
+	var lag sql.NullFloat64
+	err := p.db.QueryRowContext(ctx, `SELECT
+		EXISTS (SELECT 1 FROM pg_stat_wal_receiver WHERE status = 'streaming' OR status IS NULL),
+		CASE WHEN pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+		ELSE EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()) END`).Scan(&streaming, &lag)
+	if err != nil {
+		return status, fmt.Errorf("failed to query replication lag: %w", err)
+	}
+	status.Lag = -1
+	if streaming && lag.Valid {
+		status.Lag = time.Duration(lag.Float64 * float64(time.Second))
+	}
+	return status, nil
+}
 
-	output, err := cmd.Output()
+// CheckPrivileges verifies that the user can read every table and sequence
+// pg_dump has to dump
+func (p *PostgresBackup) CheckPrivileges(ctx context.Context) error {
+	rows, err := p.db.QueryContext(ctx, `SELECT n.nspname || '.' || c.relname
+		FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE c.relkind IN ('r', 'p', 'S', 'm')
+			AND n.nspname NOT IN ('pg_catalog', 'information_schema')
+			AND n.nspname NOT LIKE 'pg_toast%'
+			AND NOT has_table_privilege(c.oid, 'SELECT')
+		ORDER BY 1`)
 	if err != nil {
-		return nil, fmt.Errorf("backup failed: %w", err)
+		return fmt.Errorf("failed to query table privileges: %w", err)
 	}
+	defer rows.Close()
 
-	return io.NopCloser(bytes.NewReader(output)), nil
+	var denied []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return fmt.Errorf("failed to query table privileges: %w", err)
+		}
+		denied = append(denied, name)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to query table privileges: %w", err)
+	}
+	if len(denied) > 0 {
+		return fmt.Errorf("no SELECT privilege on %d tables: %s", len(denied), abbreviate(denied, 5))
+	}
+	return nil
 }
 
 func (p *PostgresBackup) Restore(ctx context.Context, backupFile io.Reader) error {
@@ -72,34 +318,44 @@ func (p *PostgresBackup) Restore(ctx context.Context, backupFile io.Reader) erro
 		return fmt.Errorf("failed to create temp file: %w", err)
 	}
 	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
 
 	// Copy backup data to temp file
 	if _, err := io.Copy(tmpFile, backupFile); err != nil {
 		return fmt.Errorf("failed to write backup to temp file: %w", err)
 	}
 
-	cmd := exec.CommandContext(ctx, "pg_restore",
-		"-h", p.config.Host,
-		"-p", fmt.Sprintf("%d", p.config.Port),
-		"-U", p.config.Username,
-		"-d", p.config.Database,
+	restore, err := p.tools.find(ctx, "pg_restore")
+	if err != nil {
+		return err
+	}
+	cmd, err := toolCommand(ctx, p.config, restore.Path, append(p.toolArgs(),
 		"-c",      // Clean (drop) database objects before recreating
 		"-F", "c", // Custom format
 		tmpFile.Name(),
-	)
+	)...)
+	if err != nil {
+		return err
+	}
 
-	cmd.Env = append(cmd.Env, fmt.Sprintf("PGPASSWORD=%s", p.config.Password))
+	p.toolEnv(cmd)
+	cleanup, err := postgresCredentials(cmd, p.config.Password)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
 
 	if output, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("restore failed: %s: %w", string(output), err)
+		return fmt.Errorf("restore failed: %s: %w", string(output), p.tunnel.wrap(err))
 	}
 
 	return nil
 }
 
 func (p *PostgresBackup) Close() error {
+	var err error
 	if p.db != nil {
-		return p.db.Close()
+		err = p.db.Close()
 	}
-	return nil
+	return errors.Join(err, p.closeTunnel())
 }
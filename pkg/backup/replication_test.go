@@ -0,0 +1,239 @@
+package backup
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeResult is the result of the queries containing its key
+type fakeResult struct {
+	columns []string
+	rows    [][]driver.Value
+	err     error
+}
+
+// fakeServer answers queries with the result whose key the query contains,
+// standing in for a database server
+type fakeServer map[string]fakeResult
+
+// Connect and Driver make the server a driver.Connector of its own
+// connections, which only run queries
+func (s fakeServer) Connect(ctx context.Context) (driver.Conn, error) {
+	return s, nil
+}
+
+func (s fakeServer) Driver() driver.Driver {
+	return nil
+}
+
+func (s fakeServer) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("prepared statements are not supported")
+}
+
+func (s fakeServer) Close() error {
+	return nil
+}
+
+func (s fakeServer) Begin() (driver.Tx, error) {
+	return nil, errors.New("transactions are not supported")
+}
+
+func (s fakeServer) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	for key, result := range s {
+		if strings.Contains(query, key) {
+			if result.err != nil {
+				return nil, result.err
+			}
+			return &fakeRows{result: result}, nil
+		}
+	}
+	return nil, errors.New("unexpected query: " + query)
+}
+
+type fakeRows struct {
+	result fakeResult
+	next   int
+}
+
+func (r *fakeRows) Columns() []string { return r.result.columns }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.next == len(r.result.rows) {
+		return io.EOF
+	}
+	copy(dest, r.result.rows[r.next])
+	r.next++
+	return nil
+}
+
+// openFake returns a database whose queries the server answers
+func openFake(t *testing.T, server fakeServer) *sql.DB {
+	t.Helper()
+	db := sql.OpenDB(server)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestPostgresReplicationStatus(t *testing.T) {
+	recovery := func(inRecovery bool) fakeResult {
+		return fakeResult{columns: []string{"pg_is_in_recovery"}, rows: [][]driver.Value{{inRecovery}}}
+	}
+	lag := func(streaming bool, seconds any) fakeResult {
+		return fakeResult{columns: []string{"exists", "lag"}, rows: [][]driver.Value{{streaming, seconds}}}
+	}
+
+	tests := []struct {
+		name    string
+		server  fakeServer
+		want    ReplicationStatus
+		wantErr string
+	}{
+		{
+			name:   "primary",
+			server: fakeServer{"pg_is_in_recovery": recovery(false)},
+			want:   ReplicationStatus{},
+		},
+		{
+			name:   "streaming and caught up",
+			server: fakeServer{"pg_is_in_recovery": recovery(true), "pg_stat_wal_receiver": lag(true, 0.0)},
+			want:   ReplicationStatus{Replica: true, Lag: 0},
+		},
+		{
+			name:   "streaming behind",
+			server: fakeServer{"pg_is_in_recovery": recovery(true), "pg_stat_wal_receiver": lag(true, 12.5)},
+			want:   ReplicationStatus{Replica: true, Lag: 12500 * time.Millisecond},
+		},
+		{
+			// A standby whose receiver disconnected has replayed all it
+			// received, which is no proof it is caught up
+			name:   "not streaming",
+			server: fakeServer{"pg_is_in_recovery": recovery(true), "pg_stat_wal_receiver": lag(false, 0.0)},
+			want:   ReplicationStatus{Replica: true, Lag: -1},
+		},
+		{
+			name:   "nothing replayed yet",
+			server: fakeServer{"pg_is_in_recovery": recovery(true), "pg_stat_wal_receiver": lag(true, nil)},
+			want:   ReplicationStatus{Replica: true, Lag: -1},
+		},
+		{
+			name:    "recovery query fails",
+			server:  fakeServer{"pg_is_in_recovery": {err: errors.New("connection reset")}},
+			wantErr: "failed to query recovery status: connection reset",
+		},
+		{
+			name:    "lag query fails",
+			server:  fakeServer{"pg_is_in_recovery": recovery(true), "pg_stat_wal_receiver": {err: errors.New("permission denied")}},
+			wantErr: "failed to query replication lag: permission denied",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &PostgresBackup{db: openFake(t, tt.server)}
+			status, err := p.ReplicationStatus(context.Background())
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("ReplicationStatus returned %v, want an error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || status != tt.want {
+				t.Errorf("ReplicationStatus = %+v, %v, want %+v", status, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestMySQLReplicationStatus(t *testing.T) {
+	columns := []string{"Replica_IO_State", "Replica_IO_Running", "Replica_SQL_Running", "Seconds_Behind_Source", "Channel_Name"}
+	// Before MySQL 8.0.22 and MariaDB 10.5.1
+	oldColumns := []string{"Slave_IO_State", "Slave_IO_Running", "Slave_SQL_Running", "Seconds_Behind_Master", "Channel_Name"}
+	channel := func(io, sql string, seconds any) []driver.Value {
+		if s, ok := seconds.(string); ok {
+			seconds = []byte(s)
+		}
+		return []driver.Value{[]byte("Waiting for source to send event"), []byte(io), []byte(sql), seconds, []byte("")}
+	}
+	unsupported := fakeResult{err: errors.New("You have an error in your SQL syntax")}
+
+	tests := []struct {
+		name    string
+		server  fakeServer
+		want    ReplicationStatus
+		wantErr string
+	}{
+		{
+			name:   "not a replica",
+			server: fakeServer{"SHOW REPLICA STATUS": {columns: columns}},
+			want:   ReplicationStatus{},
+		},
+		{
+			name:   "running",
+			server: fakeServer{"SHOW REPLICA STATUS": {columns: columns, rows: [][]driver.Value{channel("Yes", "Yes", "5")}}},
+			want:   ReplicationStatus{Replica: true, Lag: 5 * time.Second},
+		},
+		{
+			name:   "IO thread stopped",
+			server: fakeServer{"SHOW REPLICA STATUS": {columns: columns, rows: [][]driver.Value{channel("No", "Yes", "0")}}},
+			want:   ReplicationStatus{Replica: true, Lag: -1},
+		},
+		{
+			name:   "SQL thread stopped",
+			server: fakeServer{"SHOW REPLICA STATUS": {columns: columns, rows: [][]driver.Value{channel("Yes", "No", nil)}}},
+			want:   ReplicationStatus{Replica: true, Lag: -1},
+		},
+		{
+			name:   "connecting",
+			server: fakeServer{"SHOW REPLICA STATUS": {columns: columns, rows: [][]driver.Value{channel("Connecting", "Yes", nil)}}},
+			want:   ReplicationStatus{Replica: true, Lag: -1},
+		},
+		{
+			name: "channel furthest behind",
+			server: fakeServer{"SHOW REPLICA STATUS": {columns: columns, rows: [][]driver.Value{
+				channel("Yes", "Yes", "5"), channel("Yes", "Yes", "30"), channel("Yes", "Yes", "0"),
+			}}},
+			want: ReplicationStatus{Replica: true, Lag: 30 * time.Second},
+		},
+		{
+			name: "one channel stopped",
+			server: fakeServer{"SHOW REPLICA STATUS": {columns: columns, rows: [][]driver.Value{
+				channel("Yes", "Yes", "5"), channel("No", "No", nil), channel("Yes", "Yes", "30"),
+			}}},
+			want: ReplicationStatus{Replica: true, Lag: -1},
+		},
+		{
+			name: "old server",
+			server: fakeServer{
+				"SHOW REPLICA STATUS": unsupported,
+				"SHOW SLAVE STATUS":   {columns: oldColumns, rows: [][]driver.Value{channel("Yes", "Yes", "7")}},
+			},
+			want: ReplicationStatus{Replica: true, Lag: 7 * time.Second},
+		},
+		{
+			name:    "query fails",
+			server:  fakeServer{"SHOW REPLICA STATUS": unsupported, "SHOW SLAVE STATUS": {err: errors.New("access denied")}},
+			wantErr: "failed to query replica status: access denied",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &MySQLBackup{db: openFake(t, tt.server)}
+			status, err := m.ReplicationStatus(context.Background())
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("ReplicationStatus returned %v, want an error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || status != tt.want {
+				t.Errorf("ReplicationStatus = %+v, %v, want %+v", status, err, tt.want)
+			}
+		})
+	}
+}
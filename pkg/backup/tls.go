@@ -0,0 +1,152 @@
+package backup
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+
+	"github.com/yeboahd24/dbBackupUitility/pkg/config"
+)
+
+// TLS settings apply to the connections of dbbackup itself and of the client
+// tools alike, so that a server requiring TLS, or a client certificate, can
+// be backed up with either. The modes are those of libpq:
+//
+//	disable      no TLS (default)
+//	require      TLS without verifying the server certificate
+//	verify-ca    TLS with a server certificate signed by a trusted CA
+//	verify-full  verify-ca, and the certificate matches the host name
+
+// SSLModes are the supported values of ssl_mode
+var SSLModes = []string{"disable", "require", "verify-ca", "verify-full"}
+
+// sslMode returns the configured TLS mode, or disable
+func sslMode(cfg config.DatabaseConfig) string {
+	if cfg.SSLMode == "" {
+		return "disable"
+	}
+	return cfg.SSLMode
+}
+
+// postgresTLSParams returns the TLS parameters of a PostgreSQL connection
+// string
+func postgresTLSParams(cfg config.DatabaseConfig) string {
+	params := " sslmode=" + dsnQuote(sslMode(cfg))
+	for _, param := range tlsFiles(cfg, "sslrootcert", "sslcert", "sslkey") {
+		params += " " + param[0] + "=" + dsnQuote(param[1])
+	}
+	return params
+}
+
+// postgresTLS passes the TLS settings to pg_dump or pg_restore through the
+// libpq environment variables
+func postgresTLS(cmd *exec.Cmd, cfg config.DatabaseConfig) {
+	env := append(cmd.Environ(), "PGSSLMODE="+sslMode(cfg))
+	for _, v := range tlsFiles(cfg, "PGSSLROOTCERT", "PGSSLCERT", "PGSSLKEY") {
+		env = append(env, v[0]+"="+v[1])
+	}
+	cmd.Env = env
+}
+
+// tlsFiles pairs the names given for the CA, certificate and key with the
+// configured files, leaving out those not set
+func tlsFiles(cfg config.DatabaseConfig, ca, cert, key string) [][2]string {
+	var files [][2]string
+	for _, file := range [][2]string{{ca, cfg.SSLCA}, {cert, cfg.SSLCert}, {key, cfg.SSLKey}} {
+		if file[1] != "" {
+			files = append(files, file)
+		}
+	}
+	return files
+}
+
+// mysqlTLSConfig returns the TLS configuration of the MySQL driver, or nil
+// when TLS is disabled
+func mysqlTLSConfig(cfg config.DatabaseConfig) (*tls.Config, error) {
+	mode := sslMode(cfg)
+	if mode == "disable" {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{}
+	if cfg.SSLCert != "" || cfg.SSLKey != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.SSLCert, cfg.SSLKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	if cfg.SSLCA != "" {
+		pem, err := os.ReadFile(cfg.SSLCA)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA certificate: %w", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.SSLCA)
+		}
+	}
+
+	switch mode {
+	case "require":
+		tlsConfig.InsecureSkipVerify = true
+	case "verify-ca":
+		// Verify the chain but not the host name, which crypto/tls only
+		// does together
+		tlsConfig.InsecureSkipVerify = true
+		roots := tlsConfig.RootCAs
+		tlsConfig.VerifyConnection = func(state tls.ConnectionState) error {
+			if len(state.PeerCertificates) == 0 {
+				return errors.New("server sent no certificate")
+			}
+			opts := x509.VerifyOptions{Roots: roots, Intermediates: x509.NewCertPool()}
+			for _, cert := range state.PeerCertificates[1:] {
+				opts.Intermediates.AddCert(cert)
+			}
+			_, err := state.PeerCertificates[0].Verify(opts)
+			return err
+		}
+	case "verify-full":
+		// The driver sets the server name to the host
+	default:
+		return nil, fmt.Errorf("unsupported ssl_mode: %s", mode)
+	}
+	return tlsConfig, nil
+}
+
+// mysqlTLSArgs returns the TLS options of mysqldump or mysql. MariaDB
+// clients have no CA-only verification, so verify-ca verifies the host name
+// as well.
+func mysqlTLSArgs(cfg config.DatabaseConfig, tool ToolInfo) []string {
+	mode := sslMode(cfg)
+	var args []string
+	if tool.MariaDB {
+		switch mode {
+		case "disable":
+			return []string{"--skip-ssl"}
+		case "require":
+			args = []string{"--ssl", "--skip-ssl-verify-server-cert"}
+		default:
+			args = []string{"--ssl", "--ssl-verify-server-cert"}
+		}
+	} else {
+		modes := map[string]string{
+			"disable":     "DISABLED",
+			"require":     "REQUIRED",
+			"verify-ca":   "VERIFY_CA",
+			"verify-full": "VERIFY_IDENTITY",
+		}
+		args = []string{"--ssl-mode=" + modes[mode]}
+		if mode == "disable" {
+			return args
+		}
+	}
+
+	for _, file := range tlsFiles(cfg, "--ssl-ca", "--ssl-cert", "--ssl-key") {
+		args = append(args, file[0]+"="+file[1])
+	}
+	return args
+}
@@ -0,0 +1,282 @@
+package backup
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/yeboahd24/dbBackupUitility/pkg/config"
+)
+
+// testCert is a certificate and key written to PEM files
+type testCert struct {
+	cert     *x509.Certificate
+	key      *ecdsa.PrivateKey
+	certFile string
+	keyFile  string
+}
+
+// newCert writes a certificate for name signed by parent, or a self-signed
+// CA when parent is nil
+func newCert(t *testing.T, dir, name string, parent *testCert, hosts ...string) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     hosts,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := &testCert{
+		cert:     cert,
+		key:      key,
+		certFile: filepath.Join(dir, name+".pem"),
+		keyFile:  filepath.Join(dir, name+"-key.pem"),
+	}
+	writePEM(t, c.certFile, "CERTIFICATE", der)
+	writePEM(t, c.keyFile, "EC PRIVATE KEY", keyDER)
+	return c
+}
+
+func writePEM(t *testing.T, path, typ string, der []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPostgresTLSParams(t *testing.T) {
+	tests := []struct {
+		cfg  config.DatabaseConfig
+		want string
+		env  []string
+	}{
+		{config.DatabaseConfig{}, " sslmode='disable'", []string{"PGSSLMODE=disable"}},
+		{config.DatabaseConfig{SSLMode: "require"}, " sslmode='require'", []string{"PGSSLMODE=require"}},
+		{
+			config.DatabaseConfig{SSLMode: "verify-ca", SSLCA: "/etc/tls/ca.pem"},
+			" sslmode='verify-ca' sslrootcert='/etc/tls/ca.pem'",
+			[]string{"PGSSLMODE=verify-ca", "PGSSLROOTCERT=/etc/tls/ca.pem"},
+		},
+		{
+			config.DatabaseConfig{SSLMode: "verify-full", SSLCA: "/etc/tls/ca.pem", SSLCert: "/etc/tls/it's.pem", SSLKey: "/etc/tls/key.pem"},
+			` sslmode='verify-full' sslrootcert='/etc/tls/ca.pem' sslcert='/etc/tls/it\'s.pem' sslkey='/etc/tls/key.pem'`,
+			[]string{"PGSSLMODE=verify-full", "PGSSLROOTCERT=/etc/tls/ca.pem", "PGSSLCERT=/etc/tls/it's.pem", "PGSSLKEY=/etc/tls/key.pem"},
+		},
+	}
+	for _, tt := range tests {
+		params := postgresTLSParams(tt.cfg)
+		if params != tt.want {
+			t.Errorf("postgresTLSParams(%+v) = %q, want %q", tt.cfg, params, tt.want)
+		}
+		// lib/pq accepts the parameters
+		if _, err := pq.NewConnector("host=db" + params); err != nil {
+			t.Errorf("lib/pq rejected %q: %v", params, err)
+		}
+
+		cmd := exec.Command("pg_dump")
+		postgresTLS(cmd, tt.cfg)
+		if env := cmd.Env[len(cmd.Env)-len(tt.env):]; !reflect.DeepEqual(env, tt.env) {
+			t.Errorf("postgresTLS(%+v) set %v, want %v", tt.cfg, env, tt.env)
+		}
+	}
+}
+
+func TestMySQLTLSArgs(t *testing.T) {
+	files := config.DatabaseConfig{SSLCA: "/tls/ca.pem", SSLCert: "/tls/client.pem", SSLKey: "/tls/client-key.pem"}
+	fileArgs := []string{"--ssl-ca=/tls/ca.pem", "--ssl-cert=/tls/client.pem", "--ssl-key=/tls/client-key.pem"}
+	mysql, mariaDB := ToolInfo{Name: "mysqldump"}, ToolInfo{Name: "mysqldump", MariaDB: true}
+
+	tests := []struct {
+		mode string
+		tool ToolInfo
+		want []string
+	}{
+		{"", mysql, []string{"--ssl-mode=DISABLED"}},
+		{"disable", mysql, []string{"--ssl-mode=DISABLED"}},
+		{"require", mysql, append([]string{"--ssl-mode=REQUIRED"}, fileArgs...)},
+		{"verify-ca", mysql, append([]string{"--ssl-mode=VERIFY_CA"}, fileArgs...)},
+		{"verify-full", mysql, append([]string{"--ssl-mode=VERIFY_IDENTITY"}, fileArgs...)},
+		{"disable", mariaDB, []string{"--skip-ssl"}},
+		{"require", mariaDB, append([]string{"--ssl", "--skip-ssl-verify-server-cert"}, fileArgs...)},
+		// MariaDB cannot verify the CA without the host name
+		{"verify-ca", mariaDB, append([]string{"--ssl", "--ssl-verify-server-cert"}, fileArgs...)},
+		{"verify-full", mariaDB, append([]string{"--ssl", "--ssl-verify-server-cert"}, fileArgs...)},
+	}
+	for _, tt := range tests {
+		cfg := files
+		cfg.SSLMode = tt.mode
+		if got := mysqlTLSArgs(cfg, tt.tool); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("mysqlTLSArgs(%q, MariaDB %v) = %v, want %v", tt.mode, tt.tool.MariaDB, got, tt.want)
+		}
+	}
+
+	// Files not configured are left out
+	got := mysqlTLSArgs(config.DatabaseConfig{SSLMode: "verify-ca", SSLCA: "/tls/ca.pem"}, mysql)
+	if want := []string{"--ssl-mode=VERIFY_CA", "--ssl-ca=/tls/ca.pem"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("mysqlTLSArgs with only a CA = %v, want %v", got, want)
+	}
+}
+
+func TestMySQLTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+	ca := newCert(t, dir, "ca", nil)
+	client := newCert(t, dir, "client", ca)
+	otherCA := newCert(t, dir, "other-ca", nil)
+	garbage := filepath.Join(dir, "garbage.pem")
+	if err := os.WriteFile(garbage, []byte("not a certificate"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		cfg     config.DatabaseConfig
+		wantErr string
+	}{
+		{name: "require", cfg: config.DatabaseConfig{SSLMode: "require"}},
+		{name: "verify-ca", cfg: config.DatabaseConfig{SSLMode: "verify-ca", SSLCA: ca.certFile}},
+		{name: "verify-full", cfg: config.DatabaseConfig{SSLMode: "verify-full", SSLCA: ca.certFile, SSLCert: client.certFile, SSLKey: client.keyFile}},
+		{name: "missing CA", cfg: config.DatabaseConfig{SSLMode: "verify-ca", SSLCA: filepath.Join(dir, "missing.pem")}, wantErr: "failed to read CA certificate"},
+		{name: "invalid CA", cfg: config.DatabaseConfig{SSLMode: "verify-ca", SSLCA: garbage}, wantErr: "no certificates found in " + garbage},
+		{name: "key of another certificate", cfg: config.DatabaseConfig{SSLMode: "require", SSLCert: client.certFile, SSLKey: otherCA.keyFile}, wantErr: "failed to load client certificate"},
+		{name: "certificate without key", cfg: config.DatabaseConfig{SSLMode: "require", SSLCert: client.certFile}, wantErr: "failed to load client certificate"},
+		{name: "unsupported mode", cfg: config.DatabaseConfig{SSLMode: "prefer"}, wantErr: "unsupported ssl_mode: prefer"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tlsConfig, err := mysqlTLSConfig(tt.cfg)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("mysqlTLSConfig returned %v, want an error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.cfg.SSLCert != "" && len(tlsConfig.Certificates) != 1 {
+				t.Errorf("client certificate not loaded")
+			}
+			// Only verify-full leaves the host name check to crypto/tls
+			if tlsConfig.InsecureSkipVerify != (tt.cfg.SSLMode != "verify-full") {
+				t.Errorf("InsecureSkipVerify is %v for %s", tlsConfig.InsecureSkipVerify, tt.cfg.SSLMode)
+			}
+		})
+	}
+
+	if tlsConfig, err := mysqlTLSConfig(config.DatabaseConfig{}); tlsConfig != nil || err != nil {
+		t.Errorf("mysqlTLSConfig without ssl_mode = %v, %v, want no TLS", tlsConfig, err)
+	}
+}
+
+// TestMySQLVerifyCA connects to servers whose certificates name another host
+// and checks that verify-ca only accepts the one signed by the CA
+func TestMySQLVerifyCA(t *testing.T) {
+	dir := t.TempDir()
+	ca := newCert(t, dir, "ca", nil)
+	trusted := newCert(t, dir, "trusted", ca, "db.internal")
+	otherCA := newCert(t, dir, "other-ca", nil)
+	untrusted := newCert(t, dir, "untrusted", otherCA, "db.internal")
+
+	tlsConfig, err := mysqlTLSConfig(config.DatabaseConfig{SSLMode: "verify-ca", SSLCA: ca.certFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		server *testCert
+		ok     bool
+	}{{trusted, true}, {untrusted, false}} {
+		address := serveTLS(t, tt.server)
+		clientConfig := tlsConfig.Clone()
+		clientConfig.ServerName = "127.0.0.1"
+		conn, err := tls.Dial("tcp", address, clientConfig)
+		if err == nil {
+			conn.Close()
+		}
+		if (err == nil) != tt.ok {
+			t.Errorf("handshake with a certificate from %s returned %v", tt.server.cert.Issuer.CommonName, err)
+		}
+	}
+}
+
+// serveTLS accepts TLS connections with the certificate until the test ends
+// and returns the address
+func serveTLS(t *testing.T, cert *testCert) string {
+	t.Helper()
+	pair, err := tls.LoadX509KeyPair(cert.certFile, cert.keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{pair}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				conn.(*tls.Conn).Handshake()
+			}(conn)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestSSLModes(t *testing.T) {
+	// Every mode listed is supported
+	for _, mode := range SSLModes {
+		if _, err := mysqlTLSConfig(config.DatabaseConfig{SSLMode: mode}); err != nil {
+			t.Errorf("ssl_mode %s: %v", mode, err)
+		}
+	}
+	if !slices.Contains(SSLModes, sslMode(config.DatabaseConfig{})) {
+		t.Errorf("default ssl_mode %q is not supported", sslMode(config.DatabaseConfig{}))
+	}
+}
@@ -0,0 +1,248 @@
+package backup
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yeboahd24/dbBackupUitility/pkg/config"
+)
+
+// ioniceClasses maps the configured I/O scheduling classes to ionice -c values
+var ioniceClasses = map[string]string{
+	"best-effort": "2",
+	"idle":        "3",
+}
+
+// toolCommand builds the command running a database client tool, run through
+// ionice and nice when lower priorities are configured
+func toolCommand(ctx context.Context, cfg config.DatabaseConfig, name string, args ...string) (*exec.Cmd, error) {
+	var prefix []string
+	if cfg.IONiceClass != "" {
+		if runtime.GOOS != "linux" {
+			return nil, fmt.Errorf("ionice_class is only supported on Linux")
+		}
+		class, ok := ioniceClasses[cfg.IONiceClass]
+		if !ok {
+			return nil, fmt.Errorf("unsupported ionice_class: %s", cfg.IONiceClass)
+		}
+		prefix = append(prefix, "ionice", "-c", class)
+		if cfg.IONiceLevel != nil && cfg.IONiceClass == "best-effort" {
+			prefix = append(prefix, "-n", strconv.Itoa(*cfg.IONiceLevel))
+		}
+	}
+	if cfg.Nice != 0 {
+		if runtime.GOOS == "windows" {
+			return nil, fmt.Errorf("nice is not supported on Windows")
+		}
+		prefix = append(prefix, "nice", "-n", strconv.Itoa(cfg.Nice))
+	}
+
+	if len(prefix) == 0 {
+		return exec.CommandContext(ctx, name, args...), nil
+	}
+	args = append(append(prefix[1:], name), args...)
+	return exec.CommandContext(ctx, prefix[0], args...), nil
+}
+
+var (
+	// MariaDB clients report their client protocol version before the release
+	distribVersion = regexp.MustCompile(`Distrib (\d+(?:\.\d+)*)`)
+	toolVersionRe  = regexp.MustCompile(`\d+(?:\.\d+)*`)
+)
+
+// toolVersion asks the tool at path for its version
+func toolVersion(ctx context.Context, name, path string) (ToolInfo, error) {
+	output, err := exec.CommandContext(ctx, path, "--version").Output()
+	if err != nil {
+		return ToolInfo{Name: name, Path: path}, fmt.Errorf("failed to run %s --version: %w", path, err)
+	}
+
+	line, _, _ := strings.Cut(string(output), "\n")
+	version := toolVersionRe.FindString(line)
+	if m := distribVersion.FindStringSubmatch(line); m != nil {
+		version = m[1]
+	}
+	return ToolInfo{Name: name, Path: path, Version: version, MariaDB: strings.Contains(line, "MariaDB")}, nil
+}
+
+// toolSet finds the client tools of one database connection and remembers
+// the ones it picked
+type toolSet struct {
+	cfg   config.DatabaseConfig
+	dirs  []string // usual install locations of other client versions
+	found map[string]ToolInfo
+}
+
+func newToolSet(cfg config.DatabaseConfig, dirs ...string) *toolSet {
+	return &toolSet{cfg: cfg, dirs: dirs, found: make(map[string]ToolInfo)}
+}
+
+// find returns the configured path of the tool or, when none is set, the
+// newest version installed in PATH, tool_dirs or the usual install locations
+func (t *toolSet) find(ctx context.Context, name string) (ToolInfo, error) {
+	if tool, ok := t.found[name]; ok {
+		return tool, nil
+	}
+	if path := t.cfg.ToolPaths[name]; path != "" {
+		tool, err := toolVersion(ctx, name, path)
+		if err != nil {
+			return tool, err
+		}
+		t.found[name] = tool
+		return tool, nil
+	}
+
+	var candidates []string
+	if path, err := exec.LookPath(name); err == nil {
+		candidates = append(candidates, path)
+	}
+	for _, pattern := range append(slices.Clone(t.cfg.ToolDirs), t.dirs...) {
+		dirs, err := filepath.Glob(pattern)
+		if err != nil {
+			return ToolInfo{Name: name}, fmt.Errorf("invalid tool directory %q: %w", pattern, err)
+		}
+		for _, dir := range dirs {
+			if path, err := exec.LookPath(filepath.Join(dir, name)); err == nil && !slices.Contains(candidates, path) {
+				candidates = append(candidates, path)
+			}
+		}
+	}
+	if len(candidates) == 0 {
+		return ToolInfo{Name: name}, fmt.Errorf("%s not found in PATH or the tool directories", name)
+	}
+
+	// PATH wins between equal versions, as it comes first
+	var newest ToolInfo
+	var errs []error
+	for _, path := range candidates {
+		tool, err := toolVersion(ctx, name, path)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if newest.Path == "" || compareVersions(tool.Version, newest.Version) > 0 {
+			newest = tool
+		}
+	}
+	if newest.Path == "" {
+		return ToolInfo{Name: name}, errors.Join(errs...)
+	}
+	t.found[name] = newest
+	return newest, nil
+}
+
+// compareVersions compares dotted version numbers, returning -1, 0 or 1
+func compareVersions(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < max(len(as), len(bs)); i++ {
+		var x, y int
+		if i < len(as) {
+			x, _ = strconv.Atoi(as[i])
+		}
+		if i < len(bs) {
+			y, _ = strconv.Atoi(bs[i])
+		}
+		if x != y {
+			return cmp.Compare(x, y)
+		}
+	}
+	return 0
+}
+
+// majorVersion returns the leading number of a version string, or -1
+func majorVersion(version string) int {
+	major, _, _ := strings.Cut(version, ".")
+	n, err := strconv.Atoi(major)
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
+// abbreviate joins at most n names, noting how many were left out
+func abbreviate(names []string, n int) string {
+	if len(names) <= n {
+		return strings.Join(names, ", ")
+	}
+	return fmt.Sprintf("%s and %d more", strings.Join(names[:n], ", "), len(names)-n)
+}
+
+// commandReader streams the output of a started command. Its exit status is
+// reported as a read error once the output ends, so a failed dump is never
+// mistaken for a complete one.
+type commandReader struct {
+	cmd    *exec.Cmd
+	stdout io.ReadCloser
+	stderr bytes.Buffer
+	done   bool
+	err    error
+	exited func()            // releases what the command needed, once it has exited
+	wrap   func(error) error // adds context to the exit error, if set
+}
+
+// startCommand starts cmd and returns a reader over its output. exited is
+// called once the command has exited or failed to start.
+func startCommand(cmd *exec.Cmd, exited func()) (*commandReader, error) {
+	r := &commandReader{cmd: cmd, exited: exited}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		exited()
+		return nil, fmt.Errorf("backup failed: %w", err)
+	}
+	r.stdout = stdout
+	cmd.Stderr = &r.stderr
+	// Do not wait for children of a killed tool still holding stderr open
+	cmd.WaitDelay = time.Second
+	if err := cmd.Start(); err != nil {
+		exited()
+		return nil, fmt.Errorf("backup failed: %w", err)
+	}
+	return r, nil
+}
+
+func (r *commandReader) Read(p []byte) (int, error) {
+	if r.done {
+		return 0, r.err
+	}
+	n, err := r.stdout.Read(p)
+	if err == io.EOF {
+		r.done = true
+		r.err = io.EOF
+		if waitErr := r.cmd.Wait(); waitErr != nil {
+			r.err = fmt.Errorf("backup failed: %s: %w", strings.TrimSpace(r.stderr.String()), waitErr)
+			if r.wrap != nil {
+				r.err = r.wrap(r.err)
+			}
+		}
+		r.exited()
+	}
+	if r.done {
+		return n, r.err
+	}
+	return n, err
+}
+
+// Close stops the command if its output has not been read to the end
+func (r *commandReader) Close() error {
+	if r.done {
+		return nil
+	}
+	r.done = true
+	r.err = errors.New("backup stream closed")
+	r.cmd.Process.Kill()
+	r.cmd.Wait()
+	r.exited()
+	return nil
+}
@@ -0,0 +1,161 @@
+package backup
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/yeboahd24/dbBackupUitility/pkg/config"
+)
+
+// fakeTool installs a script named name in dir that prints output for
+// --version, and returns its path
+func fakeTool(t *testing.T, dir, name, output string) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("fake tools are shell scripts")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, name)
+	script := "#!/bin/sh\necho '" + output + "'\n"
+	if err := os.WriteFile(path, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"16.2", "16.2", 0},
+		{"16", "16.0", 0},
+		{"16.2", "16", 1},
+		{"9.6.24", "9.6", 1},
+		{"9.5.25", "9.6", -1},
+		{"10.1", "9.6", 1},
+		{"8.0.36", "8.4.0", -1},
+		{"10.11.6", "10.2", 1},
+	}
+	for _, tt := range tests {
+		if got := compareVersions(tt.a, tt.b); got != tt.want {
+			t.Errorf("compareVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestCheckPgDumpVersion(t *testing.T) {
+	tests := []struct {
+		dump       string
+		versionNum int
+		wantErr    string
+	}{
+		{"16.2", 160001, ""},
+		{"17.0", 160001, ""},
+		{"15.4", 160001, "pg_dump 15.4 cannot back up a PostgreSQL 16 server"},
+		{"9.6.1", 90624, ""},
+		{"10.1", 90624, ""},
+		// The major version before PostgreSQL 10 includes the minor number
+		{"9.5.25", 90624, "pg_dump 9.5.25 cannot back up a PostgreSQL 9.6 server, install pg_dump 9.6 or newer"},
+		{"", 160001, ""},
+	}
+	for _, tt := range tests {
+		err := checkPgDumpVersion(ToolInfo{Name: "pg_dump", Version: tt.dump}, tt.versionNum)
+		if tt.wantErr == "" {
+			if err != nil {
+				t.Errorf("pg_dump %s for server %d: %v", tt.dump, tt.versionNum, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("pg_dump %s for server %d returned %v, want an error containing %q", tt.dump, tt.versionNum, err, tt.wantErr)
+		}
+	}
+}
+
+func TestToolVersion(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		output  string
+		version string
+		mariaDB bool
+	}{
+		{"pg_dump (PostgreSQL) 16.2 (Ubuntu 16.2-1.pgdg22.04+1)", "16.2", false},
+		{"mysqldump  Ver 8.0.36 for Linux on x86_64 (MySQL Community Server - GPL)", "8.0.36", false},
+		// The protocol version comes first
+		{"mysqldump  Ver 10.19 Distrib 10.11.6-MariaDB, for debian-linux-gnu (x86_64)", "10.11.6", true},
+		{"mysqldump from 11.4.2-MariaDB, client 10.19 for Linux (x86_64)", "11.4.2", true},
+	}
+	for i, tt := range tests {
+		path := fakeTool(t, filepath.Join(dir, string(rune('a'+i))), "tool", tt.output)
+		tool, err := toolVersion(context.Background(), "tool", path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tool.Version != tt.version || tool.MariaDB != tt.mariaDB || tool.Path != path {
+			t.Errorf("toolVersion of %q = %+v, want version %s, MariaDB %v", tt.output, tool, tt.version, tt.mariaDB)
+		}
+	}
+
+	if _, err := toolVersion(context.Background(), "tool", filepath.Join(dir, "missing")); err == nil {
+		t.Error("toolVersion of a missing tool succeeded")
+	}
+}
+
+func TestToolSetFind(t *testing.T) {
+	dir := t.TempDir()
+	pathDir := filepath.Join(dir, "bin")
+	t.Setenv("PATH", pathDir)
+	inPath := fakeTool(t, pathDir, "pg_dump", "pg_dump (PostgreSQL) 15.4")
+	newer := fakeTool(t, filepath.Join(dir, "pg16", "bin"), "pg_dump", "pg_dump (PostgreSQL) 16.2")
+	configured := fakeTool(t, filepath.Join(dir, "pg14"), "pg_dump", "pg_dump (PostgreSQL) 14.1")
+	// The same version as in PATH
+	fakeTool(t, filepath.Join(dir, "pg15", "bin"), "pg_dump", "pg_dump (PostgreSQL) 15.4")
+
+	tests := []struct {
+		name string
+		cfg  config.DatabaseConfig
+		dirs []string
+		want string
+	}{
+		{"PATH only", config.DatabaseConfig{}, nil, inPath},
+		{"newest version wins", config.DatabaseConfig{ToolDirs: []string{filepath.Join(dir, "pg*", "bin")}}, nil, newer},
+		{"usual install locations", config.DatabaseConfig{}, []string{filepath.Join(dir, "pg16", "bin")}, newer},
+		{"configured path wins", config.DatabaseConfig{
+			ToolPaths: map[string]string{"pg_dump": configured},
+			ToolDirs:  []string{filepath.Join(dir, "pg*", "bin")},
+		}, nil, configured},
+		{"PATH wins a tie", config.DatabaseConfig{ToolDirs: []string{filepath.Join(dir, "pg15", "bin")}}, nil, inPath},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tools := newToolSet(tt.cfg, tt.dirs...)
+			tool, err := tools.find(context.Background(), "pg_dump")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tool.Path != tt.want {
+				t.Errorf("find picked %s (%s), want %s", tool.Path, tool.Version, tt.want)
+			}
+		})
+	}
+
+	tools := newToolSet(config.DatabaseConfig{})
+	if _, err := tools.find(context.Background(), "pg_restore"); err == nil || !strings.Contains(err.Error(), "pg_restore not found") {
+		t.Errorf("find of a missing tool returned %v", err)
+	}
+	// The tool picked is remembered
+	tool, _ := tools.find(context.Background(), "pg_dump")
+	if err := os.Remove(inPath); err != nil {
+		t.Fatal(err)
+	}
+	if again, err := tools.find(context.Background(), "pg_dump"); err != nil || again != tool {
+		t.Errorf("second find returned %+v, %v, want %+v", again, err, tool)
+	}
+}
@@ -0,0 +1,242 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/yeboahd24/dbBackupUitility/pkg/config"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// Databases behind a bastion are reached through an SSH tunnel opened by
+// Connect: a local port is forwarded through the bastion to the database
+// host and port, or to its Unix socket, and both the driver and the client
+// tools connect to it. Close tears the tunnel down.
+
+// sshTimeout bounds connecting to the bastion and the SSH handshake
+const sshTimeout = 30 * time.Second
+
+// sshTunnel forwards connections to a local port through an SSH bastion
+type sshTunnel struct {
+	client   *ssh.Client
+	listener net.Listener
+	network  string // of the database, as seen from the bastion
+	address  string
+	wg       sync.WaitGroup
+	closing  atomic.Bool
+
+	mu      sync.Mutex
+	dialErr error // last failure to reach the database from the bastion
+}
+
+// openTunnel connects to the bastion and forwards a local port to address,
+// a host and port for network tcp or a socket path for unix
+func openTunnel(ctx context.Context, cfg *config.SSHTunnelConfig, network, address string) (*sshTunnel, error) {
+	clientConfig, cleanup, err := sshClientConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	// The agent is only needed for the handshake
+	defer cleanup()
+
+	port := cfg.Port
+	if port == 0 {
+		port = 22
+	}
+	bastion := net.JoinHostPort(cfg.Host, strconv.Itoa(port))
+	dialCtx, cancel := context.WithTimeout(ctx, sshTimeout)
+	defer cancel()
+	conn, err := (&net.Dialer{}).DialContext(dialCtx, "tcp", bastion)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to SSH bastion %s: %w", bastion, err)
+	}
+	deadline, _ := dialCtx.Deadline()
+	conn.SetDeadline(deadline)
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, bastion, clientConfig)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("SSH handshake with %s failed: %w", bastion, err)
+	}
+	conn.SetDeadline(time.Time{})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		sshConn.Close()
+		return nil, fmt.Errorf("failed to open SSH tunnel: %w", err)
+	}
+	t := &sshTunnel{
+		client:   ssh.NewClient(sshConn, chans, reqs),
+		listener: listener,
+		network:  network,
+		address:  address,
+	}
+	t.wg.Add(1)
+	go t.serve()
+	return t, nil
+}
+
+// sshClientConfig authenticates with the configured key or, without one,
+// with the keys of the running ssh-agent. Host keys are verified against
+// known_hosts, never accepted blindly. cleanup closes the connection to the
+// agent, which signs during the handshake.
+func sshClientConfig(cfg *config.SSHTunnelConfig) (clientConfig *ssh.ClientConfig, cleanup func(), err error) {
+	var auth ssh.AuthMethod
+	cleanup = func() {}
+	if cfg.Key != "" {
+		pem, err := os.ReadFile(cfg.Key)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read SSH key: %w", err)
+		}
+		var signer ssh.Signer
+		if cfg.KeyPassphrase != "" {
+			signer, err = ssh.ParsePrivateKeyWithPassphrase(pem, []byte(cfg.KeyPassphrase))
+		} else {
+			signer, err = ssh.ParsePrivateKey(pem)
+		}
+		var missing *ssh.PassphraseMissingError
+		if errors.As(err, &missing) {
+			return nil, nil, fmt.Errorf("SSH key %s is encrypted, set key_passphrase", cfg.Key)
+		} else if err != nil {
+			return nil, nil, fmt.Errorf("failed to parse SSH key %s: %w", cfg.Key, err)
+		}
+		auth = ssh.PublicKeys(signer)
+	} else {
+		socket := os.Getenv("SSH_AUTH_SOCK")
+		if socket == "" {
+			return nil, nil, errors.New("no SSH key configured and no ssh-agent running, set ssh_tunnel.key")
+		}
+		conn, err := net.Dial("unix", socket)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to connect to ssh-agent: %w", err)
+		}
+		auth = ssh.PublicKeysCallback(agent.NewClient(conn).Signers)
+		cleanup = func() { conn.Close() }
+	}
+
+	knownHosts := cfg.KnownHosts
+	if knownHosts == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			cleanup()
+			return nil, nil, fmt.Errorf("failed to find known_hosts: %w", err)
+		}
+		knownHosts = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	hostKeys, err := knownhosts.New(knownHosts)
+	if err != nil {
+		cleanup()
+		return nil, nil, fmt.Errorf("failed to read known_hosts: %w", err)
+	}
+
+	return &ssh.ClientConfig{
+		User:            cfg.User,
+		Auth:            []ssh.AuthMethod{auth},
+		HostKeyCallback: hostKeys,
+	}, cleanup, nil
+}
+
+// port returns the local port forwarded to the database
+func (t *sshTunnel) port() int {
+	return t.listener.Addr().(*net.TCPAddr).Port
+}
+
+// DialContext connects to the local end of the tunnel, whatever the address
+// the driver asks for, which stays the database's for TLS to verify. With
+// Dial and DialTimeout, it makes the tunnel a dialer of both drivers.
+func (t *sshTunnel) DialContext(ctx context.Context, _, _ string) (net.Conn, error) {
+	return (&net.Dialer{}).DialContext(ctx, "tcp", t.listener.Addr().String())
+}
+
+func (t *sshTunnel) Dial(network, address string) (net.Conn, error) {
+	return t.DialContext(context.Background(), network, address)
+}
+
+func (t *sshTunnel) DialTimeout(network, address string, timeout time.Duration) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return t.DialContext(ctx, network, address)
+}
+
+// serve forwards the connections to the local port until the tunnel is
+// closed
+func (t *sshTunnel) serve() {
+	defer t.wg.Done()
+	for {
+		local, err := t.listener.Accept()
+		if err != nil {
+			return
+		}
+		t.wg.Add(1)
+		go func() {
+			defer t.wg.Done()
+			t.forward(local)
+		}()
+	}
+}
+
+// forward copies a local connection to the database and back
+func (t *sshTunnel) forward(local net.Conn) {
+	defer local.Close()
+	remote, err := t.client.Dial(t.network, t.address)
+	if err != nil {
+		if t.closing.Load() {
+			return
+		}
+		// The client only sees the connection close, so the bastion's
+		// error is kept for wrap to add to the client's
+		t.mu.Lock()
+		t.dialErr = err
+		t.mu.Unlock()
+		return
+	}
+	defer remote.Close()
+
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(remote, local)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(local, remote)
+		done <- struct{}{}
+	}()
+	// Either side closing ends the connection
+	<-done
+}
+
+// wrap adds the last failure of the tunnel to reach the database, if any,
+// to an error of the driver or a client tool, which only saw its connection
+// close. It returns err as it is for a nil tunnel.
+func (t *sshTunnel) wrap(err error) error {
+	if t == nil || err == nil {
+		return err
+	}
+	t.mu.Lock()
+	dialErr := t.dialErr
+	t.mu.Unlock()
+	if dialErr == nil {
+		return err
+	}
+	return fmt.Errorf("%w (SSH tunnel to %s failed: %w)", err, t.address, dialErr)
+}
+
+// Close stops forwarding and disconnects from the bastion, ending the
+// connections still open
+func (t *sshTunnel) Close() error {
+	t.closing.Store(true)
+	t.listener.Close()
+	err := t.client.Close()
+	t.wg.Wait()
+	return err
+}
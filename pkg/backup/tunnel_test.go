@@ -0,0 +1,452 @@
+package backup
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/yeboahd24/dbBackupUitility/pkg/config"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// sshServer is a bastion forwarding TCP connections for one user key
+type sshServer struct {
+	address string
+	hostKey ssh.Signer
+}
+
+func newSigner(t *testing.T) (ssh.Signer, ed25519.PrivateKey) {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer, key
+}
+
+// startSSHServer starts a bastion accepting the public key of user until
+// the test ends
+func startSSHServer(t *testing.T, user ssh.PublicKey) *sshServer {
+	t.Helper()
+	hostKey, _ := newSigner(t)
+	serverConfig := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if conn.User() == "backup" && string(key.Marshal()) == string(user.Marshal()) {
+				return nil, nil
+			}
+			return nil, errors.New("unknown key")
+		},
+	}
+	serverConfig.AddHostKey(hostKey)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveSSH(conn, serverConfig)
+		}
+	}()
+	return &sshServer{address: ln.Addr().String(), hostKey: hostKey}
+}
+
+// serveSSH forwards the direct-tcpip channels of a connection, refusing
+// those to addresses that cannot be reached as sshd does
+func serveSSH(conn net.Conn, serverConfig *ssh.ServerConfig) {
+	sshConn, chans, reqs, err := ssh.NewServerConn(conn, serverConfig)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	defer sshConn.Close()
+	go ssh.DiscardRequests(reqs)
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "direct-tcpip" {
+			newChannel.Reject(ssh.UnknownChannelType, "only direct-tcpip is supported")
+			continue
+		}
+		var target struct {
+			Host       string
+			Port       uint32
+			OriginHost string
+			OriginPort uint32
+		}
+		if err := ssh.Unmarshal(newChannel.ExtraData(), &target); err != nil {
+			newChannel.Reject(ssh.ConnectionFailed, err.Error())
+			continue
+		}
+		remote, err := net.Dial("tcp", net.JoinHostPort(target.Host, strconv.Itoa(int(target.Port))))
+		if err != nil {
+			newChannel.Reject(ssh.ConnectionFailed, "connect failed: connection refused")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			remote.Close()
+			continue
+		}
+		go ssh.DiscardRequests(requests)
+		go func() {
+			defer channel.Close()
+			defer remote.Close()
+			go io.Copy(remote, channel)
+			io.Copy(channel, remote)
+		}()
+	}
+}
+
+// startEchoServer starts a server echoing every line it reads, standing in
+// for the database
+func startEchoServer(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// tunnelConfig writes the user key and a known_hosts file trusting the
+// bastion, and returns the tunnel settings using them
+func tunnelConfig(t *testing.T, server *sshServer, key ed25519.PrivateKey) *config.SSHTunnelConfig {
+	t.Helper()
+	dir := t.TempDir()
+	block, err := ssh.MarshalPrivateKey(key, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(dir, "id_ed25519")
+	writeTestFile(t, keyFile, string(pem.EncodeToMemory(block)))
+	knownHosts := filepath.Join(dir, "known_hosts")
+	writeTestFile(t, knownHosts, knownhosts.Line([]string{knownhosts.Normalize(server.address)}, server.hostKey.PublicKey())+"\n")
+
+	host, port, _ := net.SplitHostPort(server.address)
+	portNum, _ := strconv.Atoi(port)
+	return &config.SSHTunnelConfig{Host: host, Port: portNum, User: "backup", Key: keyFile, KnownHosts: knownHosts}
+}
+
+func writeTestFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSSHTunnel(t *testing.T) {
+	signer, key := newSigner(t)
+	server := startSSHServer(t, signer.PublicKey())
+	cfg := tunnelConfig(t, server, key)
+	database := startEchoServer(t)
+
+	tunnel, err := openTunnel(t.Context(), cfg, "tcp", database)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tunnel.Close()
+
+	// The driver's address is ignored, so that TLS can verify it
+	for range 2 {
+		conn, err := tunnel.Dial("tcp", "db.internal:5432")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := conn.Write([]byte("SELECT 1\n")); err != nil {
+			t.Fatal(err)
+		}
+		line, err := bufio.NewReader(conn).ReadString('\n')
+		if err != nil || line != "SELECT 1\n" {
+			t.Errorf("read %q, %v through the tunnel", line, err)
+		}
+		conn.Close()
+	}
+	if err := tunnel.wrap(errors.New("query failed")); err.Error() != "query failed" {
+		t.Errorf("wrap of a working tunnel returned %v", err)
+	}
+
+	if err := tunnel.Close(); err != nil {
+		t.Errorf("Close returned %v", err)
+	}
+	if _, err := tunnel.Dial("tcp", "db.internal:5432"); err == nil {
+		t.Error("Dial succeeded after Close")
+	}
+}
+
+func TestSSHTunnelDialError(t *testing.T) {
+	signer, key := newSigner(t)
+	server := startSSHServer(t, signer.PublicKey())
+	cfg := tunnelConfig(t, server, key)
+	// A port nothing listens on
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	database := ln.Addr().String()
+	ln.Close()
+
+	tunnel, err := openTunnel(t.Context(), cfg, "tcp", database)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tunnel.Close()
+
+	// The local connection is closed, and the bastion's error is kept
+	conn, err := tunnel.Dial("tcp", "db.internal:5432")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("read from a failed tunnel returned %v, want EOF", err)
+	}
+	conn.Close()
+
+	cause := errors.New("EOF")
+	err = tunnel.wrap(cause)
+	if !errors.Is(err, cause) || !strings.Contains(err.Error(), "SSH tunnel to "+database+" failed") ||
+		!strings.Contains(err.Error(), "connection refused") {
+		t.Errorf("wrap returned %v, want the driver's error and the bastion's", err)
+	}
+	var openErr *ssh.OpenChannelError
+	if !errors.As(err, &openErr) || openErr.Reason != ssh.ConnectionFailed {
+		t.Errorf("wrap returned %v, want the bastion's error to be kept", err)
+	}
+
+	var nilTunnel *sshTunnel
+	if err := nilTunnel.wrap(cause); err != cause {
+		t.Errorf("wrap without a tunnel returned %v", err)
+	}
+}
+
+func TestSSHTunnelHostKey(t *testing.T) {
+	signer, key := newSigner(t)
+	server := startSSHServer(t, signer.PublicKey())
+	other, _ := newSigner(t)
+
+	tests := []struct {
+		name       string
+		knownHosts string
+		wantErr    string
+	}{
+		{"unknown host", "", "knownhosts: key is unknown"},
+		{"changed key", knownhosts.Line([]string{knownhosts.Normalize(server.address)}, other.PublicKey()) + "\n", "knownhosts: key mismatch"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := tunnelConfig(t, server, key)
+			writeTestFile(t, cfg.KnownHosts, tt.knownHosts)
+			tunnel, err := openTunnel(t.Context(), cfg, "tcp", "127.0.0.1:1")
+			if err == nil {
+				tunnel.Close()
+			}
+			if err == nil || !strings.Contains(err.Error(), "SSH handshake with "+server.address+" failed") ||
+				!strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("openTunnel returned %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	// A key the bastion does not accept
+	_, strangerKey := newSigner(t)
+	cfg := tunnelConfig(t, server, strangerKey)
+	if _, err := openTunnel(t.Context(), cfg, "tcp", "127.0.0.1:1"); err == nil || !strings.Contains(err.Error(), "unable to authenticate") {
+		t.Errorf("openTunnel with an unknown key returned %v", err)
+	}
+}
+
+func TestSSHClientConfig(t *testing.T) {
+	dir := t.TempDir()
+	_, key := newSigner(t)
+	knownHosts := filepath.Join(dir, "known_hosts")
+	writeTestFile(t, knownHosts, "")
+
+	block, err := ssh.MarshalPrivateKey(key, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	plainKey := filepath.Join(dir, "plain")
+	writeTestFile(t, plainKey, string(pem.EncodeToMemory(block)))
+	block, err = ssh.MarshalPrivateKeyWithPassphrase(key, "", []byte("open sesame"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	encryptedKey := filepath.Join(dir, "encrypted")
+	writeTestFile(t, encryptedKey, string(pem.EncodeToMemory(block)))
+	garbage := filepath.Join(dir, "garbage")
+	writeTestFile(t, garbage, "not a key")
+
+	t.Setenv("HOME", t.TempDir())
+
+	tests := []struct {
+		name    string
+		cfg     config.SSHTunnelConfig
+		agent   string // SSH_AUTH_SOCK
+		wantErr string
+	}{
+		{name: "key", cfg: config.SSHTunnelConfig{Key: plainKey, KnownHosts: knownHosts}},
+		{name: "encrypted key", cfg: config.SSHTunnelConfig{Key: encryptedKey, KeyPassphrase: "open sesame", KnownHosts: knownHosts}},
+		{name: "encrypted key without passphrase", cfg: config.SSHTunnelConfig{Key: encryptedKey, KnownHosts: knownHosts}, wantErr: "SSH key " + encryptedKey + " is encrypted, set key_passphrase"},
+		{name: "wrong passphrase", cfg: config.SSHTunnelConfig{Key: encryptedKey, KeyPassphrase: "wrong", KnownHosts: knownHosts}, wantErr: "failed to parse SSH key " + encryptedKey},
+		{name: "invalid key", cfg: config.SSHTunnelConfig{Key: garbage, KnownHosts: knownHosts}, wantErr: "failed to parse SSH key " + garbage},
+		{name: "missing key", cfg: config.SSHTunnelConfig{Key: filepath.Join(dir, "missing"), KnownHosts: knownHosts}, wantErr: "failed to read SSH key"},
+		{name: "no key and no agent", cfg: config.SSHTunnelConfig{KnownHosts: knownHosts}, wantErr: "no SSH key configured and no ssh-agent running"},
+		{name: "agent not running", cfg: config.SSHTunnelConfig{KnownHosts: knownHosts}, agent: filepath.Join(dir, "agent.sock"), wantErr: "failed to connect to ssh-agent"},
+		// ~/.ssh/known_hosts does not exist
+		{name: "default known_hosts", cfg: config.SSHTunnelConfig{Key: plainKey}, wantErr: "failed to read known_hosts"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("SSH_AUTH_SOCK", tt.agent)
+			clientConfig, cleanup, err := sshClientConfig(&tt.cfg)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("sshClientConfig returned %v, want an error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer cleanup()
+			// Host keys are checked, never accepted blindly
+			if clientConfig.HostKeyCallback == nil || len(clientConfig.Auth) != 1 {
+				t.Errorf("sshClientConfig returned %+v", clientConfig)
+			}
+		})
+	}
+}
+
+func TestSSHTunnelAgent(t *testing.T) {
+	signer, key := newSigner(t)
+	server := startSSHServer(t, signer.PublicKey())
+	cfg := tunnelConfig(t, server, key)
+	cfg.Key = ""
+
+	// An ssh-agent holding the key
+	keyring := agent.NewKeyring()
+	if err := keyring.Add(agent.AddedKey{PrivateKey: key}); err != nil {
+		t.Fatal(err)
+	}
+	socket := filepath.Join(t.TempDir(), "agent.sock")
+	ln, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Skip(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				agent.ServeAgent(keyring, conn)
+			}()
+		}
+	}()
+	t.Setenv("SSH_AUTH_SOCK", socket)
+
+	tunnel, err := openTunnel(t.Context(), cfg, "tcp", startEchoServer(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tunnel.Close()
+}
+
+func TestPostgresEndpoint(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	// The local end of a tunnel
+	tunnel := &sshTunnel{listener: ln}
+	local := tunnel.port()
+
+	tests := []struct {
+		name     string
+		cfg      config.DatabaseConfig
+		tunnel   *sshTunnel
+		host     string
+		port     int
+		hostaddr string
+	}{
+		{name: "direct", cfg: config.DatabaseConfig{Host: "db.internal", Port: 5432}, host: "db.internal", port: 5432},
+		{name: "socket", cfg: config.DatabaseConfig{Socket: "/run/postgresql/.s.PGSQL.5433"}, host: "/run/postgresql", port: 5433},
+		// The host is kept for TLS to verify, the address is the tunnel's
+		{name: "tunnel", cfg: config.DatabaseConfig{Host: "db.internal", Port: 5432}, tunnel: tunnel, host: "db.internal", port: local, hostaddr: "127.0.0.1"},
+		{name: "socket through tunnel", cfg: config.DatabaseConfig{Socket: "/run/postgresql"}, tunnel: tunnel, host: "localhost", port: local, hostaddr: "127.0.0.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &PostgresBackup{config: tt.cfg, tunnel: tt.tunnel}
+			host, port, hostaddr := p.endpoint()
+			if host != tt.host || port != tt.port || hostaddr != tt.hostaddr {
+				t.Errorf("endpoint() = %s, %d, %q, want %s, %d, %q", host, port, hostaddr, tt.host, tt.port, tt.hostaddr)
+			}
+			if args := p.toolArgs(); args[1] != tt.host || args[3] != strconv.Itoa(tt.port) {
+				t.Errorf("toolArgs() = %v", args)
+			}
+
+			cmd := exec.Command("pg_dump")
+			p.toolEnv(cmd)
+			hasHostaddr := slices.Contains(cmd.Env, "PGHOSTADDR=127.0.0.1")
+			if hasHostaddr != (tt.hostaddr != "") {
+				t.Errorf("toolEnv set PGHOSTADDR: %v, want %v", hasHostaddr, tt.hostaddr != "")
+			}
+		})
+	}
+}
+
+func TestDSNQuote(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"secret", `'secret'`},
+		{"pass word", `'pass word'`},
+		{"it's", `'it\'s'`},
+		{`back\slash`, `'back\\slash'`},
+		{`\'`, `'\\\''`},
+		{"", `''`},
+	}
+	for _, tt := range tests {
+		if got := dsnQuote(tt.in); got != tt.want {
+			t.Errorf("dsnQuote(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
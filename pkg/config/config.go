@@ -1,43 +1,80 @@
 package config
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"math"
+	"net"
 	"os"
 	"path/filepath"
-
-	"gopkg.in/yaml.v3"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 )
 
-// ConfigSearchPaths defines the locations where config.yml will be searched
+// ConfigSearchPaths defines the locations where config.yml will be searched,
+// from the highest precedence to the lowest. Every file found is loaded, and
+// values from files earlier in the list override those of later ones.
 var ConfigSearchPaths = []string{
-	".",               // Current directory
-	"$HOME/.dbbackup", // User's home directory
-	"/etc/dbbackup",   // System-wide configuration
-	filepath.Join(os.Getenv("XDG_CONFIG_HOME"), "dbbackup"), // XDG config directory
+	".",                         // Current directory (project)
+	"$HOME/.dbbackup",           // User's home directory
+	"$XDG_CONFIG_HOME/dbbackup", // XDG config directory
+	"/etc/dbbackup",             // System-wide configuration
 }
 
-// findConfigFile searches for config.yml in predefined locations
-func findConfigFile() (string, error) {
-	// First, check if XDG_CONFIG_HOME is set
-	if os.Getenv("XDG_CONFIG_HOME") == "" {
-		os.Setenv("XDG_CONFIG_HOME", filepath.Join(os.Getenv("HOME"), ".config"))
-	}
+// expandPath expands environment variables in a search path, with
+// XDG_CONFIG_HOME defaulting to ~/.config
+func expandPath(path string) string {
+	return os.Expand(path, func(name string) string {
+		value := os.Getenv(name)
+		if name == "XDG_CONFIG_HOME" && value == "" {
+			value = filepath.Join(os.Getenv("HOME"), ".config")
+		}
+		return value
+	})
+}
 
-	// Expand all paths
-	for i, path := range ConfigSearchPaths {
-		expanded := os.ExpandEnv(path)
-		ConfigSearchPaths[i] = expanded
+// ConfigFileLocations returns the config.yml paths searched, highest
+// precedence first
+func ConfigFileLocations() []string {
+	var paths []string
+	for _, path := range ConfigSearchPaths {
+		paths = append(paths, filepath.Join(expandPath(path), "config.yml"))
 	}
+	return paths
+}
 
-	// Search for config.yml in all locations
-	for _, path := range ConfigSearchPaths {
-		configPath := filepath.Join(path, "config.yml")
-		if _, err := os.Stat(configPath); err == nil {
-			return configPath, nil
+// findConfigFiles returns the config.yml files found in the search paths,
+// highest precedence first
+func findConfigFiles() []string {
+	var files, seen []string
+	for _, configPath := range ConfigFileLocations() {
+		if _, err := os.Stat(configPath); err != nil {
+			continue
+		}
+		abs, err := filepath.Abs(configPath)
+		if err != nil || slices.Contains(seen, abs) {
+			continue
 		}
+		seen = append(seen, abs)
+		files = append(files, configPath)
 	}
+	return files
+}
 
-	return "", fmt.Errorf("config.yml not found in any of the following locations: %v", ConfigSearchPaths)
+// findConfigFile searches for the config.yml with the highest precedence
+func findConfigFile() (string, error) {
+	files := findConfigFiles()
+	if len(files) == 0 {
+		var paths []string
+		for _, path := range ConfigSearchPaths {
+			paths = append(paths, expandPath(path))
+		}
+		return "", fmt.Errorf("config.yml not found in any of the following locations: %v", paths)
+	}
+	return files[0], nil
 }
 
 type DatabaseConfig struct {
@@ -47,16 +84,98 @@ type DatabaseConfig struct {
 	Username string `yaml:"username"`
 	Password string `yaml:"password"`
 	Database string `yaml:"database"`
+	// Unix socket of the server, used instead of host and port: the socket
+	// file or, for PostgreSQL, the directory holding it
+	Socket string `yaml:"socket"`
+	// SSH bastion the connections go through, optional
+	SSHTunnel *SSHTunnelConfig `yaml:"ssh_tunnel"`
+
+	// Standbys of the database, which is their primary. With dump_from
+	// replica, backups are dumped from the first replica in recovery and no
+	// further behind than max_replication_lag, e.g. 5m (unset accepts any
+	// known lag); without one, replica_fallback primary (default) dumps from
+	// the primary and fail stops the backup. Restores go to the primary.
+	Replicas          []ReplicaConfig `yaml:"replicas"`
+	DumpFrom          string          `yaml:"dump_from"` // primary (default) or replica
+	MaxReplicationLag string          `yaml:"max_replication_lag"`
+	ReplicaFallback   string          `yaml:"replica_fallback"` // primary (default) or fail
+
+	// TLS for the connections of dbbackup and of the client tools. SSLMode
+	// is disable (default), require, verify-ca or verify-full; the files are
+	// PEM encoded.
+	SSLMode string `yaml:"ssl_mode"`
+	SSLCA   string `yaml:"ssl_ca"`   // CA certificate verifying the server
+	SSLCert string `yaml:"ssl_cert"` // client certificate
+	SSLKey  string `yaml:"ssl_key"`  // client certificate key
+
+	// pg_dump compression level (0-9); unset uses the pg_dump default
+	DumpCompression *int `yaml:"dump_compression"`
+
+	// CPU and I/O priority of the dump and restore tools, so backups do not
+	// compete with production load
+	Nice        int    `yaml:"nice"`         // niceness, 1 (high) to 19 (lowest)
+	IONiceClass string `yaml:"ionice_class"` // idle or best-effort (Linux only)
+	IONiceLevel *int   `yaml:"ionice_level"` // 0 (high) to 7 (lowest), for best-effort
+
+	// Client tools to run instead of the newest installed version, by name,
+	// e.g. pg_dump: /usr/lib/postgresql/16/bin/pg_dump
+	ToolPaths map[string]string `yaml:"tool_paths"`
+	// Directories, or glob patterns, searched for installed client versions
+	// in addition to PATH and the usual install locations
+	ToolDirs []string `yaml:"tool_dirs"`
+}
+
+// ReplicaConfig is a standby of the database, reached with the settings of
+// the primary other than its address
+type ReplicaConfig struct {
+	Host   string `yaml:"host"`
+	Port   int    `yaml:"port"` // default: the primary's port
+	Socket string `yaml:"socket"`
+}
+
+// SSHTunnelConfig is an SSH bastion through which a local port is forwarded
+// to the database host and port, or to its socket, for the connections of
+// dbbackup and of the client tools
+type SSHTunnelConfig struct {
+	Host          string `yaml:"host"` // bastion host
+	Port          int    `yaml:"port"` // default 22
+	User          string `yaml:"user"`
+	Key           string `yaml:"key"`            // private key file; unset uses ssh-agent
+	KeyPassphrase string `yaml:"key_passphrase"` // of an encrypted key
+	KnownHosts    string `yaml:"known_hosts"`    // default ~/.ssh/known_hosts
 }
 
 type StorageConfig struct {
-	Type      string `yaml:"type"` // local, s3, gcs, azure
-	Enabled   bool   `yaml:"enabled"`
-	Path      string `yaml:"path"` // for local storage
+	Name      string `yaml:"name"`      // defaults to the storage type
+	Type      string `yaml:"type"`      // local, s3, gcs, azure
+	Disabled  bool   `yaml:"disabled"`  // keep the settings but leave the storage out
+	Mode      string `yaml:"mode"`      // empty for plain objects, or repository for deduplicated chunks
+	Path      string `yaml:"path"`      // for local storage
+	Overwrite bool   `yaml:"overwrite"` // for local storage, replace existing backups
 	Bucket    string `yaml:"bucket"`
 	AccessKey string `yaml:"access_key"`
 	SecretKey string `yaml:"secret_key"`
 	Region    string `yaml:"region"`
+
+	// Split backups into volumes of at most this size, e.g. 5GiB
+	VolumeSize string `yaml:"volume_size"`
+
+	// Limit uploads to this storage, e.g. 10MB/s
+	UploadRate string `yaml:"upload_rate"`
+
+	// S3 object settings
+	StorageClass         string            `yaml:"storage_class"`          // STANDARD, STANDARD_IA, GLACIER_IR, ...
+	ServerSideEncryption string            `yaml:"server_side_encryption"` // AES256, aws:kms
+	KMSKeyID             string            `yaml:"kms_key_id"`
+	Tags                 map[string]string `yaml:"tags"`
+	ObjectLock           ObjectLockConfig  `yaml:"object_lock"`
+}
+
+// ObjectLockConfig controls the S3 Object Lock retention applied to new backups
+type ObjectLockConfig struct {
+	Mode        string `yaml:"mode"` // GOVERNANCE, COMPLIANCE
+	RetainDays  int    `yaml:"retain_days"`
+	RetainUntil string `yaml:"retain_until"` // fixed date (2006-01-02), overrides retain_days
 }
 
 type NotificationConfig struct {
@@ -65,31 +184,265 @@ type NotificationConfig struct {
 }
 
 type Config struct {
+	// Format of the file, see CurrentVersion. Files of older versions are
+	// upgraded when loaded.
+	Version int `yaml:"version"`
+
+	// Other files loaded before this one, whose values this file overrides
+	Include []string `yaml:"include"`
+
 	Database     DatabaseConfig     `yaml:"database"`
-	Storage      StorageConfig      `yaml:"storage"`
 	Notification NotificationConfig `yaml:"notification"`
+
+	// Destinations every backup is copied to, and how many of them must
+	// succeed: all (default), any or majority
+	Storages      []StorageConfig `yaml:"storages"`
+	StoragePolicy string          `yaml:"storage_policy"`
+
+	// Object key layout of new backups, e.g. {env}/{database}/{yyyy}/{mm}/{id}.{ext}.
+	// Labels provide additional placeholders such as {env}.
+	NamingTemplate string            `yaml:"naming_template"`
+	Labels         map[string]string `yaml:"labels"`
+
+	// Colder storages backups are moved to from the main storage as they age
+	Tiering []TierConfig `yaml:"tiering"`
+
+	Retry RetryConfig `yaml:"retry"`
+	Spool SpoolConfig `yaml:"spool"`
+
+	// Limit all uploads of a run together, e.g. 10MB/s
+	UploadRate string `yaml:"upload_rate"`
+
+	Preflight PreflightConfig `yaml:"preflight"`
+
+	// How the sensitive values of this file are encrypted, maintained by
+	// 'config encrypt'
+	Encryption EncryptionConfig `yaml:"encryption"`
 }
 
-// LoadConfig reads and parses the configuration file
-func LoadConfig(path string) (*Config, error) {
-	// If no path is provided, search for config.yml
-	if path == "" {
-		var err error
-		path, err = findConfigFile()
-		if err != nil {
-			return nil, err
+// PreflightConfig controls the checks run before a backup or restore starts
+type PreflightConfig struct {
+	Disabled bool `yaml:"disabled"`
+
+	// Expected backup size relative to the database size, used for the free
+	// space checks; defaults to 1
+	SizeFactor float64 `yaml:"size_factor"`
+}
+
+// SpoolConfig enables writing backups to a local directory first and
+// uploading them from there, so that a storage outage does not lose the dump
+type SpoolConfig struct {
+	Path      string `yaml:"path"`
+	MaxSize   string `yaml:"max_size"`   // e.g. 50GiB, backups that do not fit fail
+	AlertSize string `yaml:"alert_size"` // e.g. 20GiB, notify once the spool grows beyond this
+}
+
+// RetryConfig controls retries of database connections, storage operations
+// and notifications. Unset fields use the defaults.
+type RetryConfig struct {
+	MaxAttempts int     `yaml:"max_attempts"` // total attempts, 1 disables retries
+	BaseDelay   string  `yaml:"base_delay"`   // e.g. 1s, doubled for each retry
+	MaxDelay    string  `yaml:"max_delay"`    // e.g. 30s
+	Jitter      float64 `yaml:"jitter"`       // fraction of each delay that is randomized
+}
+
+// TierConfig moves backups older than After to Storage
+type TierConfig struct {
+	After   string        `yaml:"after"` // e.g. 7d
+	Storage StorageConfig `yaml:"storage"`
+}
+
+// StorageOverrides holds per-run storage settings given on the command line
+type StorageOverrides struct {
+	Overwrite    bool              `yaml:"overwrite" json:"overwrite,omitempty"`
+	StorageClass string            `yaml:"storage_class" json:"storage_class,omitempty"`
+	Tags         map[string]string `yaml:"tags" json:"tags,omitempty"`
+	RetainUntil  string            `yaml:"retain_until" json:"retain_until,omitempty"`
+}
+
+// Apply applies the overrides on top of the storage config. Tags are merged.
+func (o StorageOverrides) Apply(s *StorageConfig) {
+	if o.Overwrite {
+		s.Overwrite = true
+	}
+	if o.StorageClass != "" {
+		s.StorageClass = o.StorageClass
+	}
+	if o.RetainUntil != "" {
+		s.ObjectLock.RetainUntil = o.RetainUntil
+	}
+	if len(o.Tags) == 0 {
+		return
+	}
+	merged := make(map[string]string, len(s.Tags)+len(o.Tags))
+	for k, v := range s.Tags {
+		merged[k] = v
+	}
+	for k, v := range o.Tags {
+		merged[k] = v
+	}
+	s.Tags = merged
+}
+
+// StorageName returns the configured name of the storage, or its type if unnamed
+func (s StorageConfig) StorageName() string {
+	if s.Name != "" {
+		return s.Name
+	}
+	return s.Type
+}
+
+// Replica returns the settings of the database for connecting to its
+// replica i
+func (d DatabaseConfig) Replica(i int) DatabaseConfig {
+	replica := d.Replicas[i]
+	d.Host, d.Socket = replica.Host, replica.Socket
+	if replica.Port != 0 {
+		d.Port = replica.Port
+	}
+	d.Replicas = nil
+	return d
+}
+
+// Address returns where the database is reached, its socket or its host and
+// port
+func (d DatabaseConfig) Address() string {
+	if d.Socket != "" {
+		return d.Socket
+	}
+	return net.JoinHostPort(d.Host, strconv.Itoa(d.Port))
+}
+
+// StorageTargets returns every storage a backup should be written to, the
+// storages that are not disabled
+func (c *Config) StorageTargets() []StorageConfig {
+	var targets []StorageConfig
+	for _, s := range c.Storages {
+		if !s.Disabled {
+			targets = append(targets, s)
+		}
+	}
+	return targets
+}
+
+// AllStorages returns the storage targets followed by the tiering storages
+func (c *Config) AllStorages() []StorageConfig {
+	storages := c.StorageTargets()
+	for _, tier := range c.Tiering {
+		storages = append(storages, tier.Storage)
+	}
+	return storages
+}
+
+// FindStorage looks up a storage target or tiering storage by name
+func (c *Config) FindStorage(name string) (StorageConfig, error) {
+	for _, s := range c.AllStorages() {
+		if s.StorageName() == name {
+			return s, nil
 		}
 	}
+	return StorageConfig{}, fmt.Errorf("storage %q not found in config", name)
+}
 
-	data, err := os.ReadFile(path)
+// ConfigPath returns path, or the config.yml found in the search paths if
+// path is empty
+func ConfigPath(path string) (string, error) {
+	if path != "" {
+		return path, nil
+	}
+	return findConfigFile()
+}
+
+// LoadConfig loads the configuration layers, with path replacing the
+// project file when given
+func LoadConfig(path string) (*Config, []string, error) {
+	return Load(LoadOptions{Path: path})
+}
+
+// Load loads and merges the configuration layers and resolves the secrets
+// they refer to. It also returns the warnings found while loading, for the
+// caller to show.
+func Load(opts LoadOptions) (*Config, []string, error) {
+	layers, err := LoadLayers(opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	cfg, err := layers.Decode()
+	if err != nil {
+		return nil, layers.Warnings, err
+	}
+	if err := ResolveSecrets(context.Background(), cfg); err != nil {
+		return nil, layers.Warnings, fmt.Errorf("failed to resolve secrets: %w", err)
+	}
+	return cfg, layers.Warnings, nil
+}
+
+// ParseDuration parses a duration string, additionally accepting a "d" suffix
+// for whole days (e.g. "30d")
+func ParseDuration(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read config file: %w", err)
+		return 0, fmt.Errorf("invalid duration %q", s)
 	}
+	return d, nil
+}
 
-	var cfg Config
-	if err := yaml.Unmarshal(data, &cfg); err != nil {
-		return nil, fmt.Errorf("failed to parse config file: %w", err)
+// ParseRate parses a transfer rate in bytes per second such as "10MB/s". The
+// "/s" suffix is optional.
+func ParseRate(s string) (int64, error) {
+	n, err := ParseSize(strings.TrimSuffix(strings.TrimSpace(s), "/s"))
+	if errors.Is(err, errBelowOneByte) {
+		// A zero rate would never let a byte through
+		return 0, fmt.Errorf("invalid rate %q: less than 1 byte per second", s)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("invalid rate %q", s)
 	}
+	return n, nil
+}
+
+// errBelowOneByte is returned by ParseSize for fractions of a byte, such as
+// "0.4B", which would round down to zero
+var errBelowOneByte = errors.New("less than 1 byte")
 
-	return &cfg, nil
+var sizeUnits = map[string]int64{
+	"":    1,
+	"b":   1,
+	"kb":  1000,
+	"kib": 1 << 10,
+	"mb":  1000 * 1000,
+	"mib": 1 << 20,
+	"gb":  1000 * 1000 * 1000,
+	"gib": 1 << 30,
+	"tb":  1000 * 1000 * 1000 * 1000,
+	"tib": 1 << 40,
+}
+
+// ParseSize parses a byte size such as "500MB" or "5GiB"
+func ParseSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	i := strings.IndexFunc(s, func(r rune) bool { return (r < '0' || r > '9') && r != '.' })
+	if i < 0 {
+		i = len(s)
+	}
+	n, err := strconv.ParseFloat(s[:i], 64)
+	unit, ok := sizeUnits[strings.ToLower(strings.TrimSpace(s[i:]))]
+	if err != nil || !ok || n <= 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	size := n * float64(unit)
+	if size < 1 {
+		return 0, fmt.Errorf("invalid size %q: %w", s, errBelowOneByte)
+	}
+	if size >= math.MaxInt64 {
+		return 0, fmt.Errorf("invalid size %q: too large", s)
+	}
+	return int64(size), nil
 }
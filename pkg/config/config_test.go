@@ -0,0 +1,105 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int64
+		wantErr string
+	}{
+		{in: "1", want: 1},
+		{in: "512B", want: 512},
+		{in: "500MB", want: 500_000_000},
+		{in: "5GiB", want: 5 << 30},
+		{in: "1.5kib", want: 1536},
+		{in: " 2 TB ", want: 2_000_000_000_000},
+		{in: "0.5KB", want: 500},
+		{in: "0.4B", wantErr: "less than 1 byte"},
+		{in: "0.0001kb", wantErr: "less than 1 byte"},
+		{in: "0", wantErr: "invalid size"},
+		{in: "-1MB", wantErr: "invalid size"},
+		{in: "", wantErr: "invalid size"},
+		{in: "MB", wantErr: "invalid size"},
+		{in: "5XB", wantErr: "invalid size"},
+		{in: "1.2.3MB", wantErr: "invalid size"},
+		{in: "9999999TiB", wantErr: "too large"},
+	}
+	for _, tt := range tests {
+		got, err := ParseSize(tt.in)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ParseSize(%q) = %d, %v, want an error containing %q", tt.in, got, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("ParseSize(%q) = %d, %v, want %d", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int64
+		wantErr string
+	}{
+		{in: "10MB/s", want: 10_000_000},
+		{in: "10MB", want: 10_000_000},
+		{in: "1MiB/s", want: 1 << 20},
+		{in: " 100kb/s ", want: 100_000},
+		{in: "1B/s", want: 1},
+		{in: "0.5B/s", wantErr: "less than 1 byte per second"},
+		{in: "0/s", wantErr: "invalid rate"},
+		{in: "fast", wantErr: "invalid rate"},
+		{in: "10MB/min", wantErr: "invalid rate"},
+	}
+	for _, tt := range tests {
+		got, err := ParseRate(tt.in)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ParseRate(%q) = %d, %v, want an error containing %q", tt.in, got, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("ParseRate(%q) = %d, %v, want %d", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    time.Duration
+		wantErr bool
+	}{
+		{in: "30d", want: 30 * 24 * time.Hour},
+		{in: "1d", want: 24 * time.Hour},
+		{in: "36h", want: 36 * time.Hour},
+		{in: "1h30m", want: 90 * time.Minute},
+		{in: "500ms", want: 500 * time.Millisecond},
+		{in: "1.5d", wantErr: true},
+		{in: "d", wantErr: true},
+		{in: "30", wantErr: true},
+		{in: "", wantErr: true},
+		{in: "a week", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseDuration(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseDuration(%q) = %v, want an error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("ParseDuration(%q) = %v, %v, want %v", tt.in, got, err, tt.want)
+		}
+	}
+}
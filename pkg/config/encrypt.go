@@ -0,0 +1,329 @@
+package config
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"filippo.io/age"
+	"gopkg.in/yaml.v3"
+)
+
+// Values of sensitive keys can be encrypted in place, leaving the rest of the
+// file readable, with age recipients or a passphrase:
+//
+//	password: ENC[age,YWdlLWVuY3J5cHRpb24ub3JnL3YxCi0+IFgyNTUxOSB...]
+//
+// The keys used are recorded in the encryption block so that values added
+// later can be encrypted the same way. LoadConfig decrypts the values with
+// the identities in DBBACKUP_AGE_KEY, DBBACKUP_AGE_KEY_FILE or the default
+// key file, or the passphrase in DBBACKUP_PASSPHRASE.
+
+const (
+	encryptedPrefix = "ENC[age,"
+	encryptedSuffix = "]"
+)
+
+// SensitiveKeys are the keys whose values are encrypted
+var SensitiveKeys = []string{"password", "key_passphrase", "access_key", "secret_key", "slack_webhook"}
+
+// EncryptionConfig records how the values of a configuration file are
+// encrypted
+type EncryptionConfig struct {
+	AgeRecipients []string `yaml:"age_recipients,omitempty"`
+	Passphrase    bool     `yaml:"passphrase,omitempty"`
+}
+
+// DefaultKeyFile returns the age identity file read when neither
+// DBBACKUP_AGE_KEY nor DBBACKUP_AGE_KEY_FILE is set
+func DefaultKeyFile() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "dbbackup", "age.key")
+}
+
+// IsEncrypted reports whether s is an encrypted value
+func IsEncrypted(s string) bool {
+	return strings.HasPrefix(s, encryptedPrefix) && strings.HasSuffix(s, encryptedSuffix)
+}
+
+var identities = struct {
+	sync.Mutex
+	loaded bool
+	list   []age.Identity
+}{}
+
+// AddPassphrase adds a passphrase to try when decrypting values, e.g. one
+// the user was prompted for
+func AddPassphrase(passphrase string) error {
+	identity, err := age.NewScryptIdentity(passphrase)
+	if err != nil {
+		return err
+	}
+	identities.Lock()
+	defer identities.Unlock()
+	identities.list = append(identities.list, identity)
+	return nil
+}
+
+// loadIdentities returns the identities available to decrypt values
+func loadIdentities() ([]age.Identity, error) {
+	identities.Lock()
+	defer identities.Unlock()
+	if identities.loaded {
+		return identities.list, nil
+	}
+
+	var keys io.Reader
+	if key := os.Getenv("DBBACKUP_AGE_KEY"); key != "" {
+		keys = strings.NewReader(key)
+	} else if path := os.Getenv("DBBACKUP_AGE_KEY_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read age key file: %w", err)
+		}
+		keys = bytes.NewReader(data)
+	} else if data, err := os.ReadFile(DefaultKeyFile()); err == nil {
+		keys = bytes.NewReader(data)
+	}
+	if keys != nil {
+		parsed, err := age.ParseIdentities(keys)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse age identities: %w", err)
+		}
+		identities.list = append(identities.list, parsed...)
+	}
+	if passphrase := os.Getenv("DBBACKUP_PASSPHRASE"); passphrase != "" {
+		identity, err := age.NewScryptIdentity(passphrase)
+		if err != nil {
+			return nil, err
+		}
+		identities.list = append(identities.list, identity)
+	}
+	identities.loaded = true
+	return identities.list, nil
+}
+
+// decryptValue decrypts an encrypted value
+func decryptValue(s string) (string, error) {
+	ids, err := loadIdentities()
+	if err != nil {
+		return "", err
+	}
+	if len(ids) == 0 {
+		return "", fmt.Errorf("value is encrypted but no key is available, set DBBACKUP_AGE_KEY, DBBACKUP_AGE_KEY_FILE or DBBACKUP_PASSPHRASE")
+	}
+
+	ciphertext, err := base64.StdEncoding.DecodeString(strings.TrimSuffix(strings.TrimPrefix(s, encryptedPrefix), encryptedSuffix))
+	if err != nil {
+		return "", fmt.Errorf("malformed encrypted value: %w", err)
+	}
+	r, err := age.Decrypt(bytes.NewReader(ciphertext), ids...)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt value: %w", err)
+	}
+	plaintext, err := io.ReadAll(r)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt value: %w", err)
+	}
+	return string(plaintext), nil
+}
+
+// encryptValue encrypts s for the recipients
+func encryptValue(s string, recipients []age.Recipient) (string, error) {
+	var buf bytes.Buffer
+	w, err := age.Encrypt(&buf, recipients...)
+	if err != nil {
+		return "", fmt.Errorf("failed to encrypt value: %w", err)
+	}
+	if _, err := io.WriteString(w, s); err != nil {
+		return "", fmt.Errorf("failed to encrypt value: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return "", fmt.Errorf("failed to encrypt value: %w", err)
+	}
+	return encryptedPrefix + base64.StdEncoding.EncodeToString(buf.Bytes()) + encryptedSuffix, nil
+}
+
+// Recipients returns the age recipients for the encryption settings, using
+// passphrase when they call for one
+func (e EncryptionConfig) Recipients(passphrase string) ([]age.Recipient, error) {
+	if e.Passphrase {
+		if len(e.AgeRecipients) > 0 {
+			return nil, fmt.Errorf("a passphrase cannot be combined with age recipients")
+		}
+		if passphrase == "" {
+			return nil, fmt.Errorf("no passphrase given")
+		}
+		recipient, err := age.NewScryptRecipient(passphrase)
+		if err != nil {
+			return nil, err
+		}
+		return []age.Recipient{recipient}, nil
+	}
+
+	if len(e.AgeRecipients) == 0 {
+		return nil, fmt.Errorf("no age recipients or passphrase configured")
+	}
+	recipients := make([]age.Recipient, 0, len(e.AgeRecipients))
+	for _, s := range e.AgeRecipients {
+		recipient, err := age.ParseX25519Recipient(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid age recipient %q: %w", s, err)
+		}
+		recipients = append(recipients, recipient)
+	}
+	return recipients, nil
+}
+
+// ReadEncryption returns the encryption settings recorded in a configuration
+// file, or nil if it has none
+func ReadEncryption(data []byte) (*EncryptionConfig, error) {
+	var file struct {
+		Encryption *EncryptionConfig `yaml:"encryption"`
+	}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse config file: %w", err)
+	}
+	return file.Encryption, nil
+}
+
+// EncryptFile encrypts the plain values of sensitive keys in a configuration
+// file and records the settings in its encryption block. Values already
+// encrypted and secret references are left as they are. Comments and the
+// order of keys are kept.
+func EncryptFile(data []byte, enc EncryptionConfig, recipients []age.Recipient) ([]byte, error) {
+	doc, root, err := parseDocument(data)
+	if err != nil {
+		return nil, err
+	}
+
+	err = walkSensitive(root, func(value *yaml.Node) error {
+		if value.Value == "" || IsEncrypted(value.Value) || IsSecretRef(value.Value) {
+			return nil
+		}
+		encrypted, err := encryptValue(value.Value, recipients)
+		if err != nil {
+			return err
+		}
+		value.Value = encrypted
+		value.Style = 0
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var block yaml.Node
+	if err := block.Encode(enc); err != nil {
+		return nil, err
+	}
+	setKey(root, "encryption", &block)
+	return encodeDocument(doc)
+}
+
+// DecryptFile decrypts the encrypted values of a configuration file and
+// removes its encryption block
+func DecryptFile(data []byte) ([]byte, error) {
+	doc, root, err := parseDocument(data)
+	if err != nil {
+		return nil, err
+	}
+
+	err = walkSensitive(root, func(value *yaml.Node) error {
+		if !IsEncrypted(value.Value) {
+			return nil
+		}
+		plaintext, err := decryptValue(value.Value)
+		if err != nil {
+			return err
+		}
+		value.Value = plaintext
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	setKey(root, "encryption", nil)
+	return encodeDocument(doc)
+}
+
+func parseDocument(data []byte) (*yaml.Node, *yaml.Node, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse config file: %w", err)
+	}
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, nil, fmt.Errorf("config file is not a YAML mapping")
+	}
+	return &doc, doc.Content[0], nil
+}
+
+func encodeDocument(doc *yaml.Node) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(doc); err != nil {
+		return nil, fmt.Errorf("failed to encode config file: %w", err)
+	}
+	if err := enc.Close(); err != nil {
+		return nil, fmt.Errorf("failed to encode config file: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// walkSensitive calls fn with the scalar value of every sensitive key below
+// node and returns the errors of all calls
+func walkSensitive(node *yaml.Node, fn func(value *yaml.Node) error) error {
+	switch node.Kind {
+	case yaml.MappingNode:
+		var errs []error
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			if value.Kind == yaml.ScalarNode && slices.Contains(SensitiveKeys, key.Value) {
+				if err := fn(value); err != nil {
+					errs = append(errs, fmt.Errorf("line %d: %s: %w", key.Line, key.Value, err))
+				}
+				continue
+			}
+			errs = append(errs, walkSensitive(value, fn))
+		}
+		return errors.Join(errs...)
+	case yaml.SequenceNode:
+		var errs []error
+		for _, item := range node.Content {
+			errs = append(errs, walkSensitive(item, fn))
+		}
+		return errors.Join(errs...)
+	}
+	return nil
+}
+
+// setKey sets the value of a key of a mapping, appending the key if it is
+// missing, or removes the key when value is nil
+func setKey(mapping *yaml.Node, key string, value *yaml.Node) {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value != key {
+			continue
+		}
+		if value == nil {
+			mapping.Content = slices.Delete(mapping.Content, i, i+2)
+		} else {
+			mapping.Content[i+1] = value
+		}
+		return
+	}
+	if value != nil {
+		mapping.Content = append(mapping.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, value)
+	}
+}
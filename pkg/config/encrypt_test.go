@@ -0,0 +1,212 @@
+package config
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+)
+
+// ageKeys isolates the keys used to decrypt values: only the environment
+// set by the test is read, once the identities loaded earlier are dropped
+func ageKeys(t *testing.T, key, keyFile, passphrase string) {
+	t.Helper()
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("DBBACKUP_AGE_KEY", key)
+	t.Setenv("DBBACKUP_AGE_KEY_FILE", keyFile)
+	t.Setenv("DBBACKUP_PASSPHRASE", passphrase)
+	identities.Lock()
+	identities.loaded, identities.list = false, nil
+	identities.Unlock()
+	resetSecretCache(t)
+}
+
+func newIdentity(t *testing.T) *age.X25519Identity {
+	t.Helper()
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return identity
+}
+
+const plainConfig = `# Production database
+database:
+  type: postgres
+  host: db.internal
+  username: backup
+  password: s3cret
+storages:
+  - type: s3
+    bucket: backups
+    access_key: env:AWS_ACCESS_KEY
+    secret_key: aws-secret
+  - type: local
+    path: /backups
+notifications:
+  slack_webhook: https://hooks.slack.com/services/T0/B0/x
+`
+
+func TestEncryptFileIdentity(t *testing.T) {
+	identity := newIdentity(t)
+	keyFile := filepath.Join(t.TempDir(), "age.key")
+	writeFile(t, keyFile, "# created: today\n"+identity.String()+"\n")
+	ageKeys(t, "", keyFile, "")
+
+	enc := EncryptionConfig{AgeRecipients: []string{identity.Recipient().String()}}
+	recipients, err := enc.Recipients("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := EncryptFile([]byte(plainConfig), enc, recipients)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the plain values of sensitive keys change, and the keys are
+	// recorded after the rest of the file
+	plainLines := strings.Split(plainConfig, "\n")
+	lines := strings.Split(string(encrypted), "\n")
+	for i, line := range plainLines[:len(plainLines)-1] {
+		if line == lines[i] {
+			continue
+		}
+		key, value, _ := strings.Cut(strings.TrimSpace(lines[i]), ": ")
+		if key != "password" && key != "secret_key" && key != "slack_webhook" {
+			t.Errorf("line %d changed from %q to %q", i+1, line, lines[i])
+		}
+		if !IsEncrypted(value) {
+			t.Errorf("line %d is %q, want an encrypted value", i+1, lines[i])
+		}
+	}
+	if tail := strings.Join(lines[len(plainLines)-1:], "\n"); !strings.HasPrefix(tail, "encryption:\n  age_recipients:") {
+		t.Errorf("file ends with %q, want the encryption block", tail)
+	}
+	if strings.Contains(string(encrypted), "s3cret") || strings.Contains(string(encrypted), "aws-secret") {
+		t.Errorf("encrypted file holds plain values:\n%s", encrypted)
+	}
+	got, err := ReadEncryption(encrypted)
+	if err != nil || got == nil || len(got.AgeRecipients) != 1 || got.AgeRecipients[0] != enc.AgeRecipients[0] {
+		t.Errorf("ReadEncryption = %+v, %v, want %+v", got, err, enc)
+	}
+
+	// Encrypting again leaves the encrypted values as they are
+	again, err := EncryptFile(encrypted, enc, recipients)
+	if err != nil || string(again) != string(encrypted) {
+		t.Errorf("encrypting twice changed the file:\n%s", again)
+	}
+
+	decrypted, err := DecryptFile(encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(decrypted) != plainConfig {
+		t.Errorf("DecryptFile returned\n%s\nwant\n%s", decrypted, plainConfig)
+	}
+
+	// Values are decrypted when they are resolved
+	for _, line := range lines {
+		if key, value, _ := strings.Cut(strings.TrimSpace(line), ": "); key == "password" {
+			if plain, err := ResolveSecret(context.Background(), value); err != nil || plain != "s3cret" {
+				t.Errorf("ResolveSecret = %q, %v, want s3cret", plain, err)
+			}
+		}
+	}
+}
+
+func TestEncryptFilePassphrase(t *testing.T) {
+	ageKeys(t, "", "", "correct horse")
+	enc := EncryptionConfig{Passphrase: true}
+	recipients, err := enc.Recipients("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := EncryptFile([]byte("database:\n  password: s3cret\n"), enc, recipients)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := ReadEncryption(encrypted); err != nil || got == nil || !got.Passphrase {
+		t.Errorf("ReadEncryption = %+v, %v, want a passphrase", got, err)
+	}
+
+	decrypted, err := DecryptFile(encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "database:\n  password: s3cret\n"; string(decrypted) != want {
+		t.Errorf("DecryptFile returned %q, want %q", decrypted, want)
+	}
+
+	// A passphrase the user is prompted for is tried as well
+	ageKeys(t, "", "", "")
+	if err := AddPassphrase("correct horse"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := DecryptFile(encrypted); err != nil {
+		t.Errorf("DecryptFile with an added passphrase: %v", err)
+	}
+}
+
+func TestDecryptFileWrongKey(t *testing.T) {
+	identity := newIdentity(t)
+	recipients := []age.Recipient{identity.Recipient()}
+	enc := EncryptionConfig{AgeRecipients: []string{identity.Recipient().String()}}
+	encrypted, err := EncryptFile([]byte(plainConfig), enc, recipients)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name                     string
+		key, keyFile, passphrase string
+		wantErr                  string
+	}{
+		{name: "no key", wantErr: "no key is available"},
+		{name: "other identity", key: newIdentity(t).String(), wantErr: "line 6: password: failed to decrypt value"},
+		{name: "passphrase", passphrase: "correct horse", wantErr: "line 11: secret_key: failed to decrypt value"},
+		{name: "missing key file", keyFile: filepath.Join(t.TempDir(), "missing"), wantErr: "failed to read age key file"},
+		{name: "invalid key", key: "AGE-SECRET-KEY-1INVALID", wantErr: "failed to parse age identities"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ageKeys(t, tt.key, tt.keyFile, tt.passphrase)
+			decrypted, err := DecryptFile(encrypted)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("DecryptFile returned %v, want an error containing %q", err, tt.wantErr)
+			}
+			if decrypted != nil || err != nil && strings.Contains(err.Error(), "s3cret") {
+				t.Errorf("DecryptFile with the wrong key leaked %q, %v", decrypted, err)
+			}
+		})
+	}
+}
+
+func TestEncryptionRecipients(t *testing.T) {
+	recipient := newIdentity(t).Recipient().String()
+	tests := []struct {
+		enc        EncryptionConfig
+		passphrase string
+		wantErr    string
+	}{
+		{EncryptionConfig{AgeRecipients: []string{recipient}}, "", ""},
+		{EncryptionConfig{Passphrase: true}, "pass", ""},
+		{EncryptionConfig{}, "", "no age recipients or passphrase configured"},
+		{EncryptionConfig{Passphrase: true}, "", "no passphrase given"},
+		{EncryptionConfig{Passphrase: true, AgeRecipients: []string{recipient}}, "pass", "cannot be combined"},
+		{EncryptionConfig{AgeRecipients: []string{"age1invalid"}}, "", `invalid age recipient "age1invalid"`},
+	}
+	for _, tt := range tests {
+		recipients, err := tt.enc.Recipients(tt.passphrase)
+		if tt.wantErr == "" {
+			if err != nil || len(recipients) != 1 {
+				t.Errorf("Recipients of %+v = %v, %v", tt.enc, recipients, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("Recipients of %+v returned %v, want an error containing %q", tt.enc, err, tt.wantErr)
+		}
+	}
+}
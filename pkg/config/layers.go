@@ -0,0 +1,514 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// The configuration is merged from several layers, each overriding the
+// values of the ones before it:
+//
+//  1. /etc/dbbackup/config.yml (system)
+//  2. $XDG_CONFIG_HOME/dbbackup/config.yml and ~/.dbbackup/config.yml (user)
+//  3. ./config.yml, or the file given with --config (project)
+//  4. DBBACKUP_* environment variables, e.g. DBBACKUP_DATABASE_HOST
+//  5. --set key=value flags, e.g. --set storages.0.bucket=backups
+//
+// Mappings are merged key by key; lists and other values are replaced. A file
+// can list other files under include:, which are loaded before it, so that
+// its own values win.
+
+// EnvPrefix is the prefix of environment variables overriding settings
+const EnvPrefix = "DBBACKUP_"
+
+// reservedEnv are DBBACKUP_ variables that are not settings
+var reservedEnv = []string{"DBBACKUP_AGE_KEY", "DBBACKUP_AGE_KEY_FILE", "DBBACKUP_PASSPHRASE"}
+
+// LoadOptions selects the layers to load
+type LoadOptions struct {
+	Path       string   // replaces ./config.yml as the project file
+	Set        []string // key=value overrides from the command line
+	NoDefaults bool     // skips the system, user and project files, loading only Path
+}
+
+// Layers is the merged configuration document and where each value came from
+type Layers struct {
+	Root     *yaml.Node        // merged mapping
+	Origins  map[string]string // origin of each value by dotted key, e.g. database.host
+	Files    []string          // files loaded, lowest precedence first
+	Warnings []string          // deprecated settings and ignored variables found
+}
+
+// LoadLayers reads and merges every configuration layer
+func LoadLayers(opts LoadOptions) (*Layers, error) {
+	l := &Layers{
+		Root:    &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"},
+		Origins: make(map[string]string),
+	}
+
+	var files []string
+	if !opts.NoDefaults {
+		files = findConfigFiles()
+	}
+	if opts.Path != "" {
+		// The given file takes the place of the project file
+		if len(files) > 0 && filepath.Dir(files[0]) == "." {
+			files = files[1:]
+		}
+		files = append([]string{opts.Path}, files...)
+	}
+	for _, path := range slices.Backward(files) {
+		if err := l.loadFile(path, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	envSet, err := l.loadEnv()
+	if err != nil {
+		return nil, err
+	}
+	if len(l.Files) == 0 && !envSet {
+		if opts.NoDefaults {
+			return nil, fmt.Errorf("no config file given and no %s* variables set", EnvPrefix)
+		}
+		_, err := findConfigFile()
+		return nil, err
+	}
+
+	for _, set := range opts.Set {
+		key, value, ok := strings.Cut(set, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid --set %q, expected key=value", set)
+		}
+		path := strings.Split(key, ".")
+		typ, ok := settingType(reflect.TypeOf(Config{}), path)
+		if !ok {
+			return nil, fmt.Errorf("invalid --set %q: unknown setting %s", set, key)
+		}
+		node, err := valueNode(value, typ)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --set %q: %w", set, err)
+		}
+		l.set(path, node, "flag --set "+key)
+	}
+	return l, nil
+}
+
+// Decode decodes the merged configuration, rejecting keys that match no
+// setting and values of the wrong type
+func (l *Layers) Decode() (*Config, error) {
+	if errs := l.Check(); len(errs) > 0 {
+		return nil, fmt.Errorf("invalid configuration:\n%w", errors.Join(errs...))
+	}
+	var cfg Config
+	if err := l.Root.Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse config file: %w", err)
+	}
+	cfg.Include = nil
+	cfg.Version = CurrentVersion
+	return &cfg, nil
+}
+
+// Check returns an error for every key of the merged configuration that
+// matches no setting and every value that does not fit its setting, each
+// starting with where the key was set
+func (l *Layers) Check() []error {
+	return l.checkNode(l.Root, reflect.TypeOf(Config{}), "")
+}
+
+// Origin returns where the value at path, a dotted key, was set, or where
+// the first value below it was set for a mapping or list. It returns an
+// empty string for settings that were not set.
+func (l *Layers) Origin(path string) string {
+	if origin, ok := l.Origins[path]; ok {
+		return origin
+	}
+	var keys []string
+	for key := range l.Origins {
+		if strings.HasPrefix(key, path+".") {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return ""
+	}
+	return l.Origins[slices.Min(keys)]
+}
+
+// checkNode checks node against the setting of type t at path
+func (l *Layers) checkNode(node *yaml.Node, t reflect.Type, path string) []error {
+	if node.Tag == "!!null" {
+		return nil
+	}
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Struct, reflect.Map:
+		if node.Kind != yaml.MappingNode {
+			return []error{l.nodeError(path, node, "expected a mapping")}
+		}
+		var errs []error
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			keyPath := joinPath(path, key.Value)
+			typ, ok := settingType(t, []string{key.Value})
+			if !ok {
+				errs = append(errs, l.nodeError(keyPath, key, "unknown setting"))
+				continue
+			}
+			errs = append(errs, l.checkNode(value, typ, keyPath)...)
+		}
+		return errs
+	case reflect.Slice:
+		if node.Kind != yaml.SequenceNode {
+			return []error{l.nodeError(path, node, "expected a list")}
+		}
+		var errs []error
+		for i, item := range node.Content {
+			errs = append(errs, l.checkNode(item, t.Elem(), fmt.Sprintf("%s.%d", path, i))...)
+		}
+		return errs
+	}
+	if node.Kind != yaml.ScalarNode {
+		return []error{l.nodeError(path, node, "expected a single value")}
+	}
+	if err := node.Decode(reflect.New(t).Interface()); err != nil {
+		return []error{l.nodeError(path, node, fmt.Sprintf("%q is not a valid %s", node.Value, t))}
+	}
+	return nil
+}
+
+// nodeError returns an error for the setting at path, starting with where
+// it was set
+func (l *Layers) nodeError(path string, node *yaml.Node, msg string) error {
+	origin := l.Origin(path)
+	if i := strings.LastIndex(origin, ":"); i > 0 && isIndex(origin[i+1:]) && node.Line > 0 {
+		// The origin of a mapping is the line of its first value, rather
+		// than of its key
+		origin = fmt.Sprintf("%s:%d", origin[:i], node.Line)
+	} else if origin == "" && node.Line > 0 {
+		origin = fmt.Sprintf("line %d", node.Line)
+	}
+	if origin == "" {
+		return fmt.Errorf("%s: %s", path, msg)
+	}
+	return fmt.Errorf("%s: %s: %s", origin, path, msg)
+}
+
+// loadFile merges the files a file includes and then the file itself.
+// including holds the files including it, to detect cycles.
+func (l *Layers) loadFile(path string, including []string) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+	if slices.Contains(including, abs) {
+		return fmt.Errorf("config file %s includes itself", path)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	if len(doc.Content) == 0 {
+		// Empty file
+		l.Files = append(l.Files, path)
+		return nil
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return fmt.Errorf("config file %s is not a YAML mapping", path)
+	}
+
+	version, changes, err := Migrate(root)
+	if err != nil {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+	if len(changes) > 0 {
+		warning := fmt.Sprintf("%s uses configuration format version %d, run 'dbbackup config migrate -c %s' to upgrade it:",
+			path, version, path)
+		for _, change := range changes {
+			warning += "\n  " + change
+		}
+		l.Warnings = append(l.Warnings, warning)
+	}
+	// Every layer is in the current format once migrated
+	removeKey(root, "version")
+
+	if includes := removeKey(root, "include"); includes != nil {
+		var patterns []string
+		if err := includes.Decode(&patterns); err != nil {
+			var single string
+			if includes.Decode(&single) != nil {
+				return fmt.Errorf("%s:%d: include must be a file or a list of files", path, includes.Line)
+			}
+			patterns = []string{single}
+		}
+		for _, pattern := range patterns {
+			if !filepath.IsAbs(pattern) {
+				pattern = filepath.Join(filepath.Dir(path), pattern)
+			}
+			matches, err := filepath.Glob(pattern)
+			if err != nil {
+				return fmt.Errorf("%s: invalid include %q: %w", path, pattern, err)
+			}
+			if len(matches) == 0 && !strings.ContainsAny(pattern, "*?[") {
+				return fmt.Errorf("%s: included file %s not found", path, pattern)
+			}
+			for _, match := range matches {
+				if err := l.loadFile(match, append(including, abs)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	l.merge(l.Root, root, "", func(n *yaml.Node) string {
+		return fmt.Sprintf("%s:%d", path, n.Line)
+	})
+	l.Files = append(l.Files, path)
+	return nil
+}
+
+// loadEnv applies the DBBACKUP_* environment variables and reports whether
+// any was set
+func (l *Layers) loadEnv() (bool, error) {
+	set := false
+	env := os.Environ()
+	slices.Sort(env)
+	for _, entry := range env {
+		name, value, _ := strings.Cut(entry, "=")
+		if !strings.HasPrefix(name, EnvPrefix) || slices.Contains(reservedEnv, name) {
+			continue
+		}
+		path, typ, ok := envSetting(reflect.TypeOf(Config{}), strings.TrimPrefix(name, EnvPrefix))
+		if !ok {
+			l.Warnings = append(l.Warnings, fmt.Sprintf("ignoring %s, which matches no setting", name))
+			continue
+		}
+		node, err := valueNode(value, typ)
+		if err != nil {
+			return false, fmt.Errorf("invalid %s: %w", name, err)
+		}
+		l.set(path, node, "env "+name)
+		set = true
+	}
+	return set, nil
+}
+
+// merge merges the mapping src into dst, recording the origin of every value
+// it sets
+func (l *Layers) merge(dst, src *yaml.Node, path string, origin func(*yaml.Node) string) {
+	for i := 0; i+1 < len(src.Content); i += 2 {
+		key, value := src.Content[i], src.Content[i+1]
+		keyPath := joinPath(path, key.Value)
+		if existing := lookupKey(dst, key.Value); existing != nil &&
+			existing.Kind == yaml.MappingNode && value.Kind == yaml.MappingNode {
+			l.merge(existing, value, keyPath, origin)
+			continue
+		}
+		setKey(dst, key.Value, value)
+		l.record(keyPath, value, origin)
+	}
+}
+
+// set sets the value at path, creating the mappings and list items leading
+// to it
+func (l *Layers) set(path []string, value *yaml.Node, origin string) {
+	node := l.Root
+	for i, name := range path[:len(path)-1] {
+		kind := yaml.MappingNode
+		if isIndex(path[i+1]) {
+			kind = yaml.SequenceNode
+		}
+		child := childNode(node, name)
+		if child == nil || child.Kind != kind {
+			child = &yaml.Node{Kind: kind}
+			setChild(node, name, child)
+		}
+		node = child
+	}
+	setChild(node, path[len(path)-1], value)
+	l.record(strings.Join(path, "."), value, func(*yaml.Node) string { return origin })
+}
+
+// childNode returns the value of a key of a mapping or an item of a list by
+// its index, or nil
+func childNode(node *yaml.Node, name string) *yaml.Node {
+	if node.Kind != yaml.SequenceNode {
+		return lookupKey(node, name)
+	}
+	index, _ := strconv.Atoi(name)
+	if index < len(node.Content) {
+		return node.Content[index]
+	}
+	return nil
+}
+
+// setChild sets the value of a key of a mapping or an item of a list by its
+// index, growing the list as needed
+func setChild(node *yaml.Node, name string, value *yaml.Node) {
+	if node.Kind != yaml.SequenceNode {
+		setKey(node, name, value)
+		return
+	}
+	index, _ := strconv.Atoi(name)
+	for len(node.Content) <= index {
+		node.Content = append(node.Content, &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"})
+	}
+	node.Content[index] = value
+}
+
+// record sets the origin of every value below path, forgetting the origins
+// of the values it replaced
+func (l *Layers) record(path string, value *yaml.Node, origin func(*yaml.Node) string) {
+	for key := range l.Origins {
+		if key == path || strings.HasPrefix(key, path+".") {
+			delete(l.Origins, key)
+		}
+	}
+	switch value.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(value.Content); i += 2 {
+			l.record(joinPath(path, value.Content[i].Value), value.Content[i+1], origin)
+		}
+		if len(value.Content) > 0 {
+			return
+		}
+	case yaml.SequenceNode:
+		for i, item := range value.Content {
+			l.record(fmt.Sprintf("%s.%d", path, i), item, origin)
+		}
+		if len(value.Content) > 0 {
+			return
+		}
+	}
+	l.Origins[path] = origin(value)
+}
+
+// envSetting finds the setting an environment variable name refers to, with
+// the prefix removed, e.g. STORAGES_0_BUCKET for storages.0.bucket, and
+// returns its path and type. Keys of mappings such as labels are lowercased.
+func envSetting(t reflect.Type, name string) ([]string, reflect.Type, bool) {
+	switch t.Kind() {
+	case reflect.Pointer:
+		return envSetting(t.Elem(), name)
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			key, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+			if key == "" || key == "-" {
+				continue
+			}
+			upper := strings.ToUpper(key)
+			if name == upper {
+				return []string{key}, field.Type, true
+			}
+			if rest, ok := strings.CutPrefix(name, upper+"_"); ok {
+				if path, typ, ok := envSetting(field.Type, rest); ok {
+					return append([]string{key}, path...), typ, true
+				}
+			}
+		}
+	case reflect.Slice:
+		index, rest, _ := strings.Cut(name, "_")
+		if !isIndex(index) {
+			return nil, nil, false
+		}
+		if rest == "" {
+			return []string{index}, t.Elem(), true
+		}
+		if path, typ, ok := envSetting(t.Elem(), rest); ok {
+			return append([]string{index}, path...), typ, true
+		}
+	case reflect.Map:
+		if name != "" {
+			return []string{strings.ToLower(name)}, t.Elem(), true
+		}
+	}
+	return nil, nil, false
+}
+
+// settingType returns the type of the setting at path, a dotted key split at
+// the dots
+func settingType(t reflect.Type, path []string) (reflect.Type, bool) {
+	if len(path) == 0 {
+		return t, true
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return settingType(t.Elem(), path)
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			key, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+			if key == path[0] {
+				return settingType(field.Type, path[1:])
+			}
+		}
+	case reflect.Slice:
+		if isIndex(path[0]) {
+			return settingType(t.Elem(), path[1:])
+		}
+	case reflect.Map:
+		if len(path) == 1 && path[0] != "" {
+			return t.Elem(), true
+		}
+	}
+	return nil, false
+}
+
+// isIndex reports whether s is a list index
+func isIndex(s string) bool {
+	return s != "" && strings.Trim(s, "0123456789") == ""
+}
+
+// valueNode parses a value given in the environment or on the command line
+// for a setting of type t. Strings are taken as they are; other settings are
+// parsed as YAML, e.g. a list as [a, b].
+func valueNode(value string, t reflect.Type) (*yaml.Node, error) {
+	if t.Kind() == reflect.String {
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value}, nil
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(value), &doc); err != nil || len(doc.Content) == 0 {
+		return nil, fmt.Errorf("%q is not a valid %s", value, t)
+	}
+	node := doc.Content[0]
+	if err := node.Decode(reflect.New(t).Interface()); err != nil {
+		return nil, fmt.Errorf("%q is not a valid %s", value, t)
+	}
+	return node, nil
+}
+
+// lookupKey returns the value of a key of a mapping, or nil
+func lookupKey(mapping *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// removeKey removes a key from a mapping and returns its value, or nil
+func removeKey(mapping *yaml.Node, key string) *yaml.Node {
+	value := lookupKey(mapping, key)
+	if value != nil {
+		setKey(mapping, key, nil)
+	}
+	return value
+}
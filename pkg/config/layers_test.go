@@ -0,0 +1,361 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// layerDirs isolates the configuration search paths in temporary
+// directories and returns the project and user directories
+func layerDirs(t *testing.T) (project, user string) {
+	t.Helper()
+	if _, err := os.Stat("/etc/dbbackup"); err == nil {
+		t.Skip("a system configuration is installed")
+	}
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(home, ".config"))
+	project = t.TempDir()
+	t.Chdir(project)
+	user = filepath.Join(home, ".dbbackup")
+	if err := os.Mkdir(user, 0755); err != nil {
+		t.Fatal(err)
+	}
+	return project, user
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadLayersMerge(t *testing.T) {
+	project, user := layerDirs(t)
+	writeFile(t, filepath.Join(user, "config.yml"), `
+database:
+  type: postgres
+  host: db.internal
+  port: 5432
+  username: backup
+storages:
+  - type: s3
+    bucket: user-bucket
+  - type: local
+    path: /backups
+labels:
+  team: data
+`)
+	writeFile(t, filepath.Join(project, "config.yml"), `
+database:
+  host: db.project
+storages:
+  - type: s3
+    bucket: project-bucket
+labels:
+  env: staging
+`)
+
+	layers, err := LoadLayers(LoadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := layers.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Mappings are merged key by key
+	if cfg.Database.Host != "db.project" || cfg.Database.Port != 5432 || cfg.Database.Username != "backup" {
+		t.Errorf("database is %+v, want the project host with the user's port and username", cfg.Database)
+	}
+	if !reflect.DeepEqual(cfg.Labels, map[string]string{"team": "data", "env": "staging"}) {
+		t.Errorf("labels are %v, want both files' labels", cfg.Labels)
+	}
+	// Lists are replaced
+	if len(cfg.Storages) != 1 || cfg.Storages[0].Bucket != "project-bucket" {
+		t.Errorf("storages are %+v, want only the project's", cfg.Storages)
+	}
+
+	if got := layers.Origins["database.host"]; !strings.HasPrefix(got, "config.yml:") {
+		t.Errorf("database.host was set at %q, want the project file", got)
+	}
+	if got := layers.Origins["database.port"]; !strings.HasPrefix(got, filepath.Join(user, "config.yml")+":") {
+		t.Errorf("database.port was set at %q, want the user file", got)
+	}
+	if len(layers.Files) != 2 {
+		t.Errorf("loaded %v, want the user and project files", layers.Files)
+	}
+}
+
+func TestLoadLayersOverrides(t *testing.T) {
+	project, _ := layerDirs(t)
+	writeFile(t, filepath.Join(project, "config.yml"), `
+database:
+  type: postgres
+  host: db.project
+  port: 5432
+storages:
+  - type: s3
+    bucket: project-bucket
+`)
+	t.Setenv("DBBACKUP_DATABASE_PORT", "6543")
+	t.Setenv("DBBACKUP_STORAGES_0_BUCKET", "env-bucket")
+	t.Setenv("DBBACKUP_LABELS_ENV", "production")
+
+	layers, err := LoadLayers(LoadOptions{Set: []string{"database.host=db.flag", "storages.0.bucket=flag-bucket"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := layers.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.Database.Port != 6543 || cfg.Labels["env"] != "production" {
+		t.Errorf("environment overrides not applied: port %d, labels %v", cfg.Database.Port, cfg.Labels)
+	}
+	// Flags win over the environment
+	if cfg.Database.Host != "db.flag" || cfg.Storages[0].Bucket != "flag-bucket" || cfg.Storages[0].Type != "s3" {
+		t.Errorf("flag overrides not applied: host %s, storage %+v", cfg.Database.Host, cfg.Storages[0])
+	}
+	if got := layers.Origins["database.port"]; got != "env DBBACKUP_DATABASE_PORT" {
+		t.Errorf("database.port was set at %q", got)
+	}
+	if got := layers.Origins["storages.0.bucket"]; got != "flag --set storages.0.bucket" {
+		t.Errorf("storages.0.bucket was set at %q", got)
+	}
+}
+
+func TestLoadLayersErrors(t *testing.T) {
+	project, _ := layerDirs(t)
+	writeFile(t, filepath.Join(project, "config.yml"), "database:\n  type: postgres\n")
+
+	tests := []struct {
+		name string
+		env  string
+		set  string
+		want string
+	}{
+		{name: "unknown --set", set: "database.hots=x", want: "unknown setting database.hots"},
+		{name: "--set without value", set: "database.host", want: "expected key=value"},
+		{name: "--set of the wrong type", set: "database.port=abc", want: `"abc" is not a valid int`},
+		{name: "environment of the wrong type", env: "DBBACKUP_DATABASE_PORT", want: "invalid DBBACKUP_DATABASE_PORT"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var opts LoadOptions
+			if tt.set != "" {
+				opts.Set = []string{tt.set}
+			}
+			if tt.env != "" {
+				t.Setenv(tt.env, "abc")
+			}
+			_, err := LoadLayers(opts)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("LoadLayers returned %v, want an error containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadLayersInclude(t *testing.T) {
+	project, _ := layerDirs(t)
+	writeFile(t, filepath.Join(project, "base.yml"), "database:\n  type: postgres\n  host: db.base\n  port: 5432\n")
+	writeFile(t, filepath.Join(project, "config.yml"), "include: base.yml\ndatabase:\n  host: db.project\n")
+
+	layers, err := LoadLayers(LoadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := layers.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The including file wins over the files it includes
+	if cfg.Database.Host != "db.project" || cfg.Database.Port != 5432 {
+		t.Errorf("database is %+v, want the project host over the included port", cfg.Database)
+	}
+
+	writeFile(t, filepath.Join(project, "base.yml"), "include: config.yml\n")
+	if _, err := LoadLayers(LoadOptions{}); err == nil || !strings.Contains(err.Error(), "includes itself") {
+		t.Errorf("LoadLayers of an include cycle returned %v", err)
+	}
+}
+
+func TestLoadLayersWarnings(t *testing.T) {
+	project, _ := layerDirs(t)
+	writeFile(t, filepath.Join(project, "config.yml"), "database:\n  type: postgres\nstorage:\n  type: s3\n  bucket: b\n  enabled: true\n")
+	t.Setenv("DBBACKUP_DATABASE_HOSTNAME", "db")
+
+	layers, err := LoadLayers(LoadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Warnings are returned for the caller to show, not printed
+	want := []string{
+		"config.yml uses configuration format version 1, run 'dbbackup config migrate -c config.yml' to upgrade it:\n" +
+			"  line 3: storage: moved to the storages list",
+		"ignoring DBBACKUP_DATABASE_HOSTNAME, which matches no setting",
+	}
+	if !reflect.DeepEqual(layers.Warnings, want) {
+		t.Errorf("warnings are\n%s\nwant\n%s", strings.Join(layers.Warnings, "\n"), strings.Join(want, "\n"))
+	}
+
+	_, warnings, err := Load(LoadOptions{})
+	if err != nil || !reflect.DeepEqual(warnings, want) {
+		t.Errorf("Load returned warnings %q, %v", warnings, err)
+	}
+}
+
+func TestLoadLayersNoDefaults(t *testing.T) {
+	project, user := layerDirs(t)
+	writeFile(t, filepath.Join(user, "config.yml"), "database:\n  type: postgres\n  port: 5432\n")
+	writeFile(t, filepath.Join(project, "config.yml"), "database:\n  host: db.project\n")
+	given := filepath.Join(t.TempDir(), "given.yml")
+	writeFile(t, given, "database:\n  type: mysql\n  host: db.given\n")
+
+	// The given file takes the place of the project file, over the user file
+	layers, err := LoadLayers(LoadOptions{Path: given})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{filepath.Join(user, "config.yml"), given}; !reflect.DeepEqual(layers.Files, want) {
+		t.Errorf("loaded %v, want %v", layers.Files, want)
+	}
+
+	layers, err = LoadLayers(LoadOptions{Path: given, NoDefaults: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := layers.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(layers.Files, []string{given}) || cfg.Database.Port != 0 || cfg.Database.Host != "db.given" {
+		t.Errorf("loaded %v with database %+v, want only the given file", layers.Files, cfg.Database)
+	}
+
+	if _, err := LoadLayers(LoadOptions{NoDefaults: true}); err == nil || !strings.Contains(err.Error(), "no config file given") {
+		t.Errorf("LoadLayers without any file returned %v", err)
+	}
+	t.Setenv("DBBACKUP_DATABASE_HOST", "db.env")
+	if layers, err := LoadLayers(LoadOptions{NoDefaults: true}); err != nil || len(layers.Files) != 0 {
+		t.Errorf("LoadLayers from the environment alone loaded %v, %v", layers, err)
+	}
+}
+
+func TestLayersCheck(t *testing.T) {
+	project, _ := layerDirs(t)
+	writeFile(t, filepath.Join(project, "config.yml"), `database:
+  type: postgres
+  hots: db
+  port: many
+storages: local
+`)
+	layers, err := LoadLayers(LoadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, err := range layers.Check() {
+		got = append(got, err.Error())
+	}
+	want := []string{
+		"config.yml:3: database.hots: unknown setting",
+		`config.yml:4: database.port: "many" is not a valid int`,
+		"config.yml:5: storages: expected a list",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Check returned\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestLayersCheckEveryLayer(t *testing.T) {
+	project, user := layerDirs(t)
+	writeFile(t, filepath.Join(user, "config.yml"), `database:
+  type: postgres
+  pasword: secret
+retry:
+  max_attempts: three
+`)
+	writeFile(t, filepath.Join(project, "config.yml"), `database:
+  host: db
+storages:
+  - type: local
+    path: /backups
+  - type: s3
+    buckett: backups
+    object_lock:
+      retain_dayz: 30
+`)
+	t.Setenv("DBBACKUP_DATABASE_PORT", "5432")
+
+	layers, err := LoadLayers(LoadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every problem is reported, in the order of the merged file, with the
+	// file and line of the key
+	userFile := filepath.Join(user, "config.yml")
+	want := []string{
+		userFile + ":3: database.pasword: unknown setting",
+		userFile + `:5: retry.max_attempts: "three" is not a valid int`,
+		"config.yml:7: storages.1.buckett: unknown setting",
+		"config.yml:9: storages.1.object_lock.retain_dayz: unknown setting",
+	}
+	var got []string
+	for _, err := range layers.Check() {
+		got = append(got, err.Error())
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Check returned\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	// Decode fails with all of them
+	_, err = layers.Decode()
+	for _, want := range want {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Decode returned %v, want an error containing %q", err, want)
+		}
+	}
+}
+
+func TestEnvSetting(t *testing.T) {
+	tests := []struct {
+		name string
+		path string // empty when the variable matches no setting
+		typ  string
+	}{
+		{"DATABASE_HOST", "database.host", "string"},
+		{"DATABASE_PORT", "database.port", "int"},
+		{"STORAGES_0_STORAGE_CLASS", "storages.0.storage_class", "string"},
+		{"STORAGES_0_BUCKET", "storages.0.bucket", "string"},
+		{"STORAGES_12_VOLUME_SIZE", "storages.12.volume_size", "string"},
+		{"TIERING_1_STORAGE_BUCKET", "tiering.1.storage.bucket", "string"},
+		{"LABELS_TEAM_NAME", "labels.team_name", "string"},
+		{"RETRY_JITTER", "retry.jitter", "float64"},
+		{"UPLOAD_RATE", "upload_rate", "string"},
+		{"DATABASE_HOSTNAME", "", ""},
+		{"STORAGES_BUCKET", "", ""},
+		{"LABELS", "labels", "map[string]string"},
+		{"NOPE", "", ""},
+	}
+	for _, tt := range tests {
+		path, typ, ok := envSetting(reflect.TypeOf(Config{}), tt.name)
+		if tt.path == "" {
+			if ok {
+				t.Errorf("envSetting(%s) = %v, want no setting", tt.name, path)
+			}
+			continue
+		}
+		if !ok || strings.Join(path, ".") != tt.path || typ.String() != tt.typ {
+			t.Errorf("envSetting(%s) = %v %v %v, want %s %s", tt.name, path, typ, ok, tt.path, tt.typ)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package config
+
+import (
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Configuration files record the version of their format under version:.
+// Files of older versions are upgraded in memory when loaded, with a warning
+// for every deprecated setting, and 'dbbackup config migrate' rewrites them
+// in the current format. Versions:
+//
+//  1. No version key. Backups go to the storage block when it is enabled
+//     and to the storages list.
+//  2. Backups go to the storages list only; a storage can be disabled.
+
+// CurrentVersion is the version of the configuration format of this release
+const CurrentVersion = 2
+
+// migrations upgrade a configuration file from version i+1 to i+2, editing
+// its root mapping in place, and describe each deprecated setting changed
+var migrations = []func(root *yaml.Node) []string{
+	migrateV1,
+}
+
+// Migrate upgrades the root mapping of a configuration file to the current
+// version and returns the version it had and a description of each change
+func Migrate(root *yaml.Node) (int, []string, error) {
+	version := 1
+	if node := lookupKey(root, "version"); node != nil {
+		if err := node.Decode(&version); err != nil || version < 1 {
+			return 0, nil, fmt.Errorf("line %d: invalid version %q", node.Line, node.Value)
+		}
+		if version > CurrentVersion {
+			return 0, nil, fmt.Errorf("line %d: format version %d is newer than this release supports (%d), upgrade dbbackup",
+				node.Line, version, CurrentVersion)
+		}
+	}
+	if version == CurrentVersion {
+		return version, nil, nil
+	}
+
+	var changes []string
+	for _, migrate := range migrations[version-1:] {
+		changes = append(changes, migrate(root)...)
+	}
+	// The version goes first, where readers look for it
+	removeKey(root, "version")
+	root.Content = append([]*yaml.Node{
+		{Kind: yaml.ScalarNode, Tag: "!!str", Value: "version"},
+		{Kind: yaml.ScalarNode, Tag: "!!int", Value: fmt.Sprint(CurrentVersion)},
+	}, root.Content...)
+	return version, changes, nil
+}
+
+// MigrateFile rewrites a configuration file in the current format, keeping
+// its comments, and returns the version it had and a description of each
+// change
+func MigrateFile(data []byte) ([]byte, int, []string, error) {
+	doc, root, err := parseDocument(data)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	version, changes, err := Migrate(root)
+	if err != nil || version == CurrentVersion {
+		return data, version, nil, err
+	}
+	migrated, err := encodeDocument(doc)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	return migrated, version, changes, nil
+}
+
+// migrateV1 moves the storage block to the front of the storages list, where
+// a block that is not enabled becomes a disabled storage, and drops the
+// enabled key of the other storages, which had no effect
+func migrateV1(root *yaml.Node) []string {
+	var changes []string
+	// Before the storage block is moved, so that the indexes match the file
+	if storages := lookupKey(root, "storages"); storages != nil && storages.Kind == yaml.SequenceNode {
+		for i, storage := range storages.Content {
+			changes = append(changes, dropEnabled(storage, fmt.Sprintf("storages.%d", i))...)
+		}
+	}
+	if tiering := lookupKey(root, "tiering"); tiering != nil && tiering.Kind == yaml.SequenceNode {
+		for i, tier := range tiering.Content {
+			if storage := lookupKey(tier, "storage"); storage != nil {
+				changes = append(changes, dropEnabled(storage, fmt.Sprintf("tiering.%d.storage", i))...)
+			}
+		}
+	}
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, block := root.Content[i], root.Content[i+1]
+		if key.Value != "storage" {
+			continue
+		}
+		if block.Kind != yaml.MappingNode || len(block.Content) == 0 {
+			changes = append(changes, fmt.Sprintf("line %d: storage: removed empty block", key.Line))
+			setKey(root, "storage", nil)
+			break
+		}
+
+		enabled := false
+		if node := removeKey(block, "enabled"); node != nil {
+			_ = node.Decode(&enabled)
+		}
+		change := fmt.Sprintf("line %d: storage: moved to the storages list", key.Line)
+		if !enabled {
+			setKey(block, "disabled", &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!bool", Value: "true", Line: key.Line})
+			change += " as a disabled storage, since it was not enabled"
+		}
+		changes = append(changes, change)
+		block.Style = 0
+
+		if storages := lookupKey(root, "storages"); storages != nil && storages.Kind == yaml.SequenceNode {
+			block.HeadComment = key.HeadComment
+			storages.Content = append([]*yaml.Node{block}, storages.Content...)
+			setKey(root, "storage", nil)
+		} else {
+			// Take the place of the block
+			key.Value = "storages"
+			root.Content[i+1] = &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq", Content: []*yaml.Node{block}}
+		}
+		break
+	}
+	return changes
+}
+
+// dropEnabled removes the enabled key of a storage in a list
+func dropEnabled(storage *yaml.Node, path string) []string {
+	if storage.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(storage.Content); i += 2 {
+		if key := storage.Content[i]; key.Value == "enabled" {
+			setKey(storage, "enabled", nil)
+			return []string{fmt.Sprintf("line %d: %s.enabled: removed, it had no effect outside the storage block", key.Line, path)}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package config
+
+import (
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestMigrateFileV1(t *testing.T) {
+	data := []byte(`# Nightly backups
+database:
+  type: postgres
+# Primary storage
+storage:
+  type: s3
+  bucket: primary
+  enabled: true
+storages:
+  - type: local
+    path: /backups
+    enabled: true
+tiering:
+  - after: 30d
+    storage:
+      type: s3
+      bucket: archive
+      enabled: false
+`)
+	migrated, version, changes, err := MigrateFile(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 1 {
+		t.Errorf("MigrateFile returned version %d, want 1", version)
+	}
+	wantChanges := []string{
+		"line 12: storages.0.enabled: removed, it had no effect outside the storage block",
+		"line 18: tiering.0.storage.enabled: removed, it had no effect outside the storage block",
+		"line 5: storage: moved to the storages list",
+	}
+	if strings.Join(changes, "\n") != strings.Join(wantChanges, "\n") {
+		t.Errorf("MigrateFile changes are\n%s\nwant\n%s", strings.Join(changes, "\n"), strings.Join(wantChanges, "\n"))
+	}
+
+	var cfg Config
+	if err := yaml.Unmarshal(migrated, &cfg); err != nil {
+		t.Fatalf("migrated file does not parse: %v\n%s", err, migrated)
+	}
+	if cfg.Version != CurrentVersion {
+		t.Errorf("migrated version is %d, want %d", cfg.Version, CurrentVersion)
+	}
+	if len(cfg.Storages) != 2 || cfg.Storages[0].Bucket != "primary" || cfg.Storages[0].Disabled || cfg.Storages[1].Path != "/backups" {
+		t.Errorf("migrated storages are %+v, want the storage block first", cfg.Storages)
+	}
+	for _, s := range []string{"# Nightly backups", "# Primary storage"} {
+		if !strings.Contains(string(migrated), s) {
+			t.Errorf("migrated file lost comment %q:\n%s", s, migrated)
+		}
+	}
+	if !strings.HasPrefix(string(migrated), "version: 2\n") {
+		t.Errorf("migrated file does not start with its version:\n%s", migrated)
+	}
+	if strings.Contains(string(migrated), "enabled") {
+		t.Errorf("migrated file still has enabled keys:\n%s", migrated)
+	}
+
+	// Migrating again changes nothing
+	again, version, changes, err := MigrateFile(migrated)
+	if err != nil || version != CurrentVersion || len(changes) != 0 || string(again) != string(migrated) {
+		t.Errorf("MigrateFile of a current file returned version %d, changes %v, %v", version, changes, err)
+	}
+}
+
+func TestMigrateV1StorageBlock(t *testing.T) {
+	tests := []struct {
+		name     string
+		in       string
+		storages int
+		disabled bool
+		change   string
+	}{
+		{
+			name:     "not enabled",
+			in:       "storage:\n  type: s3\n  bucket: b\n",
+			storages: 1,
+			disabled: true,
+			change:   "line 1: storage: moved to the storages list as a disabled storage, since it was not enabled",
+		},
+		{
+			name:     "without a storages list",
+			in:       "storage:\n  type: s3\n  bucket: b\n  enabled: true\n",
+			storages: 1,
+			change:   "line 1: storage: moved to the storages list",
+		},
+		{
+			name:   "empty",
+			in:     "storage: {}\nstorages:\n  - type: local\n    path: /b\n",
+			change: "line 1: storage: removed empty block",
+			// The storages list is kept as it is
+			storages: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			migrated, _, changes, err := MigrateFile([]byte(tt.in))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(changes) != 1 || changes[0] != tt.change {
+				t.Errorf("changes are %q, want %q", changes, tt.change)
+			}
+			var cfg Config
+			if err := yaml.Unmarshal(migrated, &cfg); err != nil {
+				t.Fatalf("migrated file does not parse: %v\n%s", err, migrated)
+			}
+			if len(cfg.Storages) != tt.storages || (tt.storages > 0 && cfg.Storages[0].Disabled != tt.disabled) {
+				t.Errorf("migrated storages are %+v", cfg.Storages)
+			}
+			if strings.Contains(string(migrated), "storage:") {
+				t.Errorf("migrated file still has a storage block:\n%s", migrated)
+			}
+		})
+	}
+}
+
+func TestMigrateVersionErrors(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"version: 0\n", `line 1: invalid version "0"`},
+		{"version: two\n", `line 1: invalid version "two"`},
+		{"version: 3\n", "format version 3 is newer than this release supports (2)"},
+	}
+	for _, tt := range tests {
+		if _, _, _, err := MigrateFile([]byte(tt.in)); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("MigrateFile(%q) returned %v, want an error containing %q", tt.in, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+)
+
+// schemaRules are the JSON Schema keywords added to settings beyond their
+// type, by struct type and key. Settings are never required by the schema,
+// since a file may only hold some of them and leave the rest to other
+// layers.
+var schemaRules = map[string]map[string]any{
+	"Config.version": {"minimum": 1, "maximum": CurrentVersion},
+	"Config.include": {
+		"type":  []string{"string", "array"},
+		"items": map[string]any{"type": "string"},
+	},
+	"Config.storage_policy": {"enum": []string{"all", "any", "majority"}},
+
+	"DatabaseConfig.type":             {"enum": []string{"postgres", "mysql"}},
+	"DatabaseConfig.port":             {"minimum": 1, "maximum": 65535},
+	"DatabaseConfig.dump_compression": {"minimum": 0, "maximum": 9},
+	"DatabaseConfig.nice":             {"minimum": -20, "maximum": 19},
+	"DatabaseConfig.ionice_class":     {"enum": []string{"idle", "best-effort"}},
+	"DatabaseConfig.ionice_level":     {"minimum": 0, "maximum": 7},
+	"DatabaseConfig.ssl_mode":         {"enum": []string{"disable", "require", "verify-ca", "verify-full"}},
+	"DatabaseConfig.tool_paths": {
+		"propertyNames": map[string]any{"enum": []string{"pg_dump", "pg_restore", "mysqldump", "mysql"}},
+	},
+	"DatabaseConfig.dump_from":        {"enum": []string{"primary", "replica"}},
+	"DatabaseConfig.replica_fallback": {"enum": []string{"primary", "fail"}},
+	"ReplicaConfig.port":              {"minimum": 1, "maximum": 65535},
+	"SSHTunnelConfig.port":            {"minimum": 1, "maximum": 65535},
+
+	"StorageConfig.type":                   {"enum": []string{"local", "s3"}},
+	"StorageConfig.mode":                   {"enum": []string{"", "repository"}},
+	"StorageConfig.server_side_encryption": {"enum": []string{"AES256", "aws:kms", "aws:kms:dsse"}},
+	"ObjectLockConfig.mode":                {"enum": []string{"GOVERNANCE", "COMPLIANCE"}},
+	"ObjectLockConfig.retain_days":         {"minimum": 0},
+	"ObjectLockConfig.retain_until":        {"format": "date"},
+
+	"RetryConfig.max_attempts":    {"minimum": 0},
+	"RetryConfig.jitter":          {"minimum": 0, "maximum": 1},
+	"PreflightConfig.size_factor": {"minimum": 0},
+}
+
+// Schema returns a JSON Schema of the configuration file, for editors to
+// complete and check it
+func Schema() map[string]any {
+	defs := map[string]any{}
+	schema := structSchema(reflect.TypeOf(Config{}), defs)
+	schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	schema["title"] = "dbbackup configuration"
+	schema["$defs"] = defs
+	return schema
+}
+
+// typeSchema returns the schema of a setting of type t, adding the structs
+// it refers to to defs
+func typeSchema(t reflect.Type, defs map[string]any) map[string]any {
+	switch t.Kind() {
+	case reflect.Pointer:
+		return typeSchema(t.Elem(), defs)
+	case reflect.Struct:
+		if _, ok := defs[t.Name()]; !ok {
+			// Set first, for structs referring to themselves
+			defs[t.Name()] = nil
+			defs[t.Name()] = structSchema(t, defs)
+		}
+		return map[string]any{"$ref": "#/$defs/" + t.Name()}
+	case reflect.Slice:
+		return map[string]any{"type": "array", "items": typeSchema(t.Elem(), defs)}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": typeSchema(t.Elem(), defs)}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	}
+	return map[string]any{"type": "string"}
+}
+
+// structSchema returns the schema of a struct, which accepts no keys other
+// than its settings
+func structSchema(t reflect.Type, defs map[string]any) map[string]any {
+	properties := map[string]any{}
+	for i := 0; i < t.NumField(); i++ {
+		key, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+		if key == "" || key == "-" {
+			continue
+		}
+		property := typeSchema(t.Field(i).Type, defs)
+		for keyword, value := range schemaRules[t.Name()+"."+key] {
+			property[keyword] = value
+		}
+		properties[key] = property
+	}
+	return map[string]any{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+}
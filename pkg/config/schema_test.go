@@ -0,0 +1,59 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite config.schema.json from the Go types")
+
+// TestSchemaGolden checks that the committed config.schema.json matches the
+// settings. Run go test ./pkg/config -run TestSchemaGolden -update after
+// changing them.
+func TestSchemaGolden(t *testing.T) {
+	var buf bytes.Buffer
+	// Encoded as by config schema
+	enc := json.NewEncoder(&buf)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(Schema()); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join("..", "..", "config.schema.json")
+	if *update {
+		if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("config.schema.json is out of date, run go test ./pkg/config -run TestSchemaGolden -update")
+	}
+}
+
+func TestSchemaRules(t *testing.T) {
+	// Every rule applies to a setting
+	schema := Schema()
+	defs := schema["$defs"].(map[string]any)
+	for key := range schemaRules {
+		typ, name, _ := strings.Cut(key, ".")
+		var properties map[string]any
+		if typ == "Config" {
+			properties = schema["properties"].(map[string]any)
+		} else if def, ok := defs[typ].(map[string]any); ok {
+			properties = def["properties"].(map[string]any)
+		}
+		if _, ok := properties[name]; !ok {
+			t.Errorf("schema rule %s matches no setting", key)
+		}
+	}
+}
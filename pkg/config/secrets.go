@@ -0,0 +1,256 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Any string in the configuration can refer to a secret kept elsewhere
+// instead of holding it in plain text:
+//
+//	env:DB_PASS                     environment variable
+//	file:/run/secrets/db            file contents, without the trailing newline
+//	exec:pass show db               output of a command, run without a shell
+//	vault:secret/data/dbbackup#db   field of a Vault secret, read from VAULT_ADDR
+//
+// References are resolved when the configuration is loaded. Errors name the
+// reference but never the secret.
+
+// secretTimeout bounds commands and Vault requests resolving a secret
+const secretTimeout = 30 * time.Second
+
+var secretResolvers = map[string]func(ctx context.Context, ref string) (string, error){
+	"env":   envSecret,
+	"file":  fileSecret,
+	"exec":  execSecret,
+	"vault": vaultSecret,
+}
+
+// secretCache holds the secrets resolved by this process, so that loading
+// the configuration again does not run commands or query Vault again
+var secretCache = struct {
+	sync.Mutex
+	values map[string]string
+}{values: make(map[string]string)}
+
+// IsSecretRef reports whether s refers to a secret or is an encrypted value
+func IsSecretRef(s string) bool {
+	if IsEncrypted(s) {
+		return true
+	}
+	scheme, _, ok := strings.Cut(s, ":")
+	_, known := secretResolvers[scheme]
+	return ok && known
+}
+
+// ResolveSecret returns the secret s refers to or decrypts, or s itself if it
+// is neither a reference nor encrypted
+func ResolveSecret(ctx context.Context, s string) (string, error) {
+	scheme, ref, ok := strings.Cut(s, ":")
+	resolve, known := secretResolvers[scheme]
+	if IsEncrypted(s) {
+		ref, resolve = s, func(_ context.Context, value string) (string, error) { return decryptValue(value) }
+	} else if !ok || !known {
+		return s, nil
+	}
+
+	secretCache.Lock()
+	defer secretCache.Unlock()
+	if value, ok := secretCache.values[s]; ok {
+		return value, nil
+	}
+	value, err := resolve(ctx, ref)
+	if err != nil {
+		return "", err
+	}
+	secretCache.values[s] = value
+	return value, nil
+}
+
+// ResolveSecrets replaces the secret references and encrypted values of cfg
+// with the secrets, returning an error for every one that cannot be resolved
+func ResolveSecrets(ctx context.Context, cfg *Config) error {
+	return resolveSecrets(ctx, reflect.ValueOf(cfg), "")
+}
+
+// resolveSecrets replaces the secret references in every string field, map
+// value and list item below v, which must be addressable
+func resolveSecrets(ctx context.Context, v reflect.Value, path string) error {
+	switch v.Kind() {
+	case reflect.String:
+		if !IsSecretRef(v.String()) {
+			return nil
+		}
+		value, err := ResolveSecret(ctx, v.String())
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		v.SetString(value)
+	case reflect.Pointer:
+		if !v.IsNil() {
+			return resolveSecrets(ctx, v.Elem(), path)
+		}
+	case reflect.Struct:
+		var errs []error
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+			if name == "" {
+				name = strings.ToLower(field.Name)
+			}
+			errs = append(errs, resolveSecrets(ctx, v.Field(i), joinPath(path, name)))
+		}
+		return errors.Join(errs...)
+	case reflect.Slice:
+		var errs []error
+		for i := 0; i < v.Len(); i++ {
+			errs = append(errs, resolveSecrets(ctx, v.Index(i), fmt.Sprintf("%s[%d]", path, i)))
+		}
+		return errors.Join(errs...)
+	case reflect.Map:
+		if v.Type().Elem().Kind() != reflect.String {
+			return nil
+		}
+		var errs []error
+		iter := v.MapRange()
+		for iter.Next() {
+			if !IsSecretRef(iter.Value().String()) {
+				continue
+			}
+			value, err := ResolveSecret(ctx, iter.Value().String())
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s.%v: %w", path, iter.Key(), err))
+				continue
+			}
+			v.SetMapIndex(iter.Key(), reflect.ValueOf(value).Convert(v.Type().Elem()))
+		}
+		return errors.Join(errs...)
+	}
+	return nil
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+func envSecret(_ context.Context, name string) (string, error) {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return "", fmt.Errorf("environment variable %s is not set", name)
+	}
+	return value, nil
+}
+
+func fileSecret(_ context.Context, path string) (string, error) {
+	data, err := os.ReadFile(os.ExpandEnv(path))
+	if err != nil {
+		// The error only names the file
+		return "", fmt.Errorf("failed to read secret file: %w", err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+func execSecret(ctx context.Context, command string) (string, error) {
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return "", fmt.Errorf("empty secret command")
+	}
+	ctx, cancel := context.WithTimeout(ctx, secretTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stderr = os.Stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("secret command %s failed: %w", args[0], err)
+	}
+	return strings.TrimRight(string(output), "\r\n"), nil
+}
+
+// vaultSecret reads a field of a secret from the Vault HTTP API at VAULT_ADDR
+// with the token in VAULT_TOKEN or ~/.vault-token. The reference is the API
+// path and the field, e.g. secret/data/dbbackup#password for a KV version 2
+// engine mounted at secret/.
+func vaultSecret(ctx context.Context, ref string) (string, error) {
+	path, field, ok := strings.Cut(ref, "#")
+	if !ok || path == "" || field == "" {
+		return "", fmt.Errorf("vault reference %q must be of the form path#field", ref)
+	}
+
+	addr := os.Getenv("VAULT_ADDR")
+	if addr == "" {
+		addr = "https://127.0.0.1:8200"
+	}
+	token := os.Getenv("VAULT_TOKEN")
+	if token == "" {
+		home, _ := os.UserHomeDir()
+		data, err := os.ReadFile(filepath.Join(home, ".vault-token"))
+		if err != nil {
+			return "", fmt.Errorf("no Vault token, set VAULT_TOKEN or log in with vault login")
+		}
+		token = strings.TrimSpace(string(data))
+	}
+
+	endpoint, err := url.JoinPath(addr, "v1", path)
+	if err != nil {
+		return "", fmt.Errorf("invalid VAULT_ADDR: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(ctx, secretTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return "", fmt.Errorf("invalid Vault request: %w", err)
+	}
+	req.Header.Set("X-Vault-Token", token)
+	if namespace := os.Getenv("VAULT_NAMESPACE"); namespace != "" {
+		req.Header.Set("X-Vault-Namespace", namespace)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to read Vault secret %s: %w", path, err)
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Data   map[string]any `json:"data"`
+		Errors []string       `json:"errors"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil && resp.StatusCode == http.StatusOK {
+		return "", fmt.Errorf("failed to decode Vault secret %s: %w", path, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to read Vault secret %s: %s %s",
+			path, resp.Status, strings.Join(body.Errors, "; "))
+	}
+
+	// KV version 2 nests the fields in a second data object
+	data := body.Data
+	if nested, ok := data["data"].(map[string]any); ok {
+		if _, versioned := data["metadata"]; versioned {
+			data = nested
+		}
+	}
+	value, ok := data[field].(string)
+	if !ok {
+		return "", fmt.Errorf("no string field %s in Vault secret %s", field, path)
+	}
+	return value, nil
+}
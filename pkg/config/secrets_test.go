@@ -0,0 +1,178 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// resetSecretCache empties the secrets cached by earlier tests
+func resetSecretCache(t *testing.T) {
+	t.Helper()
+	secretCache.Lock()
+	secretCache.values = make(map[string]string)
+	secretCache.Unlock()
+}
+
+// fakeVault serves the secrets by API path to requests with token, and
+// counts the requests
+func fakeVault(t *testing.T, token string, secrets map[string]any) *atomic.Int32 {
+	t.Helper()
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if r.Header.Get("X-Vault-Token") != token {
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string]any{"errors": []string{"permission denied"}})
+			return
+		}
+		secret, ok := secrets[strings.TrimPrefix(r.URL.Path, "/v1/")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]any{"errors": []string{}})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"data": secret})
+	}))
+	t.Cleanup(server.Close)
+	t.Setenv("VAULT_ADDR", server.URL)
+	t.Setenv("VAULT_TOKEN", token)
+	return &requests
+}
+
+func TestResolveSecret(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("secret commands are Unix tools")
+	}
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "db"), "s3cret\n")
+	writeFile(t, filepath.Join(dir, "crlf"), "s3cret \r\n")
+	t.Setenv("DB_PASS", "from-env")
+	t.Setenv("SECRETS_DIR", dir)
+	fakeVault(t, "token", map[string]any{
+		// KV version 2
+		"secret/data/dbbackup": map[string]any{
+			"data":     map[string]any{"password": "from-vault"},
+			"metadata": map[string]any{"version": 3},
+		},
+		// KV version 1, whose secrets may have a data field of their own
+		"kv/dbbackup": map[string]any{"password": "from-vault-v1", "data": map[string]any{}},
+	})
+
+	tests := []struct {
+		in      string
+		want    string
+		wantErr string
+	}{
+		{in: "postgres", want: "postgres"},
+		{in: "https://example.com", want: "https://example.com"},
+		{in: "env:DB_PASS", want: "from-env"},
+		{in: "env:DB_PASS_UNSET", wantErr: "environment variable DB_PASS_UNSET is not set"},
+		{in: "file:" + filepath.Join(dir, "db"), want: "s3cret"},
+		{in: "file:$SECRETS_DIR/crlf", want: "s3cret "},
+		{in: "file:" + filepath.Join(dir, "missing"), wantErr: "failed to read secret file"},
+		{in: "exec:echo  from-exec", want: "from-exec"},
+		{in: "exec:false", wantErr: "secret command false failed"},
+		{in: "exec:", wantErr: "empty secret command"},
+		{in: "vault:secret/data/dbbackup#password", want: "from-vault"},
+		{in: "vault:kv/dbbackup#password", want: "from-vault-v1"},
+		{in: "vault:secret/data/dbbackup#user", wantErr: "no string field user in Vault secret secret/data/dbbackup"},
+		{in: "vault:secret/data/other#password", wantErr: "failed to read Vault secret secret/data/other: 404"},
+		{in: "vault:secret/data/dbbackup", wantErr: "must be of the form path#field"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			resetSecretCache(t)
+			got, err := ResolveSecret(context.Background(), tt.in)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("ResolveSecret(%q) = %q, %v, want an error containing %q", tt.in, got, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("ResolveSecret(%q) = %q, %v, want %q", tt.in, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestVaultSecretToken(t *testing.T) {
+	resetSecretCache(t)
+	fakeVault(t, "file-token", map[string]any{"kv/db": map[string]any{"password": "p"}})
+
+	// Without VAULT_TOKEN, the token of vault login is used
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("VAULT_TOKEN", "")
+	writeFile(t, filepath.Join(home, ".vault-token"), "file-token\n")
+	if got, err := ResolveSecret(context.Background(), "vault:kv/db#password"); err != nil || got != "p" {
+		t.Errorf("ResolveSecret with ~/.vault-token = %q, %v", got, err)
+	}
+
+	resetSecretCache(t)
+	t.Setenv("VAULT_TOKEN", "wrong")
+	_, err := ResolveSecret(context.Background(), "vault:kv/db#password")
+	if err == nil || !strings.Contains(err.Error(), "403 Forbidden permission denied") {
+		t.Errorf("ResolveSecret with a wrong token returned %v", err)
+	}
+}
+
+func TestResolveSecretCache(t *testing.T) {
+	resetSecretCache(t)
+	requests := fakeVault(t, "token", map[string]any{"kv/db": map[string]any{"password": "p"}})
+	for range 3 {
+		if got, err := ResolveSecret(context.Background(), "vault:kv/db#password"); err != nil || got != "p" {
+			t.Fatalf("ResolveSecret = %q, %v", got, err)
+		}
+	}
+	if n := requests.Load(); n != 1 {
+		t.Errorf("Vault was queried %d times, want once", n)
+	}
+
+	// Failures are not cached. Setenv restores the variable after the test.
+	t.Setenv("DB_PASS", "")
+	os.Unsetenv("DB_PASS")
+	if _, err := ResolveSecret(context.Background(), "env:DB_PASS"); err == nil {
+		t.Fatal("ResolveSecret of an unset variable succeeded")
+	}
+	t.Setenv("DB_PASS", "set later")
+	if got, err := ResolveSecret(context.Background(), "env:DB_PASS"); err != nil || got != "set later" {
+		t.Errorf("ResolveSecret after a failure = %q, %v", got, err)
+	}
+}
+
+func TestResolveSecrets(t *testing.T) {
+	resetSecretCache(t)
+	t.Setenv("DB_PASS", "from-env")
+	t.Setenv("TEAM", "data")
+	cfg := Config{
+		Database: DatabaseConfig{Host: "db.internal", Password: "env:DB_PASS", Username: "env:DB_USER_UNSET"},
+		Storages: []StorageConfig{{Type: "s3", Bucket: "env:BUCKET_UNSET"}},
+		Labels:   map[string]string{"team": "env:TEAM", "env": "prod"},
+	}
+
+	err := ResolveSecrets(context.Background(), &cfg)
+	// Every reference that cannot be resolved is reported, by path
+	for _, want := range []string{
+		"database.username: environment variable DB_USER_UNSET is not set",
+		"storages[0].bucket: environment variable BUCKET_UNSET is not set",
+	} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("ResolveSecrets returned %v, want an error containing %q", err, want)
+		}
+	}
+	if cfg.Database.Password != "from-env" || cfg.Database.Host != "db.internal" {
+		t.Errorf("database is %+v", cfg.Database)
+	}
+	if cfg.Labels["team"] != "data" || cfg.Labels["env"] != "prod" {
+		t.Errorf("labels are %v", cfg.Labels)
+	}
+}
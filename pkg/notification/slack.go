@@ -1,6 +1,10 @@
 package notification
 
 import (
+	"errors"
+	"fmt"
+	"net/url"
+
 	"github.com/slack-go/slack"
 )
 
@@ -16,5 +20,11 @@ func (s *SlackNotifier) Notify(message string) error {
 	msg := &slack.WebhookMessage{
 		Text: message,
 	}
-	return slack.PostWebhook(s.webhookURL, msg)
+	err := slack.PostWebhook(s.webhookURL, msg)
+	// HTTP client errors include the webhook URL, which is a secret
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return fmt.Errorf("failed to post to Slack webhook: %w", urlErr.Err)
+	}
+	return err
 }
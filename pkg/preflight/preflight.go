@@ -0,0 +1,145 @@
+package preflight
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/yeboahd24/dbBackupUitility/pkg/backup"
+)
+
+// ErrUnsupported is returned by FreeSpace on platforms where free disk space
+// cannot be determined
+var ErrUnsupported = errors.New("not supported on this platform")
+
+// Result is the outcome of a single check
+type Result struct {
+	Name   string
+	Detail string // what was found, e.g. the free space
+	Err    error  // nil when the check passed
+	Hint   string // how to fix a failed check
+
+	// Warning marks a failed check that does not fail the report
+	Warning bool
+}
+
+// Report collects the results of a series of checks
+type Report struct {
+	Results []Result
+}
+
+// Add records a check result
+func (r *Report) Add(result Result) {
+	r.Results = append(r.Results, result)
+}
+
+// Failed reports whether any check failed
+func (r *Report) Failed() bool {
+	for _, result := range r.Results {
+		if result.Err != nil && !result.Warning {
+			return true
+		}
+	}
+	return false
+}
+
+// Print writes the results as a checklist
+func (r *Report) Print(w io.Writer) {
+	for _, result := range r.Results {
+		status := "ok"
+		detail := result.Detail
+		if result.Err != nil {
+			status = "FAIL"
+			if result.Warning {
+				status = "WARN"
+			}
+			detail = result.Err.Error()
+		}
+		if detail != "" {
+			fmt.Fprintf(w, "  [%-4s] %s: %s\n", status, result.Name, detail)
+		} else {
+			fmt.Fprintf(w, "  [%-4s] %s\n", status, result.Name)
+		}
+		if result.Err != nil && result.Hint != "" {
+			fmt.Fprintf(w, "         hint: %s\n", result.Hint)
+		}
+	}
+}
+
+// FreeSpace returns the bytes available to the current user on the file
+// system holding path. Paths that do not exist yet are resolved to their
+// closest existing parent.
+func FreeSpace(path string) (int64, error) {
+	dir, err := filepath.Abs(path)
+	if err != nil {
+		return 0, err
+	}
+	for {
+		if _, err := os.Stat(dir); err == nil {
+			break
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return 0, fmt.Errorf("no existing parent directory of %s", path)
+		}
+		dir = parent
+	}
+	return freeSpace(dir)
+}
+
+// ProbeStorage checks that the storage accepts writes and deletes by storing
+// and removing a small object
+func ProbeStorage(ctx context.Context, store backup.StorageProvider) error {
+	return probe(ctx, store, false)
+}
+
+// RoundTrip checks that the storage accepts writes, reads and deletes by
+// storing a small object, reading it back and removing it
+func RoundTrip(ctx context.Context, store backup.StorageProvider) error {
+	return probe(ctx, store, true)
+}
+
+func probe(ctx context.Context, store backup.StorageProvider, readBack bool) error {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	name := ".dbbackup-probe-" + hex.EncodeToString(b)
+	content := []byte("dbbackup probe " + hex.EncodeToString(b) + "\n")
+
+	if err := store.Store(ctx, name, bytes.NewReader(content)); err != nil {
+		return fmt.Errorf("failed to write probe object: %w", err)
+	}
+
+	var readErr error
+	if readBack {
+		readErr = readProbe(ctx, store, name, content)
+	}
+	// Delete even when reading failed, so no probe object is left behind
+	if err := store.Delete(ctx, name); err != nil {
+		return errors.Join(readErr, fmt.Errorf("failed to delete probe object %s: %w", name, err))
+	}
+	return readErr
+}
+
+func readProbe(ctx context.Context, store backup.StorageProvider, name string, content []byte) error {
+	r, err := store.Retrieve(ctx, name)
+	if err != nil {
+		return fmt.Errorf("failed to read probe object: %w", err)
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("failed to read probe object: %w", err)
+	}
+	if !bytes.Equal(data, content) {
+		return fmt.Errorf("probe object read back differs from what was written")
+	}
+	return nil
+}
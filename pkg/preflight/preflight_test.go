@@ -0,0 +1,124 @@
+package preflight
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/yeboahd24/dbBackupUitility/pkg/backup"
+	"github.com/yeboahd24/dbBackupUitility/pkg/storage"
+)
+
+func TestReport(t *testing.T) {
+	var r Report
+	r.Add(Result{Name: "pg_dump", Detail: "16.2"})
+	r.Add(Result{Name: "spool space", Err: errors.New("1.0 GiB free"), Hint: "free up space", Warning: true})
+	if r.Failed() {
+		t.Error("a report with only a warning failed")
+	}
+	r.Add(Result{Name: "storage s3", Err: errors.New("access denied"), Hint: "check the bucket policy"})
+	if !r.Failed() {
+		t.Error("a report with a failed check passed")
+	}
+
+	var out bytes.Buffer
+	r.Print(&out)
+	want := `  [ok  ] pg_dump: 16.2
+  [WARN] spool space: 1.0 GiB free
+         hint: free up space
+  [FAIL] storage s3: access denied
+         hint: check the bucket policy
+`
+	if out.String() != want {
+		t.Errorf("Print wrote\n%s\nwant\n%s", out.String(), want)
+	}
+}
+
+func TestRoundTripLocalStorage(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	store, err := storage.NewLocalStorage(dir, storage.LocalOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := RoundTrip(ctx, store); err != nil {
+		t.Fatal(err)
+	}
+	if err := ProbeStorage(ctx, store); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("probes left %d objects behind", len(entries))
+	}
+}
+
+// corruptStorage stores objects that read back differently and can refuse
+// deletes
+type corruptStorage struct {
+	names     []string
+	deleteErr error
+}
+
+func (c *corruptStorage) Store(ctx context.Context, name string, data io.Reader) error {
+	c.names = append(c.names, name)
+	_, err := io.Copy(io.Discard, data)
+	return err
+}
+
+func (c *corruptStorage) Retrieve(ctx context.Context, name string) (io.ReadCloser, error) {
+	return io.NopCloser(strings.NewReader("garbage")), nil
+}
+
+func (c *corruptStorage) List(ctx context.Context, prefix string) ([]backup.ObjectInfo, error) {
+	return nil, nil
+}
+
+func (c *corruptStorage) Delete(ctx context.Context, name string) error {
+	return c.deleteErr
+}
+
+func TestRoundTripErrors(t *testing.T) {
+	ctx := context.Background()
+	store := &corruptStorage{}
+	if err := RoundTrip(ctx, store); err == nil || !strings.Contains(err.Error(), "differs from what was written") {
+		t.Errorf("RoundTrip of corrupting storage returned %v", err)
+	}
+	// Only the write and delete are checked
+	if err := ProbeStorage(ctx, store); err != nil {
+		t.Errorf("ProbeStorage returned %v", err)
+	}
+
+	store.deleteErr = errors.New("access denied")
+	err := RoundTrip(ctx, store)
+	for _, want := range []string{"differs from what was written", "failed to delete probe object", "access denied"} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("RoundTrip returned %v, want an error containing %q", err, want)
+		}
+	}
+	if len(store.names) != 3 || store.names[0] == store.names[1] {
+		t.Errorf("probe objects were named %v, want a new name each time", store.names)
+	}
+}
+
+func TestFreeSpace(t *testing.T) {
+	dir := t.TempDir()
+	free, err := FreeSpace(filepath.Join(dir, "not", "created", "yet"))
+	if errors.Is(err, ErrUnsupported) {
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if free <= 0 {
+		t.Errorf("FreeSpace returned %d bytes", free)
+	}
+}
@@ -0,0 +1,265 @@
+package progress
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultInterval is the time between progress lines when the output is not
+// a terminal
+const DefaultInterval = 30 * time.Second
+
+// redrawInterval is the time between updates of a terminal progress bar
+const redrawInterval = 500 * time.Millisecond
+
+const barWidth = 30
+
+// Options controls how progress is reported
+type Options struct {
+	Disabled bool
+	Interval time.Duration // between progress lines when not on a terminal
+	Out      *os.File      // defaults to stdout
+}
+
+// Progress counts the bytes passing through a stream and periodically reports
+// them with the throughput and, when the total is known, the percentage done
+// and an ETA. On a terminal a progress bar is redrawn in place; otherwise a
+// line is printed at every interval, which also suits log files.
+type Progress struct {
+	label    string
+	total    int64 // estimated, 0 when unknown
+	out      io.Writer
+	tty      bool
+	interval time.Duration
+	disabled bool
+
+	n      atomic.Int64
+	start  time.Time
+	stop   chan struct{}
+	wg     sync.WaitGroup
+	finish sync.Once
+
+	mu       sync.Mutex // serializes output and guards counters
+	counters []*Counter
+}
+
+// Counter is a byte count shown next to the progress of the stream, such as
+// the bytes uploaded to one destination
+type Counter struct {
+	label string
+	n     atomic.Int64
+}
+
+func NewCounter(label string) *Counter {
+	return &Counter{label: label}
+}
+
+// Add counts n more bytes
+func (c *Counter) Add(n int64) {
+	c.n.Add(n)
+}
+
+// Load returns the bytes counted so far
+func (c *Counter) Load() int64 {
+	return c.n.Load()
+}
+
+// active is the progress being reported, through which Printf prints
+var active atomic.Pointer[Progress]
+
+// Printf prints a status line. While progress is redrawn in place on a
+// terminal, the line is printed above it rather than into it.
+func Printf(format string, args ...any) {
+	p := active.Load()
+	if p == nil || !p.tty {
+		fmt.Printf(format, args...)
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	fmt.Fprint(p.out, "\r\033[K")
+	fmt.Fprintf(p.out, format, args...)
+	fmt.Fprintf(p.out, "\r\033[K%s", p.line())
+}
+
+// New returns a progress reporter for a stream of about total bytes, or of
+// unknown size when total is 0
+func New(label string, total int64, opts Options) *Progress {
+	out := opts.Out
+	if out == nil {
+		out = os.Stdout
+	}
+	interval := opts.Interval
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	tty := isTerminal(out)
+	if tty {
+		interval = redrawInterval
+	}
+	return &Progress{
+		label:    label,
+		total:    total,
+		out:      out,
+		tty:      tty,
+		interval: interval,
+		disabled: opts.Disabled,
+	}
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// Reader counts the bytes read from r. The progress finishes when r is read
+// to the end.
+func (p *Progress) Reader(r io.Reader) io.Reader {
+	return &countingReader{r: r, p: p}
+}
+
+// Track adds counters to the progress line
+func (p *Progress) Track(counters ...*Counter) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.counters = append(p.counters, counters...)
+}
+
+// Start begins periodic reporting
+func (p *Progress) Start() {
+	p.start = time.Now()
+	if p.disabled {
+		return
+	}
+	active.Store(p)
+	p.stop = make(chan struct{})
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-p.stop:
+				return
+			case <-ticker.C:
+				p.report()
+			}
+		}
+	}()
+}
+
+// Finish stops reporting and prints a summary of the transfer. Only the
+// first call has an effect.
+func (p *Progress) Finish() {
+	if p.disabled || p.stop == nil {
+		return
+	}
+	p.finish.Do(p.summarize)
+}
+
+func (p *Progress) summarize() {
+	close(p.stop)
+	p.wg.Wait()
+	active.CompareAndSwap(p, nil)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n := p.n.Load()
+	elapsed := time.Since(p.start)
+	if p.tty {
+		fmt.Fprint(p.out, "\r\033[K")
+	}
+	fmt.Fprintf(p.out, "%s: %s in %s (%s/s)%s\n",
+		p.label, FormatSize(n), elapsed.Round(time.Second), FormatSize(rate(n, elapsed)), p.counts())
+}
+
+func (p *Progress) report() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.tty {
+		fmt.Fprintf(p.out, "\r\033[K%s", p.line())
+	} else {
+		fmt.Fprintln(p.out, p.line())
+	}
+}
+
+// counts formats the counters for the progress line
+func (p *Progress) counts() string {
+	var b strings.Builder
+	for i, c := range p.counters {
+		sep := ", "
+		if i == 0 {
+			sep = "; "
+		}
+		fmt.Fprintf(&b, "%s%s %s", sep, c.label, FormatSize(c.n.Load()))
+	}
+	return b.String()
+}
+
+// line formats the current progress
+func (p *Progress) line() string {
+	n := p.n.Load()
+	elapsed := time.Since(p.start)
+	speed := rate(n, elapsed)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s: %s", p.label, FormatSize(n))
+	if p.total > 0 {
+		// The total is an estimate, so never claim completion early
+		fraction := min(float64(n)/float64(p.total), 0.99)
+		fmt.Fprintf(&b, " of ~%s (%d%%)", FormatSize(p.total), int(fraction*100))
+		if p.tty {
+			filled := int(fraction * barWidth)
+			fmt.Fprintf(&b, " [%s%s]", strings.Repeat("=", filled), strings.Repeat(" ", barWidth-filled))
+		}
+	}
+	fmt.Fprintf(&b, ", %s/s", FormatSize(speed))
+	if p.total > n && speed > 0 {
+		eta := time.Duration(float64(p.total-n) / float64(speed) * float64(time.Second))
+		fmt.Fprintf(&b, ", ETA %s", eta.Round(time.Second))
+	}
+	b.WriteString(p.counts())
+	return b.String()
+}
+
+// rate returns the average bytes per second
+func rate(n int64, elapsed time.Duration) int64 {
+	if elapsed <= 0 {
+		return 0
+	}
+	return int64(float64(n) / elapsed.Seconds())
+}
+
+type countingReader struct {
+	r io.Reader
+	p *Progress
+}
+
+func (c *countingReader) Read(b []byte) (int, error) {
+	n, err := c.r.Read(b)
+	c.p.n.Add(int64(n))
+	if err == io.EOF {
+		c.p.Finish()
+	}
+	return n, err
+}
+
+// FormatSize formats a byte count for display, e.g. 1.5 GiB
+func FormatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
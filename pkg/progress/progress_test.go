@@ -0,0 +1,78 @@
+package progress
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormatSize(t *testing.T) {
+	tests := []struct {
+		n    int64
+		want string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1024, "1.0 KiB"},
+		{1536, "1.5 KiB"},
+		{5 << 30, "5.0 GiB"},
+		{3 << 50, "3.0 PiB"},
+	}
+	for _, tt := range tests {
+		if got := FormatSize(tt.n); got != tt.want {
+			t.Errorf("FormatSize(%d) = %q, want %q", tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestProgressCounters(t *testing.T) {
+	out, err := os.Create(filepath.Join(t.TempDir(), "progress.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+
+	p := New("Backup", 4096, Options{Out: out, Interval: time.Hour})
+	uploaded := NewCounter("s3 uploaded")
+	compressed := NewCounter("repo uploaded (compressed)")
+	p.Track(uploaded, compressed)
+	p.Start()
+
+	r := p.Reader(strings.NewReader(strings.Repeat("x", 2048)))
+	io.CopyN(io.Discard, r, 1024)
+	uploaded.Add(1024)
+	compressed.Add(100)
+
+	line := p.line()
+	for _, want := range []string{"Backup: 1.0 KiB of ~4.0 KiB (25%)", "; s3 uploaded 1.0 KiB, repo uploaded (compressed) 100 B"} {
+		if !strings.Contains(line, want) {
+			t.Errorf("progress line %q lacks %q", line, want)
+		}
+	}
+
+	// Reading to the end finishes the progress with a summary
+	io.Copy(io.Discard, r)
+	if active.Load() != nil {
+		t.Error("finished progress is still active")
+	}
+	summary, err := os.ReadFile(out.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(summary), "Backup: 2.0 KiB in ") || !strings.Contains(string(summary), "; s3 uploaded 1.0 KiB") {
+		t.Errorf("summary is %q", summary)
+	}
+}
+
+func TestProgressDisabled(t *testing.T) {
+	p := New("Restore", 0, Options{Disabled: true})
+	p.Start()
+	io.Copy(io.Discard, p.Reader(strings.NewReader("data")))
+	p.Finish()
+	if active.Load() != nil {
+		t.Error("disabled progress is active")
+	}
+}
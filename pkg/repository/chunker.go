@@ -0,0 +1,113 @@
+package repository
+
+import (
+	"io"
+)
+
+// Chunk size bounds. Cut points are content defined, so an insertion in the
+// dump only changes the chunks around it instead of shifting all that follow.
+const (
+	minChunkSize = 512 << 10
+	avgChunkSize = 1 << 20
+	maxChunkSize = 4 << 20
+)
+
+// Gear hash masks over the high bits, which depend on the last 64 bytes. A
+// stricter mask before the average size and a looser one after it keep chunk
+// sizes close to the average (normalized chunking).
+const (
+	maskStrict = uint64(1<<22-1) << (64 - 22)
+	maskLoose  = uint64(1<<18-1) << (64 - 18)
+)
+
+var gear [256]uint64
+
+func init() {
+	// Fixed seed: cut points must be stable across runs for deduplication
+	seed := uint64(0x9e3779b97f4a7c15)
+	for i := range gear {
+		seed += 0x9e3779b97f4a7c15
+		z := seed
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		gear[i] = z ^ (z >> 31)
+	}
+}
+
+// Chunker splits a stream into content-defined chunks
+type Chunker struct {
+	r          io.Reader
+	buf        []byte
+	start, end int
+	eof        bool
+}
+
+func NewChunker(r io.Reader) *Chunker {
+	return &Chunker{r: r, buf: make([]byte, 2*maxChunkSize)}
+}
+
+// Next returns the next chunk, which is only valid until the following call,
+// or io.EOF once the stream is exhausted
+func (c *Chunker) Next() ([]byte, error) {
+	if err := c.fill(); err != nil {
+		return nil, err
+	}
+	if c.start == c.end {
+		return nil, io.EOF
+	}
+
+	n := cutPoint(c.buf[c.start:c.end])
+	chunk := c.buf[c.start : c.start+n]
+	c.start += n
+	return chunk, nil
+}
+
+// fill tops up the buffer until it holds at least maxChunkSize bytes or the
+// stream ends
+func (c *Chunker) fill() error {
+	if c.eof || c.end-c.start >= maxChunkSize {
+		return nil
+	}
+	c.end = copy(c.buf, c.buf[c.start:c.end])
+	c.start = 0
+
+	n, err := io.ReadFull(c.r, c.buf[c.end:])
+	c.end += n
+	switch err {
+	case nil:
+		return nil
+	case io.EOF, io.ErrUnexpectedEOF:
+		c.eof = true
+		return nil
+	default:
+		return err
+	}
+}
+
+// cutPoint returns the length of the first chunk of data
+func cutPoint(data []byte) int {
+	n := len(data)
+	if n <= minChunkSize {
+		return n
+	}
+	if n > maxChunkSize {
+		n = maxChunkSize
+	}
+	normal := min(avgChunkSize, n)
+
+	var h uint64
+	i := minChunkSize
+	for ; i < normal; i++ {
+		h = (h << 1) + gear[data[i]]
+		if h&maskStrict == 0 {
+			return i + 1
+		}
+	}
+	for ; i < n; i++ {
+		h = (h << 1) + gear[data[i]]
+		if h&maskLoose == 0 {
+			return i + 1
+		}
+	}
+	return n
+}
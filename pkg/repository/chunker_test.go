@@ -0,0 +1,126 @@
+package repository
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+// randomData returns n reproducible pseudo-random bytes
+func randomData(seed int64, n int) []byte {
+	data := make([]byte, n)
+	rand.New(rand.NewSource(seed)).Read(data)
+	return data
+}
+
+// chunks splits data and returns copies of its chunks
+func chunks(t *testing.T, data []byte) [][]byte {
+	t.Helper()
+	var out [][]byte
+	c := NewChunker(bytes.NewReader(data))
+	for {
+		chunk, err := c.Next()
+		if err == io.EOF {
+			return out
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, bytes.Clone(chunk))
+	}
+}
+
+func TestChunkerEmpty(t *testing.T) {
+	if _, err := NewChunker(bytes.NewReader(nil)).Next(); err != io.EOF {
+		t.Errorf("Next on an empty stream returned %v, want io.EOF", err)
+	}
+}
+
+func TestChunkerSmallInput(t *testing.T) {
+	data := randomData(1, minChunkSize/2)
+	got := chunks(t, data)
+	if len(got) != 1 || !bytes.Equal(got[0], data) {
+		t.Errorf("input below the minimum chunk size was split into %d chunks", len(got))
+	}
+}
+
+func TestChunkerSizes(t *testing.T) {
+	data := randomData(2, 24<<20)
+	got := chunks(t, data)
+	if joined := bytes.Join(got, nil); !bytes.Equal(joined, data) {
+		t.Fatal("chunks do not reassemble the input")
+	}
+	for i, chunk := range got {
+		if len(chunk) > maxChunkSize {
+			t.Errorf("chunk %d is %d bytes, above the maximum", i, len(chunk))
+		}
+		if len(chunk) < minChunkSize && i != len(got)-1 {
+			t.Errorf("chunk %d is %d bytes, below the minimum", i, len(chunk))
+		}
+	}
+	// Content-defined cuts average around avgChunkSize on random data
+	if avg := len(data) / len(got); avg < avgChunkSize/2 || avg > 2*avgChunkSize {
+		t.Errorf("average chunk size is %d bytes, want about %d", avg, avgChunkSize)
+	}
+}
+
+func TestChunkerUniformInput(t *testing.T) {
+	// No cut point is ever found in constant data, so chunks are cut at the
+	// maximum size
+	data := make([]byte, 3*maxChunkSize+1)
+	got := chunks(t, data)
+	if len(got) != 4 || len(got[0]) != maxChunkSize || len(got[3]) != 1 {
+		sizes := make([]int, len(got))
+		for i, chunk := range got {
+			sizes[i] = len(chunk)
+		}
+		t.Errorf("chunk sizes are %v, want three of %d and one of 1", sizes, maxChunkSize)
+	}
+}
+
+// An insertion near the start only changes the chunks around it, so the rest
+// is deduplicated against the original
+func TestChunkerInsertionResynchronizes(t *testing.T) {
+	data := randomData(3, 16<<20)
+	shifted := append(append(randomData(4, 1000), data[:100]...), data[100:]...)
+
+	seen := make(map[[32]byte]bool)
+	for _, chunk := range chunks(t, data) {
+		seen[sha256.Sum256(chunk)] = true
+	}
+	after := chunks(t, shifted)
+	shared := 0
+	for _, chunk := range after {
+		if seen[sha256.Sum256(chunk)] {
+			shared++
+		}
+	}
+	if shared < len(after)-2 {
+		t.Errorf("only %d of %d chunks survive a 1000 byte insertion", shared, len(after))
+	}
+}
+
+type failingReader struct {
+	data []byte
+	err  error
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, r.err
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestChunkerReadError(t *testing.T) {
+	want := errors.New("connection reset")
+	c := NewChunker(&failingReader{data: randomData(5, 1000), err: want})
+	if _, err := c.Next(); !errors.Is(err, want) {
+		t.Errorf("Next returned %v, want the read error", err)
+	}
+}
@@ -0,0 +1,313 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yeboahd24/dbBackupUitility/pkg/backup"
+	"github.com/yeboahd24/dbBackupUitility/pkg/compression"
+	"github.com/yeboahd24/dbBackupUitility/pkg/storage"
+)
+
+const (
+	chunkPrefix = "chunks/"
+	indexPrefix = "index/"
+
+	// Unreferenced chunks younger than this are kept by GC, as they may
+	// belong to a backup whose index has not been written yet
+	gcGracePeriod = 24 * time.Hour
+)
+
+// Index lists the chunks a stored object is made of
+type Index struct {
+	Name      string     `json:"name"`
+	Size      int64      `json:"size"`
+	CreatedAt time.Time  `json:"created_at"`
+	Chunks    []ChunkRef `json:"chunks"`
+}
+
+// ChunkRef identifies a chunk by the SHA-256 of its uncompressed content
+type ChunkRef struct {
+	Hash string `json:"hash"`
+	Size int64  `json:"size"`
+}
+
+// Repository stores objects on an underlying StorageProvider as
+// content-defined chunks, each kept once by hash and compressed, plus a
+// per-object index used to reassemble them. It implements StorageProvider
+// itself, so it can be used wherever a plain storage is expected.
+type Repository struct {
+	store      backup.StorageProvider
+	compressor backup.Compressor
+
+	mu    sync.Mutex
+	known map[string]bool // chunks present in the store, loaded on first use
+}
+
+func New(store backup.StorageProvider) *Repository {
+	return &Repository{
+		store:      store,
+		compressor: compression.NewGzipCompressor(),
+	}
+}
+
+func chunkKey(hash string) string {
+	return chunkPrefix + hash[:2] + "/" + hash
+}
+
+func indexKey(name string) string {
+	return indexPrefix + name
+}
+
+// Store splits data into chunks, uploads the chunks not yet in the repository
+// and finally writes the index, so a failed store never leaves a partial object
+func (r *Repository) Store(ctx context.Context, name string, data io.Reader) error {
+	return r.storeIndexed(ctx, name, data, false)
+}
+
+// Replace stores like Store, replacing an existing index of the same name
+func (r *Repository) Replace(ctx context.Context, name string, data io.Reader) error {
+	return r.storeIndexed(ctx, name, data, true)
+}
+
+func (r *Repository) storeIndexed(ctx context.Context, name string, data io.Reader, replace bool) error {
+	known, err := r.knownChunks(ctx)
+	if err != nil {
+		return err
+	}
+
+	index := Index{Name: name, CreatedAt: time.Now()}
+	chunker := NewChunker(data)
+	for {
+		chunk, err := chunker.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read data: %w", err)
+		}
+
+		sum := sha256.Sum256(chunk)
+		hash := hex.EncodeToString(sum[:])
+		index.Chunks = append(index.Chunks, ChunkRef{Hash: hash, Size: int64(len(chunk))})
+		index.Size += int64(len(chunk))
+
+		r.mu.Lock()
+		exists := known[hash]
+		r.mu.Unlock()
+		if exists {
+			continue
+		}
+
+		compressed, err := r.compress(chunk)
+		if err != nil {
+			return fmt.Errorf("failed to compress chunk: %w", err)
+		}
+		// Chunks are content addressed, so one stored concurrently is identical
+		if err := r.store.Store(ctx, chunkKey(hash), bytes.NewReader(compressed)); err != nil && !errors.Is(err, storage.ErrExists) {
+			return fmt.Errorf("failed to store chunk %s: %w", hash, err)
+		}
+
+		r.mu.Lock()
+		known[hash] = true
+		r.mu.Unlock()
+	}
+
+	encoded, err := json.Marshal(index)
+	if err != nil {
+		return fmt.Errorf("failed to encode index: %w", err)
+	}
+	store := r.store.Store
+	if replace {
+		store = func(ctx context.Context, name string, data io.Reader) error {
+			return backup.Replace(ctx, r.store, name, data)
+		}
+	}
+	if err := store(ctx, indexKey(name), bytes.NewReader(encoded)); err != nil {
+		return fmt.Errorf("failed to store index: %w", err)
+	}
+	return nil
+}
+
+// Retrieve reassembles an object from its chunks, verifying each one
+func (r *Repository) Retrieve(ctx context.Context, name string) (io.ReadCloser, error) {
+	index, err := r.readIndex(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return &chunkReader{ctx: ctx, repo: r, chunks: index.Chunks}, nil
+}
+
+// List returns the objects stored in the repository with their original size
+func (r *Repository) List(ctx context.Context, prefix string) ([]backup.ObjectInfo, error) {
+	indexes, err := r.store.List(ctx, indexKey(prefix))
+	if err != nil {
+		return nil, err
+	}
+
+	objects := make([]backup.ObjectInfo, 0, len(indexes))
+	for _, obj := range indexes {
+		name := strings.TrimPrefix(obj.Key, indexPrefix)
+		index, err := r.readIndex(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, backup.ObjectInfo{
+			Key:     name,
+			Size:    index.Size,
+			ModTime: obj.ModTime,
+		})
+	}
+	return objects, nil
+}
+
+// Delete removes the object's index. Its chunks are reclaimed by GC once no
+// other index references them.
+func (r *Repository) Delete(ctx context.Context, name string) error {
+	return r.store.Delete(ctx, indexKey(name))
+}
+
+// GC deletes chunks no longer referenced by any index and returns how many
+// were removed. It should not run concurrently with a backup to the same
+// repository.
+func (r *Repository) GC(ctx context.Context) (int, error) {
+	indexes, err := r.store.List(ctx, indexPrefix)
+	if err != nil {
+		return 0, err
+	}
+	referenced := make(map[string]bool)
+	for _, obj := range indexes {
+		// Any unreadable index aborts GC rather than risk deleting its chunks
+		index, err := r.readIndex(ctx, strings.TrimPrefix(obj.Key, indexPrefix))
+		if err != nil {
+			return 0, err
+		}
+		for _, chunk := range index.Chunks {
+			referenced[chunk.Hash] = true
+		}
+	}
+
+	chunks, err := r.store.List(ctx, chunkPrefix)
+	if err != nil {
+		return 0, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.known = nil
+
+	removed := 0
+	for _, obj := range chunks {
+		hash := obj.Key[strings.LastIndex(obj.Key, "/")+1:]
+		if referenced[hash] || time.Since(obj.ModTime) < gcGracePeriod {
+			continue
+		}
+		if err := r.store.Delete(ctx, obj.Key); err != nil {
+			return removed, fmt.Errorf("failed to delete chunk %s: %w", hash, err)
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// compress compresses a chunk in memory, so that its upload can be retried
+func (r *Repository) compress(chunk []byte) ([]byte, error) {
+	compressed, err := r.compressor.Compress(bytes.NewReader(chunk))
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(compressed)
+}
+
+func (r *Repository) knownChunks(ctx context.Context) (map[string]bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.known != nil {
+		return r.known, nil
+	}
+
+	chunks, err := r.store.List(ctx, chunkPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list chunks: %w", err)
+	}
+	r.known = make(map[string]bool, len(chunks))
+	for _, obj := range chunks {
+		r.known[obj.Key[strings.LastIndex(obj.Key, "/")+1:]] = true
+	}
+	return r.known, nil
+}
+
+func (r *Repository) readIndex(ctx context.Context, name string) (*Index, error) {
+	rc, err := r.store.Retrieve(ctx, indexKey(name))
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve index of %s: %w", name, err)
+	}
+	defer rc.Close()
+
+	var index Index
+	if err := json.NewDecoder(rc).Decode(&index); err != nil {
+		return nil, fmt.Errorf("failed to decode index of %s: %w", name, err)
+	}
+	return &index, nil
+}
+
+// readChunk fetches, decompresses and verifies a single chunk
+func (r *Repository) readChunk(ctx context.Context, ref ChunkRef) ([]byte, error) {
+	rc, err := r.store.Retrieve(ctx, chunkKey(ref.Hash))
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve chunk %s: %w", ref.Hash, err)
+	}
+	defer rc.Close()
+
+	decompressed, err := r.compressor.Decompress(rc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress chunk %s: %w", ref.Hash, err)
+	}
+	data, err := io.ReadAll(decompressed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read chunk %s: %w", ref.Hash, err)
+	}
+
+	sum := sha256.Sum256(data)
+	if hex.EncodeToString(sum[:]) != ref.Hash {
+		return nil, fmt.Errorf("chunk %s is corrupt", ref.Hash)
+	}
+	return data, nil
+}
+
+// chunkReader streams an object by fetching its chunks in order
+type chunkReader struct {
+	ctx    context.Context
+	repo   *Repository
+	chunks []ChunkRef
+	cur    bytes.Reader
+}
+
+func (c *chunkReader) Read(p []byte) (int, error) {
+	for c.cur.Len() == 0 {
+		if len(c.chunks) == 0 {
+			return 0, io.EOF
+		}
+		data, err := c.repo.readChunk(c.ctx, c.chunks[0])
+		if err != nil {
+			return 0, err
+		}
+		c.chunks = c.chunks[1:]
+		c.cur.Reset(data)
+	}
+	return c.cur.Read(p)
+}
+
+func (c *chunkReader) Close() error {
+	c.chunks = nil
+	return nil
+}
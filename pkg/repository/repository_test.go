@@ -0,0 +1,122 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/yeboahd24/dbBackupUitility/pkg/storage"
+)
+
+func newTestRepository(t *testing.T) (*Repository, *storage.LocalStorage) {
+	t.Helper()
+	store, err := storage.NewLocalStorage(t.TempDir(), storage.LocalOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return New(store), store
+}
+
+func retrieve(t *testing.T, repo *Repository, name string) []byte {
+	t.Helper()
+	rc, err := repo.Retrieve(context.Background(), name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func countChunks(t *testing.T, store *storage.LocalStorage) int {
+	t.Helper()
+	objects, err := store.List(context.Background(), chunkPrefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(objects)
+}
+
+func TestRepositoryRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	repo, _ := newTestRepository(t)
+	data := randomData(10, 5<<20)
+	if err := repo.Store(ctx, "db.dump", bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	if got := retrieve(t, repo, "db.dump"); !bytes.Equal(got, data) {
+		t.Fatal("retrieved data differs from the stored data")
+	}
+
+	objects, err := repo.List(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 1 || objects[0].Key != "db.dump" || objects[0].Size != int64(len(data)) {
+		t.Errorf("List returned %+v, want db.dump of %d bytes", objects, len(data))
+	}
+}
+
+func TestRepositoryDeduplicates(t *testing.T) {
+	ctx := context.Background()
+	repo, store := newTestRepository(t)
+	data := randomData(11, 8<<20)
+	if err := repo.Store(ctx, "monday.dump", bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	first := countChunks(t, store)
+
+	// A day's changes append to the dump
+	tuesday := append(bytes.Clone(data), randomData(12, 1<<20)...)
+	if err := repo.Store(ctx, "tuesday.dump", bytes.NewReader(tuesday)); err != nil {
+		t.Fatal(err)
+	}
+	if added := countChunks(t, store) - first; added > 3 {
+		t.Errorf("storing a dump with 1 MiB appended added %d chunks", added)
+	}
+	if got := retrieve(t, repo, "tuesday.dump"); !bytes.Equal(got, tuesday) {
+		t.Error("retrieved data differs from the stored data")
+	}
+}
+
+func TestRepositoryReplace(t *testing.T) {
+	ctx := context.Background()
+	repo, _ := newTestRepository(t)
+	if err := repo.Store(ctx, "db.dump", strings.NewReader("old")); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Store(ctx, "db.dump", strings.NewReader("new")); err == nil {
+		t.Fatal("Store replaced an existing object on no-clobber storage")
+	}
+	if err := repo.Replace(ctx, "db.dump", strings.NewReader("new")); err != nil {
+		t.Fatal(err)
+	}
+	if got := retrieve(t, repo, "db.dump"); string(got) != "new" {
+		t.Errorf("retrieved %q after Replace, want %q", got, "new")
+	}
+}
+
+func TestRepositoryGC(t *testing.T) {
+	ctx := context.Background()
+	repo, store := newTestRepository(t)
+	if err := repo.Store(ctx, "db.dump", bytes.NewReader(randomData(13, 2<<20))); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Delete(ctx, "db.dump"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Chunks of a deleted object are kept during the grace period
+	removed, err := repo.GC(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 0 || countChunks(t, store) == 0 {
+		t.Errorf("GC removed %d chunks within the grace period", removed)
+	}
+}
@@ -0,0 +1,151 @@
+package retry
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+type httpError int
+
+func (e httpError) Error() string       { return fmt.Sprintf("HTTP %d", int(e)) }
+func (e httpError) HTTPStatusCode() int { return int(e) }
+
+type sqlError string
+
+func (e sqlError) Error() string    { return "SQLSTATE " + string(e) }
+func (e sqlError) SQLState() string { return string(e) }
+
+type selfClassified bool
+
+func (e selfClassified) Error() string   { return "self classified" }
+func (e selfClassified) Retryable() bool { return bool(e) }
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"plain error", errors.New("invalid bucket name"), false},
+		{"transient", Transient(errors.New("invalid bucket name")), true},
+		{"permanent", Permanent(io.ErrUnexpectedEOF), false},
+		{"permanent wins over transient", Permanent(Transient(io.ErrUnexpectedEOF)), false},
+		{"canceled", fmt.Errorf("upload: %w", context.Canceled), false},
+		{"deadline exceeded", context.DeadlineExceeded, false},
+		{"unexpected EOF", fmt.Errorf("read: %w", io.ErrUnexpectedEOF), true},
+		{"bad connection", driver.ErrBadConn, true},
+		{"connection refused", os.NewSyscallError("connect", syscall.ECONNREFUSED), true},
+		{"connection reset", syscall.ECONNRESET, true},
+		{"broken pipe", syscall.EPIPE, true},
+		{"HTTP 500", httpError(500), true},
+		{"HTTP 503 wrapped", fmt.Errorf("put object: %w", httpError(503)), true},
+		{"HTTP 429", httpError(429), true},
+		{"HTTP 408", httpError(408), true},
+		{"HTTP 403", httpError(403), false},
+		{"HTTP 404", httpError(404), false},
+		{"connection exception", sqlError("08006"), true},
+		{"admin shutdown", sqlError("57P01"), true},
+		{"too many connections", sqlError("53300"), true},
+		{"insufficient privilege", sqlError("42501"), false},
+		{"self retryable", selfClassified(true), true},
+		{"self not retryable", selfClassified(false), false},
+		{"dial error", &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("no route to host")}, true},
+		{"DNS error", &net.DNSError{Err: "no such host", Name: "db.example.com"}, true},
+		{"network timeout", timeoutError{}, true},
+		{"file not found", &fs.PathError{Op: "open", Path: "/backups/x", Err: fs.ErrNotExist}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsRetryable(tt.err); got != tt.want {
+				t.Errorf("IsRetryable(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDo(t *testing.T) {
+	ctx := context.Background()
+	policy := Policy{MaxAttempts: 3, BaseDelay: time.Millisecond}
+	transient := Transient(errors.New("unavailable"))
+
+	tests := []struct {
+		name         string
+		errs         []error // returned by successive attempts, then nil
+		wantAttempts int
+		wantErr      bool
+	}{
+		{"succeeds at once", nil, 1, false},
+		{"succeeds on retry", []error{transient, transient}, 3, false},
+		{"attempts exhausted", []error{transient, transient, transient}, 3, true},
+		{"not retryable", []error{errors.New("access denied")}, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			err := Do(ctx, policy, func() error {
+				attempts++
+				if attempts <= len(tt.errs) {
+					return tt.errs[attempts-1]
+				}
+				return nil
+			})
+			if attempts != tt.wantAttempts {
+				t.Errorf("made %d attempts, want %d", attempts, tt.wantAttempts)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Do returned %v", err)
+			}
+		})
+	}
+}
+
+func TestDoStopsWhenCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	policy := Policy{MaxAttempts: 5, BaseDelay: time.Hour}
+	attempts := 0
+	err := Do(ctx, policy, func() error {
+		attempts++
+		cancel()
+		return Transient(errors.New("unavailable"))
+	})
+	if err == nil || attempts != 1 {
+		t.Errorf("Do made %d attempts and returned %v after cancellation", attempts, err)
+	}
+}
+
+func TestDelay(t *testing.T) {
+	p := Policy{BaseDelay: time.Second, MaxDelay: 5 * time.Second}
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i, w := range want {
+		if got := p.delay(i + 1); got != w {
+			t.Errorf("delay(%d) = %v, want %v", i+1, got, w)
+		}
+	}
+	// The shift overflows after many attempts
+	if got := p.delay(80); got != p.MaxDelay {
+		t.Errorf("delay(80) = %v, want %v", got, p.MaxDelay)
+	}
+
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if d := p.delay(1); d < 500*time.Millisecond || d > 1500*time.Millisecond {
+			t.Fatalf("delay with 50%% jitter is %v, want within 0.5s of 1s", d)
+		}
+	}
+}
@@ -0,0 +1,312 @@
+package spool
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/yeboahd24/dbBackupUitility/pkg/backup"
+	"github.com/yeboahd24/dbBackupUitility/pkg/config"
+)
+
+// ErrFull is returned by Add when a backup does not fit within the spool's
+// size limit
+var ErrFull = errors.New("spool size limit reached")
+
+// ErrLocked is returned by Lock when another process is uploading the entry
+var ErrLocked = errors.New("spool entry is locked")
+
+const (
+	dataFile  = "data"
+	queueFile = "queue.json"
+	lockFile  = "lock"
+)
+
+// Entry is a spooled backup together with the destinations it still has to
+// be uploaded to. Its queue state is persisted next to the data, so pending
+// uploads survive a restart.
+type Entry struct {
+	ID        string                  `json:"-"`
+	Name      string                  `json:"name"`
+	Manifest  *backup.Manifest        `json:"manifest"`
+	Pending   []string                `json:"pending"`   // destination names not yet uploaded to
+	Overrides config.StorageOverrides `json:"overrides"` // per-run storage settings of the backup
+	Attempts  int                     `json:"attempts"`
+	LastError string                  `json:"last_error,omitempty"`
+
+	dir string
+}
+
+// DataPath returns the path of the spooled backup
+func (e *Entry) DataPath() string {
+	return filepath.Join(e.dir, dataFile)
+}
+
+// Size returns the size of the spooled backup
+func (e *Entry) Size() int64 {
+	if e.Manifest == nil {
+		return 0
+	}
+	return e.Manifest.Size
+}
+
+// Spool is a local directory backups are written to before they are uploaded.
+// Each backup gets its own subdirectory holding the data, its queue state and,
+// while it is being written or uploaded, a lock file.
+type Spool struct {
+	dir     string
+	maxSize int64 // 0 for no limit
+}
+
+func Open(dir string, maxSize int64) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create spool directory: %w", err)
+	}
+	return &Spool{dir: dir, maxSize: maxSize}, nil
+}
+
+// Add writes data to a new entry and returns it locked. The entry only becomes
+// visible to Entries once it has been saved.
+func (s *Spool) Add(name string, data io.Reader) (*Entry, error) {
+	used, _, err := s.Usage()
+	if err != nil {
+		return nil, err
+	}
+	if s.maxSize > 0 && used >= s.maxSize {
+		return nil, fmt.Errorf("%w: %d of %d bytes in use", ErrFull, used, s.maxSize)
+	}
+
+	id, err := newID()
+	if err != nil {
+		return nil, err
+	}
+	e := &Entry{ID: id, Name: name, dir: filepath.Join(s.dir, id)}
+	if err := os.Mkdir(e.dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create spool entry: %w", err)
+	}
+	if err := s.Lock(e); err != nil {
+		os.RemoveAll(e.dir)
+		return nil, err
+	}
+
+	if err := s.writeData(e, data, used); err != nil {
+		os.RemoveAll(e.dir)
+		return nil, err
+	}
+	return e, nil
+}
+
+func (s *Spool) writeData(e *Entry, data io.Reader, used int64) error {
+	f, err := os.OpenFile(e.DataPath(), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create spool file: %w", err)
+	}
+	defer f.Close()
+
+	var w io.Writer = f
+	if s.maxSize > 0 {
+		w = &limitWriter{w: f, remaining: s.maxSize - used}
+	}
+	if _, err := io.Copy(w, data); err != nil {
+		return fmt.Errorf("failed to write spool file: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("failed to sync spool file: %w", err)
+	}
+	return f.Close()
+}
+
+// Save persists the entry's queue state
+func (s *Spool) Save(e *Entry) error {
+	data, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode spool entry: %w", err)
+	}
+
+	// Write and rename, so a crash never leaves a truncated queue file
+	path := filepath.Join(e.dir, queueFile)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write spool entry: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to write spool entry: %w", err)
+	}
+	return nil
+}
+
+// Remove deletes the entry and its data
+func (s *Spool) Remove(e *Entry) error {
+	if err := os.RemoveAll(e.dir); err != nil {
+		return fmt.Errorf("failed to remove spool entry %s: %w", e.ID, err)
+	}
+	return nil
+}
+
+// Entries returns the saved entries, oldest first
+func (s *Spool) Entries() ([]*Entry, error) {
+	dirs, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read spool directory: %w", err)
+	}
+
+	var entries []*Entry
+	for _, d := range dirs {
+		if !d.IsDir() {
+			continue
+		}
+		e := &Entry{ID: d.Name(), dir: filepath.Join(s.dir, d.Name())}
+		data, err := os.ReadFile(filepath.Join(e.dir, queueFile))
+		if errors.Is(err, os.ErrNotExist) {
+			// Still being written, or abandoned by a crash
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read spool entry %s: %w", e.ID, err)
+		}
+		if err := json.Unmarshal(data, e); err != nil {
+			return nil, fmt.Errorf("failed to decode spool entry %s: %w", e.ID, err)
+		}
+		entries = append(entries, e)
+	}
+
+	// IDs start with the creation time
+	sort.Slice(entries, func(i, j int) bool { return entries[i].ID < entries[j].ID })
+	return entries, nil
+}
+
+// Clean removes entries that were never saved because the backup writing them
+// was interrupted, and returns how many were removed
+func (s *Spool) Clean() (int, error) {
+	dirs, err := os.ReadDir(s.dir)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read spool directory: %w", err)
+	}
+
+	removed := 0
+	for _, d := range dirs {
+		if !d.IsDir() {
+			continue
+		}
+		e := &Entry{ID: d.Name(), dir: filepath.Join(s.dir, d.Name())}
+		if _, err := os.Stat(filepath.Join(e.dir, queueFile)); !errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err := s.Lock(e); err != nil {
+			continue
+		}
+		if err := s.Remove(e); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// Usage returns the bytes used by the spool and its number of entries
+func (s *Spool) Usage() (int64, int, error) {
+	dirs, err := os.ReadDir(s.dir)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to read spool directory: %w", err)
+	}
+
+	var used int64
+	count := 0
+	for _, d := range dirs {
+		if !d.IsDir() {
+			continue
+		}
+		count++
+		if info, err := os.Stat(filepath.Join(s.dir, d.Name(), dataFile)); err == nil {
+			used += info.Size()
+		}
+	}
+	return used, count, nil
+}
+
+// Lock marks the entry as in use by this process. Locks left behind by a
+// process that no longer runs are taken over.
+func (s *Spool) Lock(e *Entry) error {
+	path := filepath.Join(e.dir, lockFile)
+	for {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err == nil {
+			_, err = f.WriteString(strconv.Itoa(os.Getpid()))
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				os.Remove(path)
+				return fmt.Errorf("failed to lock spool entry %s: %w", e.ID, err)
+			}
+			return nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return fmt.Errorf("failed to lock spool entry %s: %w", e.ID, err)
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to lock spool entry %s: %w", e.ID, err)
+		}
+		if pid, err := strconv.Atoi(strings.TrimSpace(string(data))); err == nil && processAlive(pid) {
+			return fmt.Errorf("%s: %w", e.ID, ErrLocked)
+		}
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove stale lock of spool entry %s: %w", e.ID, err)
+		}
+	}
+}
+
+// Unlock releases a lock taken with Lock
+func (s *Spool) Unlock(e *Entry) {
+	os.Remove(filepath.Join(e.dir, lockFile))
+}
+
+func processAlive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	if runtime.GOOS == "windows" {
+		// FindProcess only succeeds for running processes on Windows
+		return true
+	}
+	err = p.Signal(syscall.Signal(0))
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+// newID returns a unique entry ID that sorts by creation time
+func newID() (string, error) {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate spool entry id: %w", err)
+	}
+	return time.Now().UTC().Format("20060102T150405") + "-" + hex.EncodeToString(b), nil
+}
+
+// limitWriter fails with ErrFull once more than the remaining bytes are written
+type limitWriter struct {
+	w         io.Writer
+	remaining int64
+}
+
+func (l *limitWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) > l.remaining {
+		return 0, ErrFull
+	}
+	n, err := l.w.Write(p)
+	l.remaining -= int64(n)
+	return n, err
+}
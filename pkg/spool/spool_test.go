@@ -0,0 +1,155 @@
+package spool
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/yeboahd24/dbBackupUitility/pkg/backup"
+)
+
+func openSpool(t *testing.T, maxSize int64) *Spool {
+	t.Helper()
+	s, err := Open(filepath.Join(t.TempDir(), "spool"), maxSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestSpoolQueue(t *testing.T) {
+	s := openSpool(t, 0)
+
+	e, err := s.Add("db.dump", strings.NewReader("backup data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(e.DataPath()); err != nil || string(data) != "backup data" {
+		t.Fatalf("spooled data is %q, %v", data, err)
+	}
+
+	// Hidden until saved
+	entries, err := s.Entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("Entries returned %d unsaved entries", len(entries))
+	}
+
+	e.Manifest = &backup.Manifest{Name: "db.dump", Size: 11}
+	e.Pending = []string{"s3", "local"}
+	e.Attempts = 2
+	if err := s.Save(e); err != nil {
+		t.Fatal(err)
+	}
+	s.Unlock(e)
+
+	entries, err = s.Entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("Entries returned %d entries, want 1", len(entries))
+	}
+	got := entries[0]
+	if got.ID != e.ID || got.Name != "db.dump" || got.Size() != 11 || got.Attempts != 2 ||
+		strings.Join(got.Pending, ",") != "s3,local" || got.DataPath() != e.DataPath() {
+		t.Errorf("Entries returned %+v, want the saved entry", got)
+	}
+
+	used, count, err := s.Usage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if used != 11 || count != 1 {
+		t.Errorf("Usage returned %d bytes in %d entries, want 11 bytes in 1", used, count)
+	}
+
+	if err := s.Remove(got); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := s.Entries(); len(entries) != 0 {
+		t.Errorf("Entries returned %d entries after Remove", len(entries))
+	}
+}
+
+func TestSpoolSizeLimit(t *testing.T) {
+	s := openSpool(t, 10)
+
+	if _, err := s.Add("big.dump", strings.NewReader(strings.Repeat("x", 11))); !errors.Is(err, ErrFull) {
+		t.Fatalf("Add beyond the limit returned %v, want ErrFull", err)
+	}
+	if used, count, _ := s.Usage(); used != 0 || count != 0 {
+		t.Errorf("a rejected backup left %d bytes in %d entries", used, count)
+	}
+
+	if _, err := s.Add("a.dump", strings.NewReader(strings.Repeat("x", 10))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Add("b.dump", strings.NewReader("x")); !errors.Is(err, ErrFull) {
+		t.Errorf("Add to a full spool returned %v, want ErrFull", err)
+	}
+}
+
+func TestSpoolLock(t *testing.T) {
+	s := openSpool(t, 0)
+	e, err := s.Add("db.dump", strings.NewReader("data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Held by this process
+	if err := s.Lock(e); !errors.Is(err, ErrLocked) {
+		t.Fatalf("Lock of a locked entry returned %v, want ErrLocked", err)
+	}
+
+	// Left behind by a process that no longer runs
+	if err := os.WriteFile(filepath.Join(e.dir, lockFile), []byte("999999999"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Lock(e); err != nil {
+		t.Fatalf("stale lock was not taken over: %v", err)
+	}
+}
+
+func TestSpoolClean(t *testing.T) {
+	s := openSpool(t, 0)
+
+	saved, err := s.Add("saved.dump", strings.NewReader("data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Save(saved); err != nil {
+		t.Fatal(err)
+	}
+	s.Unlock(saved)
+
+	writing, err := s.Add("writing.dump", strings.NewReader("data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	abandoned, err := s.Add("abandoned.dump", strings.NewReader("data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Unlock(abandoned)
+
+	removed, err := s.Clean()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 1 {
+		t.Errorf("Clean removed %d entries, want 1", removed)
+	}
+	if _, err := os.Stat(abandoned.dir); !errors.Is(err, os.ErrNotExist) {
+		t.Error("Clean kept the abandoned entry")
+	}
+	for _, e := range []*Entry{saved, writing} {
+		if _, err := os.Stat(e.DataPath()); err != nil {
+			t.Errorf("Clean removed %s: %v", e.Name, err)
+		}
+	}
+}
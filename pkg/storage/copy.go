@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/yeboahd24/dbBackupUitility/pkg/backup"
+)
+
+// Copy streams the named backup from src to dst together with its manifest,
+// if it has one, and verifies the stored copy by reading it back
+func Copy(ctx context.Context, src, dst backup.StorageProvider, name string) error {
+	manifest, err := backup.ReadManifest(ctx, src, name)
+	if errors.Is(err, backup.ErrNotFound) {
+		manifest = nil
+	} else if err != nil {
+		return err
+	}
+
+	r, err := src.Retrieve(ctx, name)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve %s: %w", name, err)
+	}
+	defer r.Close()
+
+	digest := backup.NewDigest(r)
+	// Copies replace what dst holds under the name, which InSync found to differ
+	if err := backup.Replace(ctx, dst, name, digest); err != nil {
+		return fmt.Errorf("failed to store %s: %w", name, err)
+	}
+	if manifest != nil && manifest.SHA256 != digest.Sum() {
+		return discardCopy(ctx, dst, name, fmt.Errorf("%s does not match its manifest checksum on the source", name))
+	}
+
+	stored, err := backup.Checksum(ctx, dst, name)
+	if err != nil {
+		return fmt.Errorf("failed to verify copy: %w", err)
+	}
+	if stored != digest.Sum() {
+		return discardCopy(ctx, dst, name, fmt.Errorf("verification of %s failed: checksum mismatch after copy", name))
+	}
+
+	if manifest != nil {
+		if err := backup.WriteManifest(ctx, dst, manifest); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// discardCopy deletes a bad copy from dst and returns err, with the error of
+// the deletion if it failed
+func discardCopy(ctx context.Context, dst backup.StorageProvider, name string, err error) error {
+	if delErr := dst.Delete(ctx, name); delErr != nil {
+		return fmt.Errorf("%w; failed to delete the bad copy: %v", err, delErr)
+	}
+	return err
+}
+
+// InSync reports whether dst already holds a copy of the named backup with
+// the same checksum. When both sides have a manifest, only the manifests are
+// compared: the data on dst is not read, so a copy damaged after it was
+// written goes unnoticed until it is verified.
+func InSync(ctx context.Context, src, dst backup.StorageProvider, name string) (bool, error) {
+	var want string
+	if m, err := backup.ReadManifest(ctx, src, name); err == nil {
+		want = m.SHA256
+	} else if want, err = backup.Checksum(ctx, src, name); err != nil {
+		return false, err
+	}
+
+	if m, err := backup.ReadManifest(ctx, dst, name); err == nil && m.SHA256 == want {
+		return true, nil
+	}
+
+	got, err := backup.Checksum(ctx, dst, name)
+	if err != nil {
+		return false, err
+	}
+	return got == want, nil
+}
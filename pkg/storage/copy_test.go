@@ -0,0 +1,97 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"strings"
+	"testing"
+
+	"github.com/yeboahd24/dbBackupUitility/pkg/backup"
+)
+
+// storeBackup stores data under name with a manifest recording sum
+func storeBackup(t *testing.T, store *memStorage, name, data, sum string) {
+	t.Helper()
+	if err := store.Store(context.Background(), name, strings.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	if sum == "" {
+		return
+	}
+	if err := backup.WriteManifest(context.Background(), store, &backup.Manifest{Name: name, SHA256: sum}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func sha256Of(s string) string {
+	d := backup.NewDigest(strings.NewReader(s))
+	d.Read(make([]byte, len(s)+1))
+	return d.Sum()
+}
+
+func TestCopy(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("with manifest", func(t *testing.T) {
+		src, dst := newMemStorage(), newMemStorage()
+		storeBackup(t, src, "db.dump", "data", sha256Of("data"))
+		if err := Copy(ctx, src, dst, "db.dump"); err != nil {
+			t.Fatal(err)
+		}
+		want := []string{"db.dump", backup.ManifestName("db.dump")}
+		if got := dst.names(); strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("dst holds %v, want %v", got, want)
+		}
+	})
+
+	t.Run("without manifest", func(t *testing.T) {
+		src, dst := newMemStorage(), newMemStorage()
+		storeBackup(t, src, "db.dump", "data", "")
+		if err := Copy(ctx, src, dst, "db.dump"); err != nil {
+			t.Fatal(err)
+		}
+		if got := dst.names(); len(got) != 1 || got[0] != "db.dump" {
+			t.Errorf("dst holds %v, want only db.dump", got)
+		}
+	})
+
+	t.Run("manifest unreadable", func(t *testing.T) {
+		src, dst := newMemStorage(), newMemStorage()
+		storeBackup(t, src, "db.dump", "data", sha256Of("data"))
+		src.retrieveErr = func(name string) error {
+			if backup.IsManifest(name) {
+				return fs.ErrPermission
+			}
+			return nil
+		}
+		err := Copy(ctx, src, dst, "db.dump")
+		if !errors.Is(err, fs.ErrPermission) {
+			t.Fatalf("Copy returned %v, want a permission error", err)
+		}
+		if got := dst.names(); len(got) != 0 {
+			t.Errorf("dst holds %v, want nothing", got)
+		}
+	})
+
+	t.Run("checksum mismatch", func(t *testing.T) {
+		src, dst := newMemStorage(), newMemStorage()
+		storeBackup(t, src, "db.dump", "data", sha256Of("other"))
+		if err := Copy(ctx, src, dst, "db.dump"); err == nil {
+			t.Fatal("Copy succeeded despite the checksum mismatch")
+		}
+		if got := dst.names(); len(got) != 0 {
+			t.Errorf("dst holds %v, want the bad copy deleted", got)
+		}
+	})
+
+	t.Run("cleanup failure reported", func(t *testing.T) {
+		src, dst := newMemStorage(), newMemStorage()
+		storeBackup(t, src, "db.dump", "data", sha256Of("other"))
+		dst.deleteErr = func(string) error { return errors.New("delete denied") }
+		err := Copy(ctx, src, dst, "db.dump")
+		if err == nil || !strings.Contains(err.Error(), "delete denied") {
+			t.Fatalf("Copy returned %v, want the delete error reported", err)
+		}
+	})
+}
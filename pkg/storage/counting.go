@@ -0,0 +1,61 @@
+package storage
+
+import (
+	"context"
+	"io"
+
+	"github.com/yeboahd24/dbBackupUitility/pkg/backup"
+)
+
+// CountingStorage reports the bytes stored on and retrieved from the
+// underlying storage, e.g. to show upload progress per destination. Wrapped
+// under volume splitting or a repository, it counts what is actually
+// transferred: volumes, or compressed and deduplicated chunks.
+type CountingStorage struct {
+	store backup.StorageProvider
+	count func(n int64)
+}
+
+func NewCountingStorage(store backup.StorageProvider, count func(n int64)) *CountingStorage {
+	return &CountingStorage{store: store, count: count}
+}
+
+func (c *CountingStorage) Store(ctx context.Context, name string, data io.Reader) error {
+	return c.store.Store(ctx, name, &countingReader{r: data, count: c.count})
+}
+
+func (c *CountingStorage) Replace(ctx context.Context, name string, data io.Reader) error {
+	return backup.Replace(ctx, c.store, name, &countingReader{r: data, count: c.count})
+}
+
+func (c *CountingStorage) Retrieve(ctx context.Context, name string) (io.ReadCloser, error) {
+	rc, err := c.store.Retrieve(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{&countingReader{r: rc, count: c.count}, rc}, nil
+}
+
+func (c *CountingStorage) List(ctx context.Context, prefix string) ([]backup.ObjectInfo, error) {
+	return c.store.List(ctx, prefix)
+}
+
+func (c *CountingStorage) Delete(ctx context.Context, name string) error {
+	return c.store.Delete(ctx, name)
+}
+
+type countingReader struct {
+	r     io.Reader
+	count func(n int64)
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	if n > 0 {
+		c.count(int64(n))
+	}
+	return n, err
+}
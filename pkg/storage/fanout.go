@@ -0,0 +1,138 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/yeboahd24/dbBackupUitility/pkg/backup"
+)
+
+// Policies deciding whether a fan-out store counts as successful
+const (
+	PolicyAll      = "all"
+	PolicyAny      = "any"
+	PolicyMajority = "majority"
+)
+
+// Target is a named storage destination
+type Target struct {
+	Name     string
+	Provider backup.StorageProvider
+}
+
+// Result is the outcome of storing a backup on a single Target
+type Result struct {
+	Name string
+	Err  error
+}
+
+var errStoppedReading = errors.New("destination stopped reading before the end of the backup")
+
+// StoreAll streams data to all targets concurrently, reading it only once. A
+// destination that fails is dropped from the stream without interrupting the
+// others, and its error is reported in its Result.
+func StoreAll(ctx context.Context, targets []Target, name string, data io.Reader) []Result {
+	results := make([]Result, len(targets))
+	writers := make([]*io.PipeWriter, len(targets))
+
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		pr, pw := io.Pipe()
+		writers[i] = pw
+		results[i].Name = target.Name
+
+		wg.Add(1)
+		go func(i int, target Target) {
+			defer wg.Done()
+			err := target.Provider.Store(ctx, name, pr)
+			if err == nil {
+				err = errStoppedReading
+			}
+			// Unblocks the writer if the destination gave up early. After a
+			// complete stream the writer is already closed and this is a no-op.
+			pr.CloseWithError(err)
+			results[i].Err = storeErr(err)
+		}(i, target)
+	}
+
+	failed := make([]error, len(targets))
+	buf := make([]byte, 32*1024)
+	for {
+		n, readErr := data.Read(buf)
+		if n > 0 {
+			for i, w := range writers {
+				if failed[i] != nil {
+					continue
+				}
+				if _, err := w.Write(buf[:n]); err != nil {
+					failed[i] = err
+				}
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			for i, w := range writers {
+				if failed[i] == nil {
+					failed[i] = readErr
+					w.CloseWithError(readErr)
+				}
+			}
+			break
+		}
+	}
+
+	for i, w := range writers {
+		if failed[i] == nil {
+			w.Close()
+		}
+	}
+	wg.Wait()
+
+	for i := range results {
+		if results[i].Err == nil && failed[i] != nil {
+			results[i].Err = failed[i]
+		}
+	}
+	return results
+}
+
+// storeErr maps the sentinel used to unblock the writer back to success
+func storeErr(err error) error {
+	if errors.Is(err, errStoppedReading) {
+		return nil
+	}
+	return err
+}
+
+// CheckPolicy reports whether enough destinations succeeded for the policy
+func CheckPolicy(policy string, results []Result) error {
+	succeeded := 0
+	for _, r := range results {
+		if r.Err == nil {
+			succeeded++
+		}
+	}
+
+	var required int
+	switch policy {
+	case "", PolicyAll:
+		required = len(results)
+	case PolicyAny:
+		required = 1
+	case PolicyMajority:
+		required = len(results)/2 + 1
+	default:
+		return fmt.Errorf("unsupported storage policy: %s", policy)
+	}
+
+	if succeeded < required {
+		return fmt.Errorf("backup stored on %d of %d destination(s), policy %q requires %d",
+			succeeded, len(results), policy, required)
+	}
+	return nil
+}
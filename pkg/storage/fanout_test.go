@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+// partialStorage reads only the start of a backup and reports success
+type partialStorage struct {
+	memStorage
+}
+
+func (p *partialStorage) Store(ctx context.Context, name string, data io.Reader) error {
+	_, err := io.CopyN(io.Discard, data, 10)
+	return err
+}
+
+func TestStoreAll(t *testing.T) {
+	good, other := newMemStorage(), newMemStorage()
+	failing := newMemStorage()
+	failing.storeErr = func(string) error { return errors.New("bucket not found") }
+
+	data := strings.Repeat("backup data ", 20000)
+	results := StoreAll(context.Background(), []Target{
+		{Name: "good", Provider: good},
+		{Name: "failing", Provider: failing},
+		{Name: "partial", Provider: &partialStorage{}},
+		{Name: "other", Provider: other},
+	}, "db.dump", strings.NewReader(data))
+
+	want := map[string]string{"good": "", "failing": "bucket not found", "partial": errStoppedReading.Error(), "other": ""}
+	for _, r := range results {
+		got := ""
+		if r.Err != nil {
+			got = r.Err.Error()
+		}
+		if got != want[r.Name] {
+			t.Errorf("%s: error %q, want %q", r.Name, got, want[r.Name])
+		}
+	}
+	// A failing destination does not interrupt the others
+	for _, m := range []*memStorage{good, other} {
+		if string(m.objects["db.dump"]) != data {
+			t.Errorf("stored %d bytes, want %d", len(m.objects["db.dump"]), len(data))
+		}
+	}
+}
+
+func TestStoreAllReadError(t *testing.T) {
+	readErr := errors.New("dump failed")
+	store := newMemStorage()
+	results := StoreAll(context.Background(), []Target{{Name: "s3", Provider: store}, {Name: "local", Provider: newMemStorage()}},
+		"db.dump", io.MultiReader(strings.NewReader("partial"), &errReader{readErr}))
+
+	for _, r := range results {
+		if !errors.Is(r.Err, readErr) {
+			t.Errorf("%s: error %v, want the read error", r.Name, r.Err)
+		}
+	}
+	if len(store.names()) != 0 {
+		t.Errorf("a failed backup was stored as %v", store.names())
+	}
+}
+
+type errReader struct{ err error }
+
+func (e *errReader) Read([]byte) (int, error) { return 0, e.err }
+
+func TestCheckPolicy(t *testing.T) {
+	fail := errors.New("failed")
+	results := func(failed int) []Result {
+		r := make([]Result, 3)
+		for i := range failed {
+			r[i].Err = fail
+		}
+		return r
+	}
+	tests := []struct {
+		policy string
+		failed int
+		ok     bool
+	}{
+		{"", 0, true},
+		{PolicyAll, 0, true},
+		{PolicyAll, 1, false},
+		{PolicyMajority, 1, true},
+		{PolicyMajority, 2, false},
+		{PolicyAny, 2, true},
+		{PolicyAny, 3, false},
+	}
+	for _, tt := range tests {
+		err := CheckPolicy(tt.policy, results(tt.failed))
+		if (err == nil) != tt.ok {
+			t.Errorf("CheckPolicy(%q) with %d of 3 failed returned %v", tt.policy, tt.failed, err)
+		}
+	}
+	if err := CheckPolicy("most", results(0)); err == nil || !strings.Contains(err.Error(), "unsupported storage policy") {
+		t.Errorf("CheckPolicy of an unknown policy returned %v", err)
+	}
+}
@@ -2,49 +2,214 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/yeboahd24/dbBackupUitility/pkg/backup"
 )
 
+// ErrExists is returned by Store when a backup with the same name is already
+// stored and overwriting is not enabled. Replace always overwrites.
+var ErrExists = errors.New("backup already exists")
+
+// tempSuffix marks in-progress writes, which are hidden from List
+const tempSuffix = ".tmp-"
+
+// LocalOptions controls how LocalStorage writes backups
+type LocalOptions struct {
+	// Overwrite allows Store to replace an existing backup of the same name
+	Overwrite bool
+}
+
 type LocalStorage struct {
 	basePath string
+	opts     LocalOptions
 }
 
-func NewLocalStorage(path string) (*LocalStorage, error) {
+func NewLocalStorage(path string, opts LocalOptions) (*LocalStorage, error) {
 	if err := os.MkdirAll(path, 0755); err != nil {
 		return nil, fmt.Errorf("failed to create storage directory: %w", err)
 	}
-	return &LocalStorage{basePath: path}, nil
+	return &LocalStorage{basePath: path, opts: opts}, nil
+}
+
+// path maps a slash-separated key to a file below the base path
+func (l *LocalStorage) path(name string) (string, error) {
+	clean := path.Clean("/" + name)
+	if clean == "/" {
+		return "", fmt.Errorf("invalid object name %q", name)
+	}
+	return filepath.Join(l.basePath, filepath.FromSlash(clean[1:])), nil
 }
 
+// Store writes the backup to a temporary file in the target directory and only
+// moves it into place once all data has been written and synced, so a failed
+// or interrupted write never leaves a truncated file under the final name.
 func (l *LocalStorage) Store(ctx context.Context, name string, data io.Reader) error {
-	path := filepath.Join(l.basePath, name)
-	file, err := os.Create(path)
+	return l.store(name, data, l.opts.Overwrite)
+}
+
+// Replace stores like Store but replaces an existing object even without
+// Overwrite, for manifests and copies that supersede what is stored
+func (l *LocalStorage) Replace(ctx context.Context, name string, data io.Reader) error {
+	return l.store(name, data, true)
+}
+
+func (l *LocalStorage) store(name string, data io.Reader, overwrite bool) error {
+	path, err := l.path(name)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(path)
+	if !overwrite {
+		if _, err := os.Lstat(path); err == nil {
+			return fmt.Errorf("%s: %w", name, ErrExists)
+		}
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+tempSuffix+"*")
 	if err != nil {
 		return fmt.Errorf("failed to create file: %w", err)
 	}
-	defer file.Close()
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
 
-	if _, err := io.Copy(file, data); err != nil {
+	if _, err := io.Copy(tmp, data); err != nil {
 		return fmt.Errorf("failed to write data: %w", err)
 	}
+	if err := tmp.Sync(); err != nil {
+		return fmt.Errorf("failed to sync file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close file: %w", err)
+	}
+
+	if err := commit(tmp.Name(), path, overwrite); err != nil {
+		if errors.Is(err, fs.ErrExist) {
+			return fmt.Errorf("%s: %w", name, ErrExists)
+		}
+		return fmt.Errorf("failed to move file into place: %w", err)
+	}
+	return syncDir(dir)
+}
+
+// commit moves the temporary file to its final name. Without overwrite, a hard
+// link is used so that a file created concurrently is never replaced.
+func commit(tmp, path string, overwrite bool) error {
+	if overwrite {
+		return os.Rename(tmp, path)
+	}
+
+	err := os.Link(tmp, path)
+	if err == nil || errors.Is(err, fs.ErrExist) {
+		return err
+	}
+	// Filesystems without hard link support
+	if _, statErr := os.Lstat(path); statErr == nil {
+		return fs.ErrExist
+	}
+	return os.Rename(tmp, path)
+}
+
+// syncDir makes a rename in dir durable
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		// Directories cannot be opened for syncing on Windows
+		return nil
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("failed to open directory: %w", err)
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("failed to sync directory: %w", err)
+	}
 	return nil
 }
 
 func (l *LocalStorage) Retrieve(ctx context.Context, name string) (io.ReadCloser, error) {
-	path := filepath.Join(l.basePath, name)
+	path, err := l.path(name)
+	if err != nil {
+		return nil, err
+	}
 	return os.Open(path)
 }
 
-func (l *LocalStorage) List(ctx context.Context) ([]string, error) {
-	var files []string
-	err := filepath.Walk(l.basePath, func(path string, info os.FileInfo, err error) error {
-		if !info.IsDir() {
-			files = append(files, filepath.Base(path))
+func (l *LocalStorage) List(ctx context.Context, prefix string) ([]backup.ObjectInfo, error) {
+	var objects []backup.ObjectInfo
+
+	// Only walk the directory the prefix points into
+	root := l.basePath
+	if dir := path.Dir(prefix); strings.Contains(prefix, "/") && dir != "." {
+		root = filepath.Join(l.basePath, filepath.FromSlash(dir))
+	}
+
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if p == root && errors.Is(err, fs.ErrNotExist) {
+				return filepath.SkipAll
+			}
+			return err
+		}
+		if d.IsDir() || isTempFile(p) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(l.basePath, p)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
 		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		objects = append(objects, backup.ObjectInfo{
+			Key:     key,
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+		})
 		return nil
 	})
-	return files, err
+	if err != nil {
+		return nil, fmt.Errorf("failed to list files: %w", err)
+	}
+
+	// WalkDir orders by path segment; keys are compared as whole strings
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].Key < objects[j].Key
+	})
+	return objects, nil
+}
+
+func (l *LocalStorage) Delete(ctx context.Context, name string) error {
+	path, err := l.path(name)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("failed to delete file: %w", err)
+	}
+	return nil
+}
+
+func isTempFile(path string) bool {
+	base := filepath.Base(path)
+	return strings.HasPrefix(base, ".") && strings.Contains(base, tempSuffix)
 }
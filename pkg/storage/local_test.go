@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/yeboahd24/dbBackupUitility/pkg/backup"
+)
+
+func readObject(t *testing.T, store backup.StorageProvider, name string) string {
+	t.Helper()
+	r, err := store.Retrieve(context.Background(), name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	b, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestLocalStorageOverwrite(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewLocalStorage(t.TempDir(), LocalOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Store(ctx, "db.dump", strings.NewReader("old")); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Store(ctx, "db.dump", strings.NewReader("new")); !errors.Is(err, ErrExists) {
+		t.Fatalf("Store over an existing backup returned %v, want ErrExists", err)
+	}
+	if got := readObject(t, store, "db.dump"); got != "old" {
+		t.Errorf("backup holds %q after a refused Store, want %q", got, "old")
+	}
+
+	if err := store.Replace(ctx, "db.dump", strings.NewReader("new")); err != nil {
+		t.Fatal(err)
+	}
+	if got := readObject(t, store, "db.dump"); got != "new" {
+		t.Errorf("backup holds %q after Replace, want %q", got, "new")
+	}
+}
+
+func TestLocalStorageRetrieveNotFound(t *testing.T) {
+	store, err := NewLocalStorage(t.TempDir(), LocalOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Retrieve(context.Background(), "missing"); !errors.Is(err, backup.ErrNotFound) {
+		t.Errorf("Retrieve of a missing object returned %v, want ErrNotFound", err)
+	}
+}
+
+// Copies and manifests replace what a no-clobber storage holds, through the
+// wrappers the commands stack on it
+func TestCopyReplacesOnLocalStorage(t *testing.T) {
+	ctx := context.Background()
+	local, err := NewLocalStorage(t.TempDir(), LocalOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst := NewVolumeStorage(NewRetryStorage(NewThrottleStorage(local), retryTestPolicy), "local", 4)
+	if err := dst.Store(ctx, "db.dump", strings.NewReader("stale data")); err != nil {
+		t.Fatal(err)
+	}
+	if err := backup.WriteManifest(ctx, dst, &backup.Manifest{Name: "db.dump", SHA256: sha256Of("stale data")}); err != nil {
+		t.Fatal(err)
+	}
+
+	src := newMemStorage()
+	storeBackup(t, src, "db.dump", "fresh data", sha256Of("fresh data"))
+	if err := Copy(ctx, src, dst, "db.dump"); err != nil {
+		t.Fatal(err)
+	}
+	if got := readObject(t, dst, "db.dump"); got != "fresh data" {
+		t.Errorf("dst holds %q, want %q", got, "fresh data")
+	}
+	m, err := backup.ReadManifest(ctx, dst, "db.dump")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.SHA256 != sha256Of("fresh data") {
+		t.Error("dst kept the stale manifest")
+	}
+}
@@ -0,0 +1,85 @@
+package storage
+
+import (
+	"context"
+	"io"
+
+	"github.com/yeboahd24/dbBackupUitility/pkg/backup"
+	"github.com/yeboahd24/dbBackupUitility/pkg/retry"
+)
+
+// RetryStorage retries failed operations on the underlying storage according
+// to a retry policy
+type RetryStorage struct {
+	store  backup.StorageProvider
+	policy retry.Policy
+}
+
+func NewRetryStorage(store backup.StorageProvider, policy retry.Policy) *RetryStorage {
+	return &RetryStorage{store: store, policy: policy}
+}
+
+// Store is only retried when data can be rewound to where the failed attempt
+// started reading, such as a spooled file. A stream that cannot be replayed
+// gets a single attempt, as a retry would upload a truncated backup.
+func (r *RetryStorage) Store(ctx context.Context, name string, data io.Reader) error {
+	return r.put(ctx, data, func() error {
+		return r.store.Store(ctx, name, data)
+	})
+}
+
+// Replace is retried like Store
+func (r *RetryStorage) Replace(ctx context.Context, name string, data io.Reader) error {
+	return r.put(ctx, data, func() error {
+		return backup.Replace(ctx, r.store, name, data)
+	})
+}
+
+// put runs a write of data, retrying it if data can be rewound
+func (r *RetryStorage) put(ctx context.Context, data io.Reader, write func() error) error {
+	seeker, ok := data.(io.Seeker)
+	if !ok {
+		return write()
+	}
+	start, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return write()
+	}
+
+	attempt := 0
+	return retry.Do(ctx, r.policy, func() error {
+		attempt++
+		if attempt > 1 {
+			if _, err := seeker.Seek(start, io.SeekStart); err != nil {
+				return retry.Permanent(err)
+			}
+		}
+		return write()
+	})
+}
+
+func (r *RetryStorage) Retrieve(ctx context.Context, name string) (io.ReadCloser, error) {
+	var rc io.ReadCloser
+	err := retry.Do(ctx, r.policy, func() error {
+		var err error
+		rc, err = r.store.Retrieve(ctx, name)
+		return err
+	})
+	return rc, err
+}
+
+func (r *RetryStorage) List(ctx context.Context, prefix string) ([]backup.ObjectInfo, error) {
+	var objects []backup.ObjectInfo
+	err := retry.Do(ctx, r.policy, func() error {
+		var err error
+		objects, err = r.store.List(ctx, prefix)
+		return err
+	})
+	return objects, err
+}
+
+func (r *RetryStorage) Delete(ctx context.Context, name string) error {
+	return retry.Do(ctx, r.policy, func() error {
+		return r.store.Delete(ctx, name)
+	})
+}
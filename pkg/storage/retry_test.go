@@ -0,0 +1,58 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yeboahd24/dbBackupUitility/pkg/retry"
+)
+
+// flakyStorage reads the whole object on every Store but fails the first ones
+type flakyStorage struct {
+	*memStorage
+	failures int
+	attempts int
+}
+
+func (f *flakyStorage) Store(ctx context.Context, name string, data io.Reader) error {
+	f.attempts++
+	if f.attempts <= f.failures {
+		io.Copy(io.Discard, data)
+		return retry.Transient(errors.New("connection reset"))
+	}
+	return f.memStorage.Store(ctx, name, data)
+}
+
+var retryStorePolicy = retry.Policy{MaxAttempts: 3, BaseDelay: time.Millisecond}
+
+func TestRetryStorageRewinds(t *testing.T) {
+	flaky := &flakyStorage{memStorage: newMemStorage(), failures: 2}
+	data := strings.NewReader("backup")
+	data.Seek(2, io.SeekStart) // retries resume from where the first attempt began
+
+	if err := NewRetryStorage(flaky, retryStorePolicy).Store(context.Background(), "db.dump", data); err != nil {
+		t.Fatal(err)
+	}
+	if flaky.attempts != 3 {
+		t.Errorf("made %d attempts, want 3", flaky.attempts)
+	}
+	if got := readObject(t, flaky, "db.dump"); got != "ckup" {
+		t.Errorf("stored %q, want %q", got, "ckup")
+	}
+}
+
+func TestRetryStorageStreamSingleAttempt(t *testing.T) {
+	// A stream cannot be replayed, so a retry would store a truncated backup
+	flaky := &flakyStorage{memStorage: newMemStorage(), failures: 1}
+	data := onlyReader{strings.NewReader("backup")}
+	if err := NewRetryStorage(flaky, retryStorePolicy).Store(context.Background(), "db.dump", data); err == nil {
+		t.Error("Store of a stream succeeded after a failed attempt")
+	}
+	if flaky.attempts != 1 {
+		t.Errorf("made %d attempts, want 1", flaky.attempts)
+	}
+}
@@ -2,37 +2,131 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"net/url"
+	"slices"
+	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/yeboahd24/dbBackupUitility/pkg/backup"
 )
 
+// ErrObjectLocked is returned by Delete when an object is still under an
+// S3 Object Lock retention period
+var ErrObjectLocked = errors.New("object is under retention")
+
+// S3Options holds the object settings applied to every upload
+type S3Options struct {
+	StorageClass         string
+	ServerSideEncryption string
+	KMSKeyID             string
+	Tags                 map[string]string
+
+	// Object Lock retention. RetainUntil takes precedence over RetainFor.
+	LockMode    string
+	RetainFor   time.Duration
+	RetainUntil time.Time
+}
+
+// Validate checks the object settings against those S3 supports
+func (o S3Options) Validate() error {
+	if o.StorageClass != "" && !slices.Contains(types.StorageClass("").Values(), types.StorageClass(o.StorageClass)) {
+		return fmt.Errorf("unsupported S3 storage class: %s", o.StorageClass)
+	}
+	if o.ServerSideEncryption != "" && !slices.Contains(types.ServerSideEncryption("").Values(), types.ServerSideEncryption(o.ServerSideEncryption)) {
+		return fmt.Errorf("unsupported S3 server-side encryption: %s", o.ServerSideEncryption)
+	}
+	if o.KMSKeyID != "" && o.ServerSideEncryption != string(types.ServerSideEncryptionAwsKms) &&
+		o.ServerSideEncryption != string(types.ServerSideEncryptionAwsKmsDsse) {
+		return fmt.Errorf("kms_key_id requires aws:kms server-side encryption")
+	}
+	if o.LockMode != "" {
+		if !slices.Contains(types.ObjectLockMode("").Values(), types.ObjectLockMode(o.LockMode)) {
+			return fmt.Errorf("unsupported S3 object lock mode: %s", o.LockMode)
+		}
+		if o.RetainFor <= 0 && o.RetainUntil.IsZero() {
+			return fmt.Errorf("object lock mode %s requires a retention period", o.LockMode)
+		}
+	}
+	return nil
+}
+
 type S3Storage struct {
-	client *s3.Client
-	bucket string
+	client   *s3.Client
+	uploader *manager.Uploader
+	bucket   string
+	opts     S3Options
 }
 
-func NewS3Storage(ctx context.Context, bucket, region string) (*S3Storage, error) {
-	cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(region))
+func NewS3Storage(ctx context.Context, bucket, region string, opts S3Options) (*S3Storage, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	// A single attempt per request: RetryStorage retries failed operations,
+	// and the SDK retrying them as well would multiply the attempts
+	cfg, err := config.LoadDefaultConfig(ctx,
+		config.WithRegion(region),
+		config.WithRetryMaxAttempts(1),
+	)
 	if err != nil {
 		return nil, fmt.Errorf("unable to load SDK config: %w", err)
 	}
 
-	client := s3.NewFromConfig(cfg)
+	return newS3Storage(s3.NewFromConfig(cfg), bucket, opts), nil
+}
+
+func newS3Storage(client *s3.Client, bucket string, opts S3Options) *S3Storage {
+	// Parts of 16 MiB hold backups of up to 160 GB in the 10,000 parts S3
+	// allows, with five parts buffered at a time
+	uploader := manager.NewUploader(client, func(u *manager.Uploader) {
+		u.PartSize = 16 << 20
+	})
 	return &S3Storage{
-		client: client,
-		bucket: bucket,
-	}, nil
+		client:   client,
+		uploader: uploader,
+		bucket:   bucket,
+		opts:     opts,
+	}
 }
 
+// Store uploads data in parts, as it is streamed from a dump, a fan-out pipe
+// or a rate limiter, of unknown length and not seekable, and may exceed the
+// 5 GB limit of a single PutObject
 func (s *S3Storage) Store(ctx context.Context, name string, data io.Reader) error {
-	_, err := s.client.PutObject(ctx, &s3.PutObjectInput{
-		Bucket: &s.bucket,
-		Key:    &name,
-		Body:   data,
-	})
+	input := &s3.PutObjectInput{
+		Bucket:               &s.bucket,
+		Key:                  &name,
+		Body:                 data,
+		StorageClass:         types.StorageClass(s.opts.StorageClass),
+		ServerSideEncryption: types.ServerSideEncryption(s.opts.ServerSideEncryption),
+	}
+	if s.opts.KMSKeyID != "" {
+		input.SSEKMSKeyId = aws.String(s.opts.KMSKeyID)
+	}
+	if len(s.opts.Tags) > 0 {
+		tags := url.Values{}
+		for k, v := range s.opts.Tags {
+			tags.Set(k, v)
+		}
+		input.Tagging = aws.String(tags.Encode())
+	}
+	if s.opts.LockMode != "" {
+		until := s.opts.RetainUntil
+		if until.IsZero() {
+			until = time.Now().Add(s.opts.RetainFor)
+		}
+		input.ObjectLockMode = types.ObjectLockMode(s.opts.LockMode)
+		input.ObjectLockRetainUntilDate = aws.Time(until.UTC())
+	}
+
+	_, err := s.uploader.Upload(ctx, input)
 	return err
 }
 
@@ -41,16 +135,21 @@ func (s *S3Storage) Retrieve(ctx context.Context, name string) (io.ReadCloser, e
 		Bucket: &s.bucket,
 		Key:    &name,
 	})
+	var noSuchKey *types.NoSuchKey
+	if errors.As(err, &noSuchKey) {
+		return nil, fmt.Errorf("%w: %s", backup.ErrNotFound, name)
+	}
 	if err != nil {
 		return nil, err
 	}
 	return result.Body, nil
 }
 
-func (s *S3Storage) List(ctx context.Context) ([]string, error) {
-	var files []string
+func (s *S3Storage) List(ctx context.Context, prefix string) ([]backup.ObjectInfo, error) {
+	var objects []backup.ObjectInfo
 	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
 		Bucket: &s.bucket,
+		Prefix: aws.String(prefix),
 	})
 
 	for paginator.HasMorePages() {
@@ -59,8 +158,45 @@ func (s *S3Storage) List(ctx context.Context) ([]string, error) {
 			return nil, err
 		}
 		for _, obj := range page.Contents {
-			files = append(files, *obj.Key)
+			objects = append(objects, backup.ObjectInfo{
+				Key:     aws.ToString(obj.Key),
+				Size:    aws.ToInt64(obj.Size),
+				ModTime: aws.ToTime(obj.LastModified),
+			})
 		}
 	}
-	return files, nil
+	return objects, nil
+}
+
+// Delete permanently removes the current version of an object. Objects whose
+// Object Lock retention has not yet expired are left in place and
+// ErrObjectLocked is returned, so callers can skip them instead of failing.
+func (s *S3Storage) Delete(ctx context.Context, name string) error {
+	head, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: &s.bucket,
+		Key:    &name,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to inspect object: %w", err)
+	}
+
+	if head.ObjectLockRetainUntilDate != nil && head.ObjectLockRetainUntilDate.After(time.Now()) {
+		return fmt.Errorf("%s locked until %s: %w",
+			name, head.ObjectLockRetainUntilDate.Format(time.RFC3339), ErrObjectLocked)
+	}
+	if head.ObjectLockLegalHoldStatus == types.ObjectLockLegalHoldStatusOn {
+		return fmt.Errorf("%s has a legal hold: %w", name, ErrObjectLocked)
+	}
+
+	// Deleting by version removes the data itself rather than adding a delete
+	// marker on versioned (and therefore all Object Lock enabled) buckets
+	_, err = s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket:    &s.bucket,
+		Key:       &name,
+		VersionId: head.VersionId,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete object: %w", err)
+	}
+	return nil
 }
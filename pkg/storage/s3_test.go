@@ -0,0 +1,167 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// fakeS3 serves the PutObject and multipart upload requests of a single
+// bucket and records the headers of the requests creating objects
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	parts   map[string]map[int][]byte // by upload ID and part number
+	headers map[string]http.Header    // of the PutObject or CreateMultipartUpload by key
+}
+
+func newFakeS3(t *testing.T) (*fakeS3, *s3.Client) {
+	t.Helper()
+	f := &fakeS3{
+		objects: make(map[string][]byte),
+		parts:   make(map[string]map[int][]byte),
+		headers: make(map[string]http.Header),
+	}
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+	client := s3.New(s3.Options{
+		BaseEndpoint: aws.String(server.URL),
+		UsePathStyle: true,
+		Region:       "us-east-1",
+		Credentials:  credentials.NewStaticCredentialsProvider("key", "secret", ""),
+	})
+	return f, client
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	// Path style: /bucket/key
+	_, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	query := r.URL.Query()
+	body, err := readBody(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		id := fmt.Sprint(len(f.parts) + 1)
+		f.parts[id] = make(map[int][]byte)
+		f.headers[key] = r.Header.Clone()
+		fmt.Fprintf(w, `<InitiateMultipartUploadResult><Bucket>bucket</Bucket><Key>%s</Key><UploadId>%s</UploadId></InitiateMultipartUploadResult>`, key, id)
+	case r.Method == http.MethodPut && query.Has("partNumber"):
+		n, _ := strconv.Atoi(query.Get("partNumber"))
+		f.parts[query.Get("uploadId")][n] = body
+		w.Header().Set("ETag", fmt.Sprintf(`"%d"`, n))
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		parts := f.parts[query.Get("uploadId")]
+		numbers := make([]int, 0, len(parts))
+		for n := range parts {
+			numbers = append(numbers, n)
+		}
+		sort.Ints(numbers)
+		var data []byte
+		for _, n := range numbers {
+			data = append(data, parts[n]...)
+		}
+		f.objects[key] = data
+		fmt.Fprintf(w, `<CompleteMultipartUploadResult><Bucket>bucket</Bucket><Key>%s</Key><ETag>"x"</ETag></CompleteMultipartUploadResult>`, key)
+	case r.Method == http.MethodPut:
+		f.objects[key] = body
+		f.headers[key] = r.Header.Clone()
+	default:
+		http.Error(w, "unexpected request", http.StatusNotImplemented)
+	}
+}
+
+// readBody reads a request body, decoding the aws-chunked encoding the SDK
+// uses to send trailing checksums
+func readBody(r *http.Request) ([]byte, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil || !strings.Contains(r.Header.Get("Content-Encoding"), "aws-chunked") {
+		return body, err
+	}
+	var data []byte
+	for {
+		line, rest, ok := bytes.Cut(body, []byte("\r\n"))
+		if !ok {
+			return nil, fmt.Errorf("truncated chunk")
+		}
+		sizeField, _, _ := bytes.Cut(line, []byte(";"))
+		size, err := strconv.ParseInt(string(sizeField), 16, 64)
+		if err != nil || int64(len(rest)) < size {
+			return nil, fmt.Errorf("invalid chunk size %q", line)
+		}
+		if size == 0 {
+			return data, nil
+		}
+		data = append(data, rest[:size]...)
+		body = bytes.TrimPrefix(rest[size:], []byte("\r\n"))
+	}
+}
+
+func TestS3StoreUnseekable(t *testing.T) {
+	until := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	opts := S3Options{
+		StorageClass:         "STANDARD_IA",
+		ServerSideEncryption: "aws:kms",
+		KMSKeyID:             "key-1",
+		Tags:                 map[string]string{"env": "prod"},
+		LockMode:             "COMPLIANCE",
+		RetainUntil:          until,
+	}
+
+	tests := []struct {
+		name string
+		size int
+	}{
+		{"single part", 1 << 20},
+		// Beyond one part, and with a partial last part
+		{"multipart", 40 << 20},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake, client := newFakeS3(t)
+			store := newS3Storage(client, "bucket", opts)
+			data := bytes.Repeat([]byte("0123456789abcdef"), tt.size/16)
+
+			if err := store.Store(context.Background(), "db/backup.dump", onlyReader{bytes.NewReader(data)}); err != nil {
+				t.Fatal(err)
+			}
+			if got := fake.objects["db/backup.dump"]; !bytes.Equal(got, data) {
+				t.Fatalf("stored %d bytes, want %d", len(got), len(data))
+			}
+
+			header := fake.headers["db/backup.dump"]
+			want := map[string]string{
+				"X-Amz-Storage-Class":                         "STANDARD_IA",
+				"X-Amz-Server-Side-Encryption":                "aws:kms",
+				"X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id": "key-1",
+				"X-Amz-Tagging":                               url.Values{"env": {"prod"}}.Encode(),
+				"X-Amz-Object-Lock-Mode":                      "COMPLIANCE",
+				"X-Amz-Object-Lock-Retain-Until-Date":         until.Format(time.RFC3339),
+			}
+			for name, value := range want {
+				if got := header.Get(name); got != value {
+					t.Errorf("%s is %q, want %q", name, got, value)
+				}
+			}
+		})
+	}
+}
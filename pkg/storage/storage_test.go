@@ -0,0 +1,100 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/yeboahd24/dbBackupUitility/pkg/backup"
+	"github.com/yeboahd24/dbBackupUitility/pkg/retry"
+)
+
+// retryTestPolicy fails at once, so tests never wait
+var retryTestPolicy = retry.Policy{MaxAttempts: 1}
+
+// memStorage is an in-memory StorageProvider whose operations can be made to
+// fail by object name
+type memStorage struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+
+	storeErr    func(name string) error
+	retrieveErr func(name string) error
+	deleteErr   func(name string) error
+}
+
+func newMemStorage() *memStorage {
+	return &memStorage{objects: make(map[string][]byte)}
+}
+
+func (m *memStorage) Store(ctx context.Context, name string, data io.Reader) error {
+	if m.storeErr != nil {
+		if err := m.storeErr(name); err != nil {
+			return err
+		}
+	}
+	b, err := io.ReadAll(data)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.objects[name] = b
+	return nil
+}
+
+func (m *memStorage) Retrieve(ctx context.Context, name string) (io.ReadCloser, error) {
+	if m.retrieveErr != nil {
+		if err := m.retrieveErr(name); err != nil {
+			return nil, err
+		}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, ok := m.objects[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", backup.ErrNotFound, name)
+	}
+	return io.NopCloser(bytes.NewReader(b)), nil
+}
+
+func (m *memStorage) List(ctx context.Context, prefix string) ([]backup.ObjectInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var objects []backup.ObjectInfo
+	for name, b := range m.objects {
+		if strings.HasPrefix(name, prefix) {
+			objects = append(objects, backup.ObjectInfo{Key: name, Size: int64(len(b))})
+		}
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	return objects, nil
+}
+
+func (m *memStorage) Delete(ctx context.Context, name string) error {
+	if m.deleteErr != nil {
+		if err := m.deleteErr(name); err != nil {
+			return err
+		}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.objects, name)
+	return nil
+}
+
+// names returns the names of the stored objects in order
+func (m *memStorage) names() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var names []string
+	for name := range m.objects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,40 @@
+package storage
+
+import (
+	"context"
+	"io"
+
+	"github.com/yeboahd24/dbBackupUitility/pkg/backup"
+	"github.com/yeboahd24/dbBackupUitility/pkg/throttle"
+)
+
+// ThrottleStorage limits the rate at which backups are uploaded to the
+// underlying storage. Downloads are not limited.
+type ThrottleStorage struct {
+	store    backup.StorageProvider
+	limiters []*throttle.Limiter
+}
+
+func NewThrottleStorage(store backup.StorageProvider, limiters ...*throttle.Limiter) *ThrottleStorage {
+	return &ThrottleStorage{store: store, limiters: limiters}
+}
+
+func (t *ThrottleStorage) Store(ctx context.Context, name string, data io.Reader) error {
+	return t.store.Store(ctx, name, throttle.Reader(ctx, data, t.limiters...))
+}
+
+func (t *ThrottleStorage) Replace(ctx context.Context, name string, data io.Reader) error {
+	return backup.Replace(ctx, t.store, name, throttle.Reader(ctx, data, t.limiters...))
+}
+
+func (t *ThrottleStorage) Retrieve(ctx context.Context, name string) (io.ReadCloser, error) {
+	return t.store.Retrieve(ctx, name)
+}
+
+func (t *ThrottleStorage) List(ctx context.Context, prefix string) ([]backup.ObjectInfo, error) {
+	return t.store.List(ctx, prefix)
+}
+
+func (t *ThrottleStorage) Delete(ctx context.Context, name string) error {
+	return t.store.Delete(ctx, name)
+}
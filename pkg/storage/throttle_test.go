@@ -0,0 +1,40 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/yeboahd24/dbBackupUitility/pkg/throttle"
+)
+
+func TestThrottleStorageLimitsUploads(t *testing.T) {
+	ctx := context.Background()
+	mem := newMemStorage()
+	store := NewThrottleStorage(mem, throttle.NewLimiter(1<<20), nil)
+	data := bytes.Repeat([]byte("x"), 768<<10)
+
+	start := time.Now()
+	if err := store.Store(ctx, "db.dump", bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Errorf("stored 768 KiB at 1 MiB/s in %s", elapsed)
+	}
+
+	start = time.Now()
+	rc, err := store.Retrieve(ctx, "db.dump")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	got, err := io.ReadAll(rc)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("retrieved %d bytes, %v, want the stored data", len(got), err)
+	}
+	if elapsed := time.Since(start); elapsed > 200*time.Millisecond {
+		t.Errorf("download was throttled, took %s", elapsed)
+	}
+}
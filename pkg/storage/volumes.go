@@ -0,0 +1,378 @@
+package storage
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/yeboahd24/dbBackupUitility/pkg/backup"
+)
+
+// partSuffix matches the numbered suffix of a volume, e.g. backup.dump.part001
+var partSuffix = regexp.MustCompile(`\.part(\d{3,})$`)
+
+// VolumeStorage splits every stored backup into numbered volumes of at most a
+// fixed size on the underlying storage, and transparently concatenates them
+// again on Retrieve. Manifests are stored unsplit.
+type VolumeStorage struct {
+	store backup.StorageProvider
+	name  string // destination name, under which manifests record the volumes
+	size  int64
+
+	mu       sync.Mutex
+	parts    map[string][]backup.Part
+	warnings map[string][]error
+}
+
+func NewVolumeStorage(store backup.StorageProvider, name string, size int64) *VolumeStorage {
+	return &VolumeStorage{
+		store:    store,
+		name:     name,
+		size:     size,
+		parts:    make(map[string][]backup.Part),
+		warnings: make(map[string][]error),
+	}
+}
+
+func partName(name string, n int) string {
+	return fmt.Sprintf("%s.part%03d", name, n)
+}
+
+func (v *VolumeStorage) Store(ctx context.Context, name string, data io.Reader) error {
+	return v.storeVolumes(ctx, name, data, false)
+}
+
+// Replace stores like Store, replacing existing volumes
+func (v *VolumeStorage) Replace(ctx context.Context, name string, data io.Reader) error {
+	return v.storeVolumes(ctx, name, data, true)
+}
+
+// put stores a single object on the underlying storage
+func (v *VolumeStorage) put(ctx context.Context, name string, data io.Reader, replace bool) error {
+	if replace {
+		return backup.Replace(ctx, v.store, name, data)
+	}
+	return v.store.Store(ctx, name, data)
+}
+
+func (v *VolumeStorage) storeVolumes(ctx context.Context, name string, data io.Reader, replace bool) error {
+	if backup.IsManifest(name) {
+		return v.put(ctx, name, data, replace)
+	}
+
+	if file, ok := data.(interface {
+		io.ReaderAt
+		io.Seeker
+	}); ok {
+		return v.storeSections(ctx, name, file, replace)
+	}
+
+	var parts []backup.Part
+	br := bufio.NewReader(data)
+	for n := 1; ; n++ {
+		if n > 1 {
+			if _, err := br.Peek(1); err == io.EOF {
+				break
+			} else if err != nil {
+				return v.finish(ctx, name, parts, fmt.Errorf("failed to read data: %w", err))
+			}
+		}
+
+		part := partName(name, n)
+		digest := backup.NewDigest(io.LimitReader(br, v.size))
+		if err := v.put(ctx, part, digest, replace); err != nil {
+			return v.finish(ctx, name, parts, fmt.Errorf("failed to store volume %s: %w", part, err))
+		}
+		parts = append(parts, backup.Part{Name: part, Size: digest.Size(), SHA256: digest.Sum()})
+	}
+	return v.finish(ctx, name, parts, nil)
+}
+
+// storeSections stores a file as independent sections, which can be rewound
+// so that the upload of a single volume can be retried
+func (v *VolumeStorage) storeSections(ctx context.Context, name string, file interface {
+	io.ReaderAt
+	io.Seeker
+}, replace bool) error {
+	start, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return fmt.Errorf("failed to read data: %w", err)
+	}
+	end, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return fmt.Errorf("failed to read data: %w", err)
+	}
+
+	var parts []backup.Part
+	for n, off := 1, start; n == 1 || off < end; n, off = n+1, off+v.size {
+		part := partName(name, n)
+		digest := newSectionDigest(io.NewSectionReader(file, off, min(v.size, end-off)))
+		if err := v.put(ctx, part, digest, replace); err != nil {
+			return v.finish(ctx, name, parts, fmt.Errorf("failed to store volume %s: %w", part, err))
+		}
+		parts = append(parts, backup.Part{Name: part, Size: digest.Size(), SHA256: digest.Sum()})
+	}
+	return v.finish(ctx, name, parts, nil)
+}
+
+// finish ends a Store that wrote parts. After a failure it deletes them, so no
+// partial backup is left behind. After a success it records them and deletes
+// the higher-numbered volumes of an earlier, larger backup of the same name,
+// keeping what fails for Warnings.
+func (v *VolumeStorage) finish(ctx context.Context, name string, parts []backup.Part, err error) error {
+	// Clean up even when the store failed because ctx was canceled
+	ctx = context.WithoutCancel(ctx)
+	if err != nil {
+		for _, part := range parts {
+			if delErr := v.store.Delete(ctx, part.Name); delErr != nil {
+				err = fmt.Errorf("%w; failed to delete volume %s: %v", err, part.Name, delErr)
+			}
+		}
+		return err
+	}
+
+	// Left over volumes are not read back, as the manifest lists the parts,
+	// so failing to delete them only wastes space
+	var warnings []error
+	stored, err := v.listParts(ctx, name)
+	if err != nil {
+		warnings = append(warnings, err)
+	}
+	for _, part := range stored {
+		if partNumber(part) <= len(parts) {
+			continue
+		}
+		if err := v.store.Delete(ctx, part); err != nil {
+			warnings = append(warnings, fmt.Errorf("failed to delete stale volume %s: %w", part, err))
+		}
+	}
+
+	v.mu.Lock()
+	v.parts[name] = parts
+	v.warnings[name] = warnings
+	v.mu.Unlock()
+	return nil
+}
+
+// sectionDigest hashes a volume while it is stored. Rewinding it for a retry
+// restarts the hash.
+type sectionDigest struct {
+	*backup.Digest
+	section *io.SectionReader
+}
+
+func newSectionDigest(section *io.SectionReader) *sectionDigest {
+	return &sectionDigest{Digest: backup.NewDigest(section), section: section}
+}
+
+func (d *sectionDigest) Seek(offset int64, whence int) (int64, error) {
+	if offset == 0 && whence == io.SeekCurrent {
+		return d.section.Seek(0, io.SeekCurrent)
+	}
+	if offset != 0 || whence != io.SeekStart {
+		return 0, errors.New("volume can only be rewound to its start")
+	}
+	d.Digest = backup.NewDigest(d.section)
+	return d.section.Seek(0, io.SeekStart)
+}
+
+// Parts returns the volumes written by the last Store of the named backup
+func (v *VolumeStorage) Parts(name string) []backup.Part {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.parts[name]
+}
+
+// Warnings returns the problems the last Store of the named backup met
+// deleting the volumes of an earlier, larger backup, which did not fail it
+func (v *VolumeStorage) Warnings(name string) []error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.warnings[name]
+}
+
+// Retrieve streams the volumes of a backup one after another, as listed by
+// the last Store or else by the manifest. Backups no manifest lists volumes
+// for, such as copies, are looked up by their volume names. Objects stored
+// before splitting was enabled are returned as is.
+func (v *VolumeStorage) Retrieve(ctx context.Context, name string) (io.ReadCloser, error) {
+	if backup.IsManifest(name) {
+		return v.store.Retrieve(ctx, name)
+	}
+
+	parts, err := v.manifestParts(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if parts == nil {
+		if parts, err = v.listParts(ctx, name); err != nil {
+			return nil, err
+		}
+	}
+	if len(parts) == 0 {
+		return v.store.Retrieve(ctx, name)
+	}
+	return &volumeReader{ctx: ctx, store: v.store, parts: parts}, nil
+}
+
+// manifestParts returns the volume names of a backup recorded by the last
+// Store or by its manifest for this destination, or nil if neither has them
+func (v *VolumeStorage) manifestParts(ctx context.Context, name string) ([]string, error) {
+	v.mu.Lock()
+	recorded := v.parts[name]
+	v.mu.Unlock()
+
+	if recorded == nil {
+		m, err := backup.ReadManifest(ctx, v.store, name)
+		if errors.Is(err, backup.ErrNotFound) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, dest := range m.Destinations {
+			if dest.Name == v.name {
+				recorded = dest.Parts
+			}
+		}
+	}
+
+	var parts []string
+	for _, part := range recorded {
+		parts = append(parts, part.Name)
+	}
+	return parts, nil
+}
+
+// List reports each split backup once, under its own name, with the total
+// size of its volumes
+func (v *VolumeStorage) List(ctx context.Context, prefix string) ([]backup.ObjectInfo, error) {
+	objects, err := v.store.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var merged []backup.ObjectInfo
+	index := make(map[string]int)
+	for _, obj := range objects {
+		loc := partSuffix.FindStringIndex(obj.Key)
+		if loc == nil {
+			merged = append(merged, obj)
+			continue
+		}
+
+		name := obj.Key[:loc[0]]
+		i, ok := index[name]
+		if !ok {
+			index[name] = len(merged)
+			merged = append(merged, backup.ObjectInfo{Key: name, ModTime: obj.ModTime})
+			i = len(merged) - 1
+		}
+		merged[i].Size += obj.Size
+		if obj.ModTime.After(merged[i].ModTime) {
+			merged[i].ModTime = obj.ModTime
+		}
+	}
+
+	sort.Slice(merged, func(i, j int) bool {
+		return merged[i].Key < merged[j].Key
+	})
+	return merged, nil
+}
+
+func (v *VolumeStorage) Delete(ctx context.Context, name string) error {
+	v.mu.Lock()
+	delete(v.parts, name)
+	delete(v.warnings, name)
+	v.mu.Unlock()
+
+	parts, err := v.listParts(ctx, name)
+	if err != nil {
+		return err
+	}
+	if len(parts) == 0 {
+		return v.store.Delete(ctx, name)
+	}
+	for _, part := range parts {
+		if err := v.store.Delete(ctx, part); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// listParts returns the volume names of a backup in order
+func (v *VolumeStorage) listParts(ctx context.Context, name string) ([]string, error) {
+	objects, err := v.store.List(ctx, name+".part")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list volumes of %s: %w", name, err)
+	}
+
+	var parts []string
+	for _, obj := range objects {
+		loc := partSuffix.FindStringIndex(obj.Key)
+		if loc != nil && obj.Key[:loc[0]] == name {
+			parts = append(parts, obj.Key)
+		}
+	}
+	// Volume numbers grow beyond their zero padding after 999 parts, so
+	// order numerically rather than by key
+	sort.Slice(parts, func(i, j int) bool {
+		return partNumber(parts[i]) < partNumber(parts[j])
+	})
+	return parts, nil
+}
+
+func partNumber(name string) int {
+	n, _ := strconv.Atoi(partSuffix.FindStringSubmatch(name)[1])
+	return n
+}
+
+// volumeReader concatenates volumes, opening each one only when needed
+type volumeReader struct {
+	ctx   context.Context
+	store backup.StorageProvider
+	parts []string
+	cur   io.ReadCloser
+}
+
+func (r *volumeReader) Read(p []byte) (int, error) {
+	for {
+		if r.cur == nil {
+			if len(r.parts) == 0 {
+				return 0, io.EOF
+			}
+			rc, err := r.store.Retrieve(r.ctx, r.parts[0])
+			if err != nil {
+				return 0, fmt.Errorf("failed to retrieve volume %s: %w", r.parts[0], err)
+			}
+			r.cur = rc
+			r.parts = r.parts[1:]
+		}
+
+		n, err := r.cur.Read(p)
+		if err == io.EOF {
+			r.cur.Close()
+			r.cur = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+func (r *volumeReader) Close() error {
+	r.parts = nil
+	if r.cur != nil {
+		return r.cur.Close()
+	}
+	return nil
+}
@@ -0,0 +1,228 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/yeboahd24/dbBackupUitility/pkg/backup"
+)
+
+// onlyReader hides the io.Seeker and io.ReaderAt of a reader, so that data is
+// stored as a stream
+type onlyReader struct{ io.Reader }
+
+func TestVolumeStorageSplits(t *testing.T) {
+	ctx := context.Background()
+	data := "0123456789"
+	tests := []struct {
+		name string
+		data io.Reader
+	}{
+		{"stream", onlyReader{strings.NewReader(data)}},
+		{"file", strings.NewReader(data)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mem := newMemStorage()
+			v := NewVolumeStorage(mem, "mem", 4)
+			if err := v.Store(ctx, "db.dump", tt.data); err != nil {
+				t.Fatal(err)
+			}
+
+			want := []string{"db.dump.part001", "db.dump.part002", "db.dump.part003"}
+			if got := mem.names(); !reflect.DeepEqual(got, want) {
+				t.Errorf("stored %v, want %v", got, want)
+			}
+			parts := v.Parts("db.dump")
+			if len(parts) != 3 || parts[2].Size != 2 || parts[0].SHA256 != sha256Of("0123") {
+				t.Errorf("Parts returned %+v", parts)
+			}
+			if got := readObject(t, v, "db.dump"); got != data {
+				t.Errorf("Retrieve returned %q, want %q", got, data)
+			}
+
+			objects, err := v.List(ctx, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(objects) != 1 || objects[0].Key != "db.dump" || objects[0].Size != int64(len(data)) {
+				t.Errorf("List returned %+v, want db.dump of %d bytes", objects, len(data))
+			}
+		})
+	}
+}
+
+func TestVolumeStorageEmpty(t *testing.T) {
+	mem := newMemStorage()
+	v := NewVolumeStorage(mem, "mem", 4)
+	if err := v.Store(context.Background(), "db.dump", onlyReader{strings.NewReader("")}); err != nil {
+		t.Fatal(err)
+	}
+	if got := mem.names(); len(got) != 1 || got[0] != "db.dump.part001" {
+		t.Errorf("stored %v, want a single empty volume", got)
+	}
+	if got := readObject(t, v, "db.dump"); got != "" {
+		t.Errorf("Retrieve returned %q, want nothing", got)
+	}
+}
+
+func TestVolumeStorageFailureDeletesParts(t *testing.T) {
+	for _, data := range []io.Reader{onlyReader{strings.NewReader("0123456789")}, strings.NewReader("0123456789")} {
+		mem := newMemStorage()
+		mem.storeErr = func(name string) error {
+			if strings.HasSuffix(name, ".part003") {
+				return errors.New("disk full")
+			}
+			return nil
+		}
+		v := NewVolumeStorage(mem, "mem", 4)
+		if err := v.Store(context.Background(), "db.dump", data); err == nil {
+			t.Fatal("Store succeeded despite a failed volume")
+		}
+		if got := mem.names(); len(got) != 0 {
+			t.Errorf("failed Store left %v behind", got)
+		}
+	}
+}
+
+func TestVolumeStorageFailureKeepsExistingParts(t *testing.T) {
+	ctx := context.Background()
+	local, err := NewLocalStorage(t.TempDir(), LocalOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := NewVolumeStorage(local, "local", 4)
+	if err := v.Store(ctx, "db.dump", strings.NewReader("0123456789")); err != nil {
+		t.Fatal(err)
+	}
+	// Refused by the no-clobber storage, which must not lose the first backup
+	if err := v.Store(ctx, "db.dump", strings.NewReader("abcdefghij")); !errors.Is(err, ErrExists) {
+		t.Fatalf("second Store returned %v, want ErrExists", err)
+	}
+	if got := readObject(t, v, "db.dump"); got != "0123456789" {
+		t.Errorf("Retrieve returned %q after a refused Store", got)
+	}
+}
+
+func TestVolumeStorageReplaceDeletesStaleParts(t *testing.T) {
+	ctx := context.Background()
+	mem := newMemStorage()
+	v := NewVolumeStorage(mem, "mem", 4)
+	if err := v.Store(ctx, "db.dump", strings.NewReader("0123456789")); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Replace(ctx, "db.dump", strings.NewReader("abcde")); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"db.dump.part001", "db.dump.part002"}
+	if got := mem.names(); !reflect.DeepEqual(got, want) {
+		t.Errorf("stored %v after a smaller backup, want %v", got, want)
+	}
+	if got := readObject(t, NewVolumeStorage(mem, "mem", 4), "db.dump"); got != "abcde" {
+		t.Errorf("Retrieve returned %q, want %q", got, "abcde")
+	}
+}
+
+func TestVolumeStorageStalePartWarnings(t *testing.T) {
+	ctx := context.Background()
+	mem := newMemStorage()
+	v := NewVolumeStorage(mem, "mem", 4)
+	if err := v.Store(ctx, "db.dump", strings.NewReader("0123456789")); err != nil {
+		t.Fatal(err)
+	}
+	mem.deleteErr = func(name string) error { return errors.New("access denied") }
+
+	// The stale volume is left behind, but the backup is stored
+	if err := v.Replace(ctx, "db.dump", strings.NewReader("abcde")); err != nil {
+		t.Fatal(err)
+	}
+	warnings := v.Warnings("db.dump")
+	if len(warnings) != 1 || !strings.Contains(warnings[0].Error(), "failed to delete stale volume db.dump.part003: access denied") {
+		t.Errorf("Warnings returned %v, want the failed delete of part003", warnings)
+	}
+	if got := readObject(t, v, "db.dump"); got != "abcde" {
+		t.Errorf("Retrieve returned %q, want %q", got, "abcde")
+	}
+
+	mem.deleteErr = nil
+	if err := v.Replace(ctx, "db.dump", strings.NewReader("abcde")); err != nil {
+		t.Fatal(err)
+	}
+	if warnings := v.Warnings("db.dump"); len(warnings) != 0 {
+		t.Errorf("Warnings returned %v after a clean Replace", warnings)
+	}
+}
+
+// A later run reads the volumes its destination's manifest entry lists, and
+// ignores any other object with a volume name
+func TestVolumeStorageRetrieveFollowsManifest(t *testing.T) {
+	ctx := context.Background()
+	mem := newMemStorage()
+	v := NewVolumeStorage(mem, "mem", 4)
+	if err := v.Store(ctx, "db.dump", strings.NewReader("0123456789")); err != nil {
+		t.Fatal(err)
+	}
+	m := &backup.Manifest{
+		Name: "db.dump",
+		Destinations: []backup.DestinationResult{
+			{Name: "other", OK: true},
+			{Name: "mem", OK: true, Parts: v.Parts("db.dump")},
+		},
+	}
+	if err := backup.WriteManifest(ctx, v, m); err != nil {
+		t.Fatal(err)
+	}
+	if err := mem.Store(ctx, "db.dump.part004", strings.NewReader("stray")); err != nil {
+		t.Fatal(err)
+	}
+
+	later := NewVolumeStorage(mem, "mem", 4)
+	if got := readObject(t, later, "db.dump"); got != "0123456789" {
+		t.Errorf("Retrieve returned %q, want %q", got, "0123456789")
+	}
+}
+
+func TestVolumeStorageUnsplitObject(t *testing.T) {
+	ctx := context.Background()
+	mem := newMemStorage()
+	if err := mem.Store(ctx, "old.dump", strings.NewReader("stored before splitting")); err != nil {
+		t.Fatal(err)
+	}
+	v := NewVolumeStorage(mem, "mem", 4)
+	if got := readObject(t, v, "old.dump"); got != "stored before splitting" {
+		t.Errorf("Retrieve returned %q", got)
+	}
+	if err := v.Delete(ctx, "old.dump"); err != nil {
+		t.Fatal(err)
+	}
+	if got := mem.names(); len(got) != 0 {
+		t.Errorf("Delete left %v behind", got)
+	}
+}
+
+func TestVolumeStorageManyParts(t *testing.T) {
+	// Volume numbers outgrow their padding after 999 parts
+	ctx := context.Background()
+	dir := t.TempDir()
+	local, err := NewLocalStorage(dir, LocalOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := bytes.Repeat([]byte("0123456789"), 101)
+	if err := NewVolumeStorage(local, "local", 1).Store(ctx, "db.dump", bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "db.dump.part1010")); err != nil {
+		t.Fatal(err)
+	}
+	if got := readObject(t, NewVolumeStorage(local, "local", 1), "db.dump"); got != string(data) {
+		t.Error("volumes beyond 999 were reassembled out of order")
+	}
+}
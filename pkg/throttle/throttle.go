@@ -0,0 +1,64 @@
+package throttle
+
+import (
+	"context"
+	"io"
+
+	"golang.org/x/time/rate"
+)
+
+// maxBurst bounds how many bytes may be sent at once after an idle period
+const maxBurst = 256 << 10
+
+// Limiter limits a byte rate. A Limiter shared by several readers limits
+// their combined rate.
+type Limiter struct {
+	limiter *rate.Limiter
+}
+
+// NewLimiter returns a limiter allowing bytesPerSec bytes per second
+func NewLimiter(bytesPerSec int64) *Limiter {
+	burst := int(min(bytesPerSec, maxBurst))
+	return &Limiter{limiter: rate.NewLimiter(rate.Limit(bytesPerSec), burst)}
+}
+
+// Reader limits reads from r to the rate of every given limiter. Nil
+// limiters are ignored, and r is returned as is when none remain.
+func Reader(ctx context.Context, r io.Reader, limiters ...*Limiter) io.Reader {
+	var active []*Limiter
+	for _, l := range limiters {
+		if l != nil {
+			active = append(active, l)
+		}
+	}
+	if len(active) == 0 {
+		return r
+	}
+	return &reader{ctx: ctx, r: r, limiters: active}
+}
+
+type reader struct {
+	ctx      context.Context
+	r        io.Reader
+	limiters []*Limiter
+}
+
+func (t *reader) Read(p []byte) (int, error) {
+	// Never read more than the smallest burst, so waiting for the bytes
+	// read is always possible
+	for _, l := range t.limiters {
+		if burst := l.limiter.Burst(); len(p) > burst {
+			p = p[:burst]
+		}
+	}
+
+	n, err := t.r.Read(p)
+	if n > 0 {
+		for _, l := range t.limiters {
+			if waitErr := l.limiter.WaitN(t.ctx, n); waitErr != nil {
+				return n, waitErr
+			}
+		}
+	}
+	return n, err
+}
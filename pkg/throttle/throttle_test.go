@@ -0,0 +1,83 @@
+package throttle
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReaderLimitsRate(t *testing.T) {
+	data := bytes.Repeat([]byte("x"), 768<<10)
+	start := time.Now()
+	got, err := io.ReadAll(Reader(context.Background(), bytes.NewReader(data), NewLimiter(1<<20)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("throttled data differs from the source")
+	}
+	// The first 256 KiB pass as a burst, the other 512 KiB take half a second
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Errorf("read 768 KiB at 1 MiB/s in %s", elapsed)
+	}
+}
+
+func TestReaderSharedLimiter(t *testing.T) {
+	limiter := NewLimiter(1 << 20)
+	start := time.Now()
+	done := make(chan error, 2)
+	for range 2 {
+		go func() {
+			_, err := io.Copy(io.Discard, Reader(context.Background(), bytes.NewReader(make([]byte, 384<<10)), limiter))
+			done <- err
+		}()
+	}
+	for range 2 {
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Together the readers are held to the shared rate
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Errorf("read 2 x 384 KiB at a shared 1 MiB/s in %s", elapsed)
+	}
+}
+
+func TestReaderBurst(t *testing.T) {
+	r := Reader(context.Background(), strings.NewReader(strings.Repeat("x", 1000)), NewLimiter(100), NewLimiter(1<<20))
+	// Reads are cut to the smallest burst, so that the limiter can grant them
+	n, err := r.Read(make([]byte, 1000))
+	if err != nil || n != 100 {
+		t.Errorf("Read returned %d, %v, want 100 bytes", n, err)
+	}
+}
+
+func TestReaderCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	r := Reader(ctx, strings.NewReader(strings.Repeat("x", 1000)), NewLimiter(10))
+	if _, err := r.Read(make([]byte, 10)); err != nil {
+		t.Fatal(err)
+	}
+
+	// The burst is spent, so the next read waits until it is canceled
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	_, err := r.Read(make([]byte, 10))
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Read returned %v, want context.Canceled", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("canceled Read returned after %s", elapsed)
+	}
+}
+
+func TestReaderWithoutLimiters(t *testing.T) {
+	src := strings.NewReader("data")
+	if r := Reader(context.Background(), src, nil, nil); r != io.Reader(src) {
+		t.Error("Reader without limiters wrapped the source")
+	}
+}